@@ -0,0 +1,467 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client ...
+type Client interface {
+	Sign(domains []string) (crt, key []byte, err error)
+}
+
+// Resolver answers the http-01 challenges of the CA
+type Resolver interface {
+	SetToken(token, keyAuth string) error
+	ClearToken(token string)
+}
+
+// ClientOptions ...
+type ClientOptions struct {
+	Endpoint    string
+	Emails      string
+	TermsAgreed bool
+	AccountKey  *ecdsa.PrivateKey
+	Resolver    Resolver
+	HTTPClient  *http.Client
+	// PollInterval and PollTimeout configure the wait of
+	// authorizations and orders, defaults to 1s and 2m
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+}
+
+// NewClient creates an ACME v2 client, see RFC 8555. The account is created
+// or recovered, using the account key, on the first call to Sign.
+func NewClient(options *ClientOptions) Client {
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	pollInterval := options.PollInterval
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	}
+	pollTimeout := options.PollTimeout
+	if pollTimeout == 0 {
+		pollTimeout = 2 * time.Minute
+	}
+	return &client{
+		options:      options,
+		httpClient:   httpClient,
+		pollInterval: pollInterval,
+		pollTimeout:  pollTimeout,
+	}
+}
+
+type client struct {
+	options      *ClientOptions
+	httpClient   *http.Client
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+	directory    *directory
+	nonce        string
+	kid          string
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string       `json:"status"`
+	Identifier identifier   `json:"identifier"`
+	Challenges []*challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *problem `json:"error"`
+}
+
+const badNonce = "urn:ietf:params:acme:error:badNonce"
+
+func (c *client) Sign(domains []string) (crt, key []byte, err error) {
+	if len(domains) == 0 {
+		return nil, nil, fmt.Errorf("missing domains")
+	}
+	if err := c.ensureAccount(); err != nil {
+		return nil, nil, err
+	}
+	var o order
+	ids := make([]identifier, len(domains))
+	for i, domain := range domains {
+		ids[i] = identifier{Type: "dns", Value: domain}
+	}
+	resp, err := c.post(c.directory.NewOrder, map[string]interface{}{"identifiers": ids}, &o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating order: %v", err)
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(authzURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	certKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, certKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := c.post(o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return nil, nil, fmt.Errorf("error finalizing order: %v", err)
+	}
+	if err := c.poll(orderURL, &o, func() (bool, error) {
+		switch o.Status {
+		case "valid":
+			return true, nil
+		case "invalid":
+			return false, fmt.Errorf("order is invalid: %v", o.Error)
+		}
+		return false, nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	resp, err = c.post(o.Certificate, nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error downloading certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	crt, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(certKey)})
+	return crt, key, nil
+}
+
+func (c *client) authorize(authzURL string) error {
+	var authz authorization
+	if _, err := c.post(authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("error reading authorization: %v", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	var chall *challenge
+	for _, ch := range authz.Challenges {
+		if ch.Type == "http-01" {
+			chall = ch
+			break
+		}
+	}
+	if chall == nil {
+		return fmt.Errorf("http-01 challenge not found for domain '%s'", authz.Identifier.Value)
+	}
+	keyAuth := chall.Token + "." + c.thumbprint()
+	if err := c.options.Resolver.SetToken(chall.Token, keyAuth); err != nil {
+		return fmt.Errorf("error answering challenge of domain '%s': %v", authz.Identifier.Value, err)
+	}
+	defer c.options.Resolver.ClearToken(chall.Token)
+	if _, err := c.post(chall.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("error accepting challenge of domain '%s': %v", authz.Identifier.Value, err)
+	}
+	return c.poll(authzURL, &authz, func() (bool, error) {
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "pending", "processing":
+			return false, nil
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == "http-01" && ch.Error != nil {
+				return false, fmt.Errorf("authorization of domain '%s' is %s: %v", authz.Identifier.Value, authz.Status, ch.Error)
+			}
+		}
+		return false, fmt.Errorf("authorization of domain '%s' is %s", authz.Identifier.Value, authz.Status)
+	})
+}
+
+func (c *client) poll(url string, v interface{}, done func() (bool, error)) error {
+	timeout := time.Now().Add(c.pollTimeout)
+	for {
+		if ok, err := done(); ok || err != nil {
+			return err
+		}
+		if time.Now().After(timeout) {
+			return fmt.Errorf("timeout waiting for '%s'", url)
+		}
+		time.Sleep(c.pollInterval)
+		if _, err := c.post(url, nil, v); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *client) ensureAccount() error {
+	if c.kid != "" {
+		return nil
+	}
+	if c.directory == nil {
+		resp, err := c.httpClient.Get(c.options.Endpoint)
+		if err != nil {
+			return fmt.Errorf("error reading directory: %v", err)
+		}
+		defer resp.Body.Close()
+		var dir directory
+		if err := readResponse(resp, &dir); err != nil {
+			return fmt.Errorf("error reading directory: %v", err)
+		}
+		c.directory = &dir
+	}
+	account := map[string]interface{}{
+		"termsOfServiceAgreed": c.options.TermsAgreed,
+	}
+	var contact []string
+	for _, email := range strings.Split(c.options.Emails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			contact = append(contact, "mailto:"+email)
+		}
+	}
+	if len(contact) > 0 {
+		account["contact"] = contact
+	}
+	resp, err := c.post(c.directory.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("error creating account: %v", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("missing account URL")
+	}
+	return nil
+}
+
+// post sends a JWS signed request. A nil payload sends a POST-as-GET request.
+// The response body is read to v if v is not nil, otherwise the caller should
+// close the body of the response.
+func (c *client) post(url string, payload, v interface{}) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for retry := 0; retry < 2; retry++ {
+		resp, err = c.doPost(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 400 {
+			break
+		}
+		prob := &problem{}
+		readResponse(resp, prob)
+		resp.Body.Close()
+		if prob.Type != badNonce || retry > 0 {
+			return nil, fmt.Errorf("%s (status %d)", prob, resp.StatusCode)
+		}
+	}
+	if v != nil {
+		defer resp.Body.Close()
+		if err := readResponse(resp, v); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (c *client) doPost(url string, payload interface{}) (*http.Response, error) {
+	nonce, err := c.fetchNonce()
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.jws(url, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	return resp, nil
+}
+
+func (c *client) fetchNonce() (string, error) {
+	if nonce := c.nonce; nonce != "" {
+		c.nonce = ""
+		return nonce, nil
+	}
+	resp, err := c.httpClient.Head(c.directory.NewNonce)
+	if err != nil {
+		return "", fmt.Errorf("error reading nonce: %v", err)
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("missing nonce")
+	}
+	return nonce, nil
+}
+
+func (c *client) jws(url, nonce string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if payload != nil {
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	signingInput := encode(header) + "." + encode(data)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.options.AccountKey, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	// ES256 signature is the concatenation of r and s, 32 bytes each
+	sig := make([]byte, 64)
+	copyPadded(sig[:32], r)
+	copyPadded(sig[32:], s)
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   encode(data),
+		"signature": encode(sig),
+	})
+}
+
+func (c *client) jwk() map[string]string {
+	pub := c.options.AccountKey.PublicKey
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	copyPadded(x, pub.X)
+	copyPadded(y, pub.Y)
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   encode(x),
+		"y":   encode(y),
+	}
+}
+
+// thumbprint implements RFC 7638, json.Marshal sorts the keys of the map
+func (c *client) thumbprint() string {
+	jwk, _ := json.Marshal(c.jwk())
+	hash := crypto.SHA256.New()
+	hash.Write(jwk)
+	return encode(hash.Sum(nil))
+}
+
+func readResponse(resp *http.Response, v interface{}) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		if _, isProblem := v.(*problem); !isProblem {
+			return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(data))
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+func copyPadded(dst []byte, n *big.Int) {
+	b := n.Bytes()
+	copy(dst[len(dst)-len(b):], b)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// NewAccountKey ...
+func NewAccountKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// EncodeAccountKey ...
+func EncodeAccountKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecodeAccountKey ...
+func DecodeAccountKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid account key")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,554 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestSign(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.Close()
+	logger := &types_helper.LoggerMock{T: t}
+	resolver := NewServer(logger, "", nil)
+	ca.resolver = resolver.(http.Handler)
+	accountKey, _ := NewAccountKey()
+	client := NewClient(&ClientOptions{
+		Endpoint:     ca.URL + "/dir",
+		Emails:       "admin@example.com",
+		TermsAgreed:  true,
+		AccountKey:   accountKey,
+		Resolver:     resolver,
+		PollInterval: time.Millisecond,
+	})
+	crtPEM, keyPEM, err := client.Sign([]string{"d1.local", "www.d1.local"})
+	if err != nil {
+		t.Fatalf("error signing certificate: %v", err)
+	}
+	crt := parseCert(t, crtPEM)
+	if !reflect.DeepEqual(crt.DNSNames, []string{"d1.local", "www.d1.local"}) {
+		t.Errorf("unexpected DNS names: %v", crt.DNSNames)
+	}
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "RSA PRIVATE KEY" {
+		t.Errorf("unexpected private key: %s", string(keyPEM))
+	}
+	if ca.contact != "mailto:admin@example.com" {
+		t.Errorf("unexpected account contact: %s", ca.contact)
+	}
+	if ca.badNonces != 1 {
+		t.Errorf("expected one badNonce retry but was %d", ca.badNonces)
+	}
+	if len(resolver.(*server).tokens) > 0 {
+		t.Errorf("expected tokens to be cleared: %v", resolver.(*server).tokens)
+	}
+	logger.CompareLogging(`
+INFO acme: answering challenge of host 'd1.local'
+INFO acme: answering challenge of host 'www.d1.local'`)
+}
+
+func TestSignInvalidChallenge(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.Close()
+	logger := &types_helper.LoggerMock{T: t}
+	ca.resolver = http.NotFoundHandler()
+	accountKey, _ := NewAccountKey()
+	client := NewClient(&ClientOptions{
+		Endpoint:     ca.URL + "/dir",
+		TermsAgreed:  true,
+		AccountKey:   accountKey,
+		Resolver:     NewServer(logger, "", nil),
+		PollInterval: time.Millisecond,
+	})
+	_, _, err := client.Sign([]string{"d1.local"})
+	expected := "authorization of domain 'd1.local' is invalid: urn:ietf:params:acme:error:unauthorized: invalid key authorization"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error '%s' but was '%v'", expected, err)
+	}
+}
+
+func TestSignerVerify(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.Close()
+	logger := &types_helper.LoggerMock{T: t}
+	cache := &cacheMock{secrets: map[string]map[string][]byte{}}
+	store := NewSecretTokenStore(cache, "ingress/acme-tokens")
+	server := NewServer(logger, "", store)
+	// challenges are answered by another replica, which reads the tokens from the store
+	ca.resolver = NewServer(logger, "", store).(http.Handler)
+	leader := false
+	s := NewSigner(&SignerOptions{
+		Logger:        logger,
+		Cache:         cache,
+		Resolver:      server,
+		AccountSecret: "ingress/acme-account",
+		IsLeader:      func() bool { return leader },
+	}).(*signer)
+	s.pollInterval = time.Millisecond
+
+	acme := &hatypes.AcmeConfig{
+		Enabled:     true,
+		Endpoint:    ca.URL + "/dir",
+		Expiring:    30 * 24 * time.Hour,
+		TermsAgreed: true,
+	}
+	acme.AddDomains("default/tls1", []string{"d1.local"})
+	s.Notify(acme)
+	s.verifyAll()
+	if _, found := cache.secrets["default/tls1"]; found {
+		t.Errorf("expected certificate not requested by a replica which is not the leader")
+	}
+	logger.CompareLogging(`
+INFO-V(2) acme: skipping verification of the certificates, this controller is not the leader`)
+
+	leader = true
+	s.verifyAll()
+	if tokens := cache.secrets["ingress/acme-tokens"]; len(tokens) > 0 {
+		t.Errorf("expected tokens to be cleared: %v", tokens)
+	}
+	if crt := parseCert(t, cache.secrets["default/tls1"]["tls.crt"]); !reflect.DeepEqual(crt.DNSNames, []string{"d1.local"}) {
+		t.Errorf("unexpected DNS names: %v", crt.DNSNames)
+	}
+	if _, found := cache.secrets["ingress/acme-account"]["account.key"]; !found {
+		t.Errorf("expected account key to be stored")
+	}
+	logger.CompareLogging(`
+INFO acme: requesting certificate of secret 'default/tls1' domain(s) 'd1.local': certificate not found
+INFO acme: new account key stored in secret 'ingress/acme-account'
+INFO acme: answering challenge of host 'd1.local'
+INFO acme: new certificate issued to secret 'default/tls1' domain(s) 'd1.local'`)
+
+	s.verifyAll()
+	logger.CompareLogging(`
+INFO-V(2) acme: certificate of secret 'default/tls1' is up to date`)
+
+	acme.AddDomains("default/tls1", []string{"www.d1.local"})
+	s.Notify(acme)
+	s.verifyAll()
+	logger.CompareLogging(`
+INFO acme: requesting certificate of secret 'default/tls1' domain(s) 'd1.local,www.d1.local': certificate does not have domain 'www.d1.local'
+INFO acme: answering challenge of host 'd1.local'
+INFO acme: answering challenge of host 'www.d1.local'
+INFO acme: new certificate issued to secret 'default/tls1' domain(s) 'd1.local,www.d1.local'`)
+
+	ca.validity = 10 * 24 * time.Hour
+	cache.secrets["default/tls1"] = nil
+	s.verifyAll()
+	expires := parseCert(t, cache.secrets["default/tls1"]["tls.crt"]).NotAfter.Format(time.RFC3339)
+	s.verifyAll()
+	logger.CompareLogging(`
+INFO acme: requesting certificate of secret 'default/tls1' domain(s) 'd1.local,www.d1.local': certificate not found
+INFO acme: answering challenge of host 'd1.local'
+INFO acme: answering challenge of host 'www.d1.local'
+INFO acme: new certificate issued to secret 'default/tls1' domain(s) 'd1.local,www.d1.local'
+INFO acme: requesting certificate of secret 'default/tls1' domain(s) 'd1.local,www.d1.local': certificate expires at ` + expires + `
+INFO acme: answering challenge of host 'd1.local'
+INFO acme: answering challenge of host 'www.d1.local'
+INFO acme: new certificate issued to secret 'default/tls1' domain(s) 'd1.local,www.d1.local'`)
+
+	if ca.accounts != 1 {
+		t.Errorf("expected one account request but was %d", ca.accounts)
+	}
+}
+
+func TestServer(t *testing.T) {
+	logger := &types_helper.LoggerMock{T: t}
+	server := NewServer(logger, "", nil)
+	server.SetToken("abc", "abc.123")
+	testCase := []struct {
+		path     string
+		expCode  int
+		expBody  string
+		expLog   string
+		clearTkn bool
+	}{
+		// 0
+		{
+			path:    "/.well-known/acme-challenge/abc",
+			expCode: 200,
+			expBody: "abc.123",
+			expLog:  "INFO acme: answering challenge of host 'd1.local'",
+		},
+		// 1
+		{
+			path:    "/.well-known/acme-challenge/abcd",
+			expCode: 404,
+			expLog:  "WARN acme: token not found: abcd",
+		},
+		// 2
+		{
+			path:    "/app",
+			expCode: 404,
+		},
+		// 3
+		{
+			path:     "/.well-known/acme-challenge/abc",
+			expCode:  404,
+			expLog:   "WARN acme: token not found: abc",
+			clearTkn: true,
+		},
+	}
+	for i, test := range testCase {
+		if test.clearTkn {
+			server.ClearToken("abc")
+		}
+		w := httptest.NewRecorder()
+		server.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "http://d1.local"+test.path, nil))
+		if w.Code != test.expCode {
+			t.Errorf("expected status %d on %d but was %d", test.expCode, i, w.Code)
+		}
+		if test.expCode == 200 && w.Body.String() != test.expBody {
+			t.Errorf("expected body '%s' on %d but was '%s'", test.expBody, i, w.Body.String())
+		}
+		logger.CompareLogging(test.expLog)
+	}
+}
+
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ *
+ *  BUILDERS
+ *
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type cacheMock struct {
+	secrets map[string]map[string][]byte
+}
+
+func (c *cacheMock) GetSecretContent(secretName, keyName string) ([]byte, error) {
+	if data, found := c.secrets[secretName][keyName]; found {
+		return data, nil
+	}
+	return nil, fmt.Errorf("secret not found: '%s'", secretName)
+}
+
+func (c *cacheMock) UpdateSecret(secretName, secretType string, update func(data map[string][]byte) bool) error {
+	data := map[string][]byte{}
+	for key, value := range c.secrets[secretName] {
+		data[key] = value
+	}
+	if update(data) {
+		c.secrets[secretName] = data
+	}
+	return nil
+}
+
+func (c *cacheMock) CreateOrUpdateSecret(secretName, secretType string, data map[string][]byte) error {
+	secret := c.secrets[secretName]
+	if secret == nil {
+		secret = map[string][]byte{}
+		c.secrets[secretName] = secret
+	}
+	for key, value := range data {
+		secret[key] = value
+	}
+	return nil
+}
+
+func parseCert(t *testing.T, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("invalid certificate: %s", string(data))
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return crt
+}
+
+// fakeCA implements a very small subset of a RFC 8555 server, the same
+// flow of a local ACME test server like pebble: signatures and nonces are
+// verified, challenges are validated against the resolver and the first
+// order is refused with badNonce in order to test the retry
+type fakeCA struct {
+	*httptest.Server
+	t         *testing.T
+	mutex     sync.Mutex
+	resolver  http.Handler
+	validity  time.Duration
+	nonces    map[string]bool
+	nonceSeq  int
+	badNonces int
+	accounts  int
+	contact   string
+	jwk       map[string]string
+	key       *ecdsa.PublicKey
+	caKey     *ecdsa.PrivateKey
+	caCrt     *x509.Certificate
+	domains   []string
+	valid     map[string]bool
+	certPEM   []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{
+		t:        t,
+		validity: 90 * 24 * time.Hour,
+		nonces:   map[string]bool{},
+	}
+	ca.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &ca.caKey.PublicKey, ca.caKey)
+	ca.caCrt, _ = x509.ParseCertificate(der)
+	ca.Server = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	return ca
+}
+
+func (ca *fakeCA) newNonce(w http.ResponseWriter) {
+	ca.nonceSeq++
+	nonce := fmt.Sprintf("nonce%d", ca.nonceSeq)
+	ca.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+func (ca *fakeCA) problem(w http.ResponseWriter, status int, probType, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"type":   "urn:ietf:params:acme:error:" + probType,
+		"detail": detail,
+	})
+}
+
+func (ca *fakeCA) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (ca *fakeCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	url := ca.URL + r.URL.Path
+	switch {
+	case r.URL.Path == "/dir":
+		ca.reply(w, 200, map[string]string{
+			"newNonce":   ca.URL + "/nonce",
+			"newAccount": ca.URL + "/account",
+			"newOrder":   ca.URL + "/order",
+		})
+		return
+	case r.URL.Path == "/nonce":
+		ca.newNonce(w)
+		return
+	}
+	payload, protected, err := ca.readJWS(r)
+	ca.newNonce(w)
+	if err != nil {
+		ca.problem(w, 400, "malformed", err.Error())
+		return
+	}
+	if protected["url"] != url {
+		ca.problem(w, 400, "malformed", "url mismatch")
+		return
+	}
+	if !ca.nonces[protected["nonce"].(string)] {
+		ca.problem(w, 400, "badNonce", "invalid nonce")
+		return
+	}
+	delete(ca.nonces, protected["nonce"].(string))
+	switch {
+	case r.URL.Path == "/account":
+		var account struct {
+			Contact     []string `json:"contact"`
+			TermsAgreed bool     `json:"termsOfServiceAgreed"`
+		}
+		json.Unmarshal(payload, &account)
+		if !account.TermsAgreed {
+			ca.problem(w, 403, "userActionRequired", "terms of service should be agreed")
+			return
+		}
+		ca.accounts++
+		ca.contact = strings.Join(account.Contact, ",")
+		w.Header().Set("Location", ca.URL+"/account/1")
+		ca.reply(w, 201, map[string]string{"status": "valid"})
+	case r.URL.Path == "/order":
+		if ca.badNonces == 0 {
+			// simulates a nonce rejected by the server
+			ca.badNonces++
+			ca.problem(w, 400, "badNonce", "stale nonce")
+			return
+		}
+		var order struct {
+			Identifiers []identifier `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &order)
+		ca.domains = nil
+		ca.valid = map[string]bool{}
+		var authz []string
+		for i, id := range order.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+			authz = append(authz, fmt.Sprintf("%s/authz/%d", ca.URL, i))
+		}
+		w.Header().Set("Location", ca.URL+"/order/1")
+		ca.reply(w, 201, map[string]interface{}{
+			"status":         "pending",
+			"authorizations": authz,
+			"finalize":       ca.URL + "/finalize",
+		})
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		domain, status := ca.authz(r.URL.Path)
+		chall := map[string]interface{}{
+			"type":  "http-01",
+			"url":   ca.URL + "/chall/" + strings.TrimPrefix(r.URL.Path, "/authz/"),
+			"token": "token-" + domain,
+		}
+		if status == "invalid" {
+			chall["error"] = map[string]string{
+				"type":   "urn:ietf:params:acme:error:unauthorized",
+				"detail": "invalid key authorization",
+			}
+		}
+		ca.reply(w, 200, map[string]interface{}{
+			"status":     status,
+			"identifier": identifier{Type: "dns", Value: domain},
+			"challenges": []interface{}{
+				map[string]string{"type": "dns-01", "url": ca.URL + "/chall/dns", "token": "dns"},
+				chall,
+			},
+		})
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		domain, _ := ca.authz(strings.Replace(r.URL.Path, "/chall/", "/authz/", 1))
+		token := "token-" + domain
+		rec := httptest.NewRecorder()
+		ca.resolver.ServeHTTP(rec, httptest.NewRequest("GET", "http://"+domain+ChallengePrefix+token, nil))
+		ca.valid[domain] = rec.Code == 200 && rec.Body.String() == token+"."+ca.thumbprint()
+		ca.reply(w, 200, map[string]string{"status": "processing"})
+	case r.URL.Path == "/finalize":
+		var finalize struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &finalize)
+		der, _ := base64.RawURLEncoding.DecodeString(finalize.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.problem(w, 400, "badCSR", err.Error())
+			return
+		}
+		names := append([]string{}, csr.DNSNames...)
+		sort.Strings(names)
+		domains := append([]string{}, ca.domains...)
+		sort.Strings(domains)
+		if !reflect.DeepEqual(names, domains) {
+			ca.problem(w, 400, "badCSR", "domains mismatch")
+			return
+		}
+		crtTemplate := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(ca.validity),
+		}
+		crt, _ := x509.CreateCertificate(rand.Reader, crtTemplate, ca.caCrt, csr.PublicKey, ca.caKey)
+		ca.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt})
+		ca.reply(w, 200, map[string]string{"status": "processing"})
+	case r.URL.Path == "/order/1":
+		ca.reply(w, 200, map[string]string{
+			"status":      "valid",
+			"certificate": ca.URL + "/cert/1",
+		})
+	case r.URL.Path == "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.certPEM)
+	default:
+		ca.problem(w, 404, "malformed", "not found")
+	}
+}
+
+func (ca *fakeCA) authz(path string) (domain, status string) {
+	var i int
+	fmt.Sscanf(path, "/authz/%d", &i)
+	domain = ca.domains[i]
+	valid, found := ca.valid[domain]
+	if !found {
+		return domain, "pending"
+	}
+	if valid {
+		return domain, "valid"
+	}
+	return domain, "invalid"
+}
+
+func (ca *fakeCA) thumbprint() string {
+	jwk, _ := json.Marshal(ca.jwk)
+	hash := sha256.Sum256(jwk)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (ca *fakeCA) readJWS(r *http.Request) (payload []byte, protected map[string]interface{}, err error) {
+	body, _ := ioutil.ReadAll(r.Body)
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, nil, err
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, nil, err
+	}
+	if jwk, found := protected["jwk"]; found {
+		ca.jwk = map[string]string{}
+		for k, v := range jwk.(map[string]interface{}) {
+			ca.jwk[k] = v.(string)
+		}
+		x, _ := base64.RawURLEncoding.DecodeString(ca.jwk["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(ca.jwk["y"])
+		ca.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected["kid"] != ca.URL+"/account/1" {
+		return nil, nil, fmt.Errorf("unknown account")
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(ca.key, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, nil, fmt.Errorf("invalid signature")
+	}
+	payload, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, protected, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// ChallengePrefix is the path prefix of the http-01 challenge, see RFC 8555 section 8.3
+const ChallengePrefix = "/.well-known/acme-challenge/"
+
+// Server ...
+type Server interface {
+	Resolver
+	Listen(stopCh <-chan struct{}) error
+}
+
+// TokenStore shares the tokens of the http-01 challenges with the other
+// replicas of the controller, which can receive the challenge requests
+type TokenStore interface {
+	SetToken(token, keyAuth string) error
+	ClearToken(token string) error
+	GetToken(token string) (string, error)
+}
+
+// TokenCache ...
+type TokenCache interface {
+	GetSecretContent(secretName, keyName string) ([]byte, error)
+	// UpdateSecret reads the secret from the API server and calls update with
+	// its current data, an empty map if the secret doesn't exist. update
+	// changes data in place and returns true if the secret should be written.
+	UpdateSecret(secretName, secretType string, update func(data map[string][]byte) bool) error
+}
+
+// NewSecretTokenStore creates a TokenStore which stores the tokens as
+// keys of a secret. Tokens are written to the API server and read from
+// the cache, so every replica watching the secret answers the challenges.
+func NewSecretTokenStore(cache TokenCache, secretName string) TokenStore {
+	return &secretTokenStore{
+		cache:      cache,
+		secretName: secretName,
+	}
+}
+
+type secretTokenStore struct {
+	cache      TokenCache
+	secretName string
+}
+
+func (s *secretTokenStore) SetToken(token, keyAuth string) error {
+	return s.cache.UpdateSecret(s.secretName, "Opaque", func(data map[string][]byte) bool {
+		data[token] = []byte(keyAuth)
+		return true
+	})
+}
+
+func (s *secretTokenStore) ClearToken(token string) error {
+	return s.cache.UpdateSecret(s.secretName, "Opaque", func(data map[string][]byte) bool {
+		if _, found := data[token]; !found {
+			return false
+		}
+		delete(data, token)
+		return true
+	})
+}
+
+func (s *secretTokenStore) GetToken(token string) (string, error) {
+	keyAuth, err := s.cache.GetSecretContent(s.secretName, token)
+	return string(keyAuth), err
+}
+
+// NewServer creates the http server that answers the http-01 challenges.
+// HAProxy forwards the challenge requests to the unix socket. Tokens are
+// also written to store if assigned, and tokens not found in memory, e.g.
+// requested by another replica, are read from store.
+func NewServer(logger types.Logger, socket string, store TokenStore) Server {
+	return &server{
+		logger: logger,
+		socket: socket,
+		store:  store,
+		tokens: map[string]string{},
+	}
+}
+
+type server struct {
+	logger types.Logger
+	socket string
+	store  TokenStore
+	mutex  sync.RWMutex
+	tokens map[string]string
+}
+
+func (s *server) SetToken(token, keyAuth string) error {
+	s.mutex.Lock()
+	s.tokens[token] = keyAuth
+	s.mutex.Unlock()
+	if s.store != nil {
+		if err := s.store.SetToken(token, keyAuth); err != nil {
+			return fmt.Errorf("error storing token: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *server) ClearToken(token string) {
+	s.mutex.Lock()
+	delete(s.tokens, token)
+	s.mutex.Unlock()
+	if s.store != nil {
+		if err := s.store.ClearToken(token); err != nil {
+			s.logger.Warn("acme: error removing token %s: %v", token, err)
+		}
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, ChallengePrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, ChallengePrefix)
+	s.mutex.RLock()
+	keyAuth, found := s.tokens[token]
+	s.mutex.RUnlock()
+	if !found && s.store != nil {
+		var err error
+		keyAuth, err = s.store.GetToken(token)
+		found = err == nil
+	}
+	if !found {
+		s.logger.Warn("acme: token not found: %s", token)
+		http.NotFound(w, r)
+		return
+	}
+	s.logger.Info("acme: answering challenge of host '%s'", r.Host)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+func (s *server) Listen(stopCh <-chan struct{}) error {
+	os.Remove(s.socket)
+	l, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: s}
+	go func() {
+		<-stopCh
+		httpServer.Close()
+	}()
+	go func() {
+		if err := httpServer.Serve(l); err != http.ErrServerClosed {
+			s.logger.Error("acme: error serving challenges: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// Cache ...
+type Cache interface {
+	GetSecretContent(secretName, keyName string) ([]byte, error)
+	// CreateOrUpdateSecret creates the secret if it does not exist, otherwise
+	// updates the keys of data, other keys of the secret are preserved
+	CreateOrUpdateSecret(secretName, secretType string, data map[string][]byte) error
+}
+
+// Signer ...
+type Signer interface {
+	Notify(acme *hatypes.AcmeConfig)
+	Start(stopCh <-chan struct{})
+}
+
+// SignerOptions ...
+type SignerOptions struct {
+	Logger        types.Logger
+	Cache         Cache
+	Resolver      Resolver
+	AccountSecret string
+	CheckPeriod   time.Duration
+	HTTPClient    *http.Client
+	// IsLeader, if assigned, reports if this replica is the elected
+	// leader, certificates are only verified and requested by the leader
+	IsLeader func() bool
+}
+
+// leaderCheckPeriod is the interval between checks of the leader election,
+// a newly elected leader verifies the certificates without waiting CheckPeriod
+const leaderCheckPeriod = 30 * time.Second
+
+// NewSigner creates the ACME signer. Certificates are verified on every
+// CheckPeriod and on every change of the ACME config, and a new one is
+// requested if missing, expiring or not covering all the domains.
+func NewSigner(options *SignerOptions) Signer {
+	return &signer{
+		options: options,
+		logger:  options.Logger,
+		cache:   options.Cache,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+type signer struct {
+	options   *SignerOptions
+	logger    types.Logger
+	cache     Cache
+	trigger   chan struct{}
+	mutex     sync.Mutex
+	config    *hatypes.AcmeConfig
+	client    Client
+	clientKey string
+	leader    bool
+	// pollInterval overrides the client's default, used on tests
+	pollInterval time.Duration
+}
+
+const (
+	accountKeyName = "account.key"
+	crtKeyName     = "tls.crt"
+	keyKeyName     = "tls.key"
+)
+
+func (s *signer) Notify(acme *hatypes.AcmeConfig) {
+	s.mutex.Lock()
+	changed := !reflect.DeepEqual(s.config, acme)
+	if changed {
+		config := *acme
+		s.config = &config
+	}
+	s.mutex.Unlock()
+	if changed && acme.Enabled {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *signer) Start(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(s.options.CheckPeriod)
+	defer ticker.Stop()
+	leaderTicker := time.NewTicker(leaderCheckPeriod)
+	defer leaderTicker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-s.trigger:
+		case <-leaderTicker.C:
+			wasLeader := s.leader
+			s.leader = s.isLeader()
+			if wasLeader || !s.leader {
+				continue
+			}
+		}
+		s.verifyAll()
+	}
+}
+
+func (s *signer) isLeader() bool {
+	return s.options.IsLeader == nil || s.options.IsLeader()
+}
+
+func (s *signer) verifyAll() {
+	s.mutex.Lock()
+	config := s.config
+	s.mutex.Unlock()
+	if config == nil || !config.Enabled {
+		return
+	}
+	s.leader = s.isLeader()
+	if !s.leader {
+		s.logger.InfoV(2, "acme: skipping verification of the certificates, this controller is not the leader")
+		return
+	}
+	secrets := make([]string, 0, len(config.Certs))
+	for secret := range config.Certs {
+		secrets = append(secrets, secret)
+	}
+	sort.Strings(secrets)
+	for _, secret := range secrets {
+		s.verify(config, secret)
+	}
+}
+
+func (s *signer) verify(config *hatypes.AcmeConfig, secretName string) {
+	domains := config.Domains(secretName)
+	reason := s.checkCert(secretName, domains, config.Expiring)
+	if reason == "" {
+		s.logger.InfoV(2, "acme: certificate of secret '%s' is up to date", secretName)
+		return
+	}
+	strDomains := strings.Join(domains, ",")
+	s.logger.Info("acme: requesting certificate of secret '%s' domain(s) '%s': %s", secretName, strDomains, reason)
+	client, err := s.acquireClient(config)
+	if err != nil {
+		s.logger.Error("acme: error creating the ACME client: %v", err)
+		return
+	}
+	crt, key, err := client.Sign(domains)
+	if err != nil {
+		s.logger.Warn("acme: error signing certificate of secret '%s': %v", secretName, err)
+		return
+	}
+	if err := s.cache.CreateOrUpdateSecret(secretName, "kubernetes.io/tls", map[string][]byte{
+		crtKeyName: crt,
+		keyKeyName: key,
+	}); err != nil {
+		s.logger.Error("acme: error storing certificate of secret '%s': %v", secretName, err)
+		return
+	}
+	s.logger.Info("acme: new certificate issued to secret '%s' domain(s) '%s'", secretName, strDomains)
+}
+
+// checkCert returns the reason why a new certificate should be requested,
+// or an empty string if the current one is valid
+func (s *signer) checkCert(secretName string, domains []string, expiring time.Duration) string {
+	data, err := s.cache.GetSecretContent(secretName, crtKeyName)
+	if err != nil {
+		return "certificate not found"
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "invalid certificate"
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Sprintf("invalid certificate: %v", err)
+	}
+	for _, domain := range domains {
+		if err := crt.VerifyHostname(domain); err != nil {
+			return fmt.Sprintf("certificate does not have domain '%s'", domain)
+		}
+	}
+	if time.Until(crt.NotAfter) < expiring {
+		return fmt.Sprintf("certificate expires at %s", crt.NotAfter.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// acquireClient reuses the ACME client and its account while
+// the endpoint and the contacts does not change
+func (s *signer) acquireClient(config *hatypes.AcmeConfig) (Client, error) {
+	clientKey := config.Endpoint + "|" + config.Emails
+	if s.client != nil && s.clientKey == clientKey {
+		return s.client, nil
+	}
+	accountKey, err := s.acquireAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	s.client = NewClient(&ClientOptions{
+		Endpoint:     config.Endpoint,
+		Emails:       config.Emails,
+		TermsAgreed:  config.TermsAgreed,
+		AccountKey:   accountKey,
+		Resolver:     s.options.Resolver,
+		HTTPClient:   s.options.HTTPClient,
+		PollInterval: s.pollInterval,
+	})
+	s.clientKey = clientKey
+	return s.client, nil
+}
+
+func (s *signer) acquireAccountKey() (*ecdsa.PrivateKey, error) {
+	if data, err := s.cache.GetSecretContent(s.options.AccountSecret, accountKeyName); err == nil {
+		return DecodeAccountKey(data)
+	}
+	key, err := NewAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := EncodeAccountKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cache.CreateOrUpdateSecret(s.options.AccountSecret, "Opaque", map[string][]byte{
+		accountKeyName: data,
+	}); err != nil {
+		return nil, fmt.Errorf("error storing account key: %v", err)
+	}
+	s.logger.Info("acme: new account key stored in secret '%s'", s.options.AccountSecret)
+	return key, nil
+}
@@ -116,6 +116,9 @@ type GenericController struct {
 	runningConfig *ingress.Configuration
 
 	forceReload int32
+
+	// configmaps read by the converter, its changes should also start a sync
+	trackedConfigMaps *sync.Map
 }
 
 // Configuration contains all the settings required by an Ingress controller
@@ -175,7 +178,8 @@ func newIngressController(config *Configuration) *GenericController {
 		recorder: eventBroadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{
 			Component: "ingress-controller",
 		}),
-		sslCertTracker: newSSLCertTracker(),
+		sslCertTracker:    newSSLCertTracker(),
+		trackedConfigMaps: &sync.Map{},
 	}
 
 	ic.syncQueue = task.NewTaskQueue(ic.syncIngress)
@@ -1579,6 +1583,17 @@ func (ic *GenericController) isForceReload() bool {
 	return atomic.LoadInt32(&ic.forceReload) != 0
 }
 
+// TrackConfigMap adds a configmap to the list of configmaps which should
+// trigger a new sync when changed
+func (ic *GenericController) TrackConfigMap(name string) {
+	ic.trackedConfigMaps.Store(name, true)
+}
+
+func (ic *GenericController) isTrackedConfigMap(name string) bool {
+	_, found := ic.trackedConfigMaps.Load(name)
+	return found
+}
+
 // SetForceReload ...
 func (ic *GenericController) SetForceReload(shouldReload bool) {
 	if shouldReload {
@@ -1589,6 +1604,21 @@ func (ic *GenericController) SetForceReload(shouldReload bool) {
 	}
 }
 
+// IsLeader returns true if this controller is the elected leader of its
+// ingress class. The leader is only elected if the status update is enabled,
+// otherwise the controller is always the leader.
+func (ic *GenericController) IsLeader() bool {
+	if ic.syncStatus == nil {
+		return true
+	}
+	return ic.syncStatus.IsLeader()
+}
+
+// SyncAfter schedules a new sync after delay
+func (ic *GenericController) SyncAfter(delay time.Duration) {
+	ic.syncQueue.EnqueueAfter(&extensions.Ingress{}, delay)
+}
+
 // CreateDefaultSSLCertificate ...
 func (ic *GenericController) CreateDefaultSSLCertificate() (path, hash string) {
 	defCert, defKey := ssl.GetFakeSSLCert()
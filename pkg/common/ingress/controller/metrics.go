@@ -17,9 +17,12 @@ limitations under the License.
 package controller
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
 )
 
 const (
@@ -28,13 +31,23 @@ const (
 	reloadLabel    = "reloads"
 	sslLabelExpire = "ssl_expire_time_seconds"
 	sslLabelHost   = "host"
+	annotationName = "annotation"
 )
 
 func init() {
 	prometheus.MustRegister(reloadOperation)
 	prometheus.MustRegister(reloadOperationErrors)
 	prometheus.MustRegister(sslExpireTime)
-
+	prometheus.MustRegister(syncDuration)
+	prometheus.MustRegister(converterDuration)
+	prometheus.MustRegister(renderDuration)
+	prometheus.MustRegister(checkDuration)
+	prometheus.MustRegister(reloadDuration)
+	prometheus.MustRegister(configHosts)
+	prometheus.MustRegister(configBackends)
+	prometheus.MustRegister(configEndpoints)
+	prometheus.MustRegister(configUserlists)
+	prometheus.MustRegister(annotationErrors)
 }
 
 var (
@@ -63,6 +76,77 @@ var (
 		},
 		[]string{sslLabelHost},
 	)
+	syncDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "sync_duration_seconds",
+			Help:      "Time spent synchronizing the ingress resources to HAProxy, including the reload",
+		},
+	)
+	converterDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "converter_duration_seconds",
+			Help:      "Time spent converting the ingress resources to the HAProxy model",
+		},
+	)
+	renderDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "template_render_duration_seconds",
+			Help:      "Time spent rendering the HAProxy configuration and map files",
+		},
+	)
+	checkDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "haproxy_check_duration_seconds",
+			Help:      "Time spent validating the HAProxy configuration with haproxy -c",
+		},
+	)
+	reloadDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: ns,
+			Name:      "haproxy_reload_duration_seconds",
+			Help:      "Time spent reloading HAProxy",
+		},
+	)
+	configHosts = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "config_hosts",
+			Help:      "Number of hosts of the current HAProxy configuration",
+		},
+	)
+	configBackends = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "config_backends",
+			Help:      "Number of backends of the current HAProxy configuration",
+		},
+	)
+	configEndpoints = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "config_endpoints",
+			Help:      "Number of endpoints of the backends of the current HAProxy configuration",
+		},
+	)
+	configUserlists = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "config_userlists",
+			Help:      "Number of userlists of the current HAProxy configuration",
+		},
+	)
+	annotationErrors = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: ns,
+			Name:      "annotation_errors",
+			Help:      "Number of objects of the current configuration with an annotation whose value couldn't be parsed",
+		},
+		[]string{annotationName},
+	)
 )
 
 func incReloadCount() {
@@ -82,3 +166,45 @@ func setSSLExpireTime(servers []*ingress.Server) {
 	}
 
 }
+
+// NewMetrics returns the metrics of the v0.8 controller, registered
+// along with the metrics of the generic controller
+func NewMetrics() types.Metrics {
+	return &metrics{}
+}
+
+type metrics struct{}
+
+func (m *metrics) ObserveSync(duration time.Duration) {
+	syncDuration.Observe(duration.Seconds())
+}
+
+func (m *metrics) ObserveConverter(duration time.Duration) {
+	converterDuration.Observe(duration.Seconds())
+}
+
+func (m *metrics) ObserveRender(duration time.Duration) {
+	renderDuration.Observe(duration.Seconds())
+}
+
+func (m *metrics) ObserveCheck(duration time.Duration) {
+	checkDuration.Observe(duration.Seconds())
+}
+
+func (m *metrics) ObserveReload(duration time.Duration) {
+	reloadDuration.Observe(duration.Seconds())
+}
+
+func (m *metrics) SetConfigSize(hosts, backends, endpoints, userlists int) {
+	configHosts.Set(float64(hosts))
+	configBackends.Set(float64(backends))
+	configEndpoints.Set(float64(endpoints))
+	configUserlists.Set(float64(userlists))
+}
+
+func (m *metrics) SetAnnotationErrors(errors map[string]int) {
+	annotationErrors.Reset()
+	for annotation, count := range errors {
+		annotationErrors.WithLabelValues(annotation).Set(float64(count))
+	}
+}
@@ -54,6 +54,7 @@ const (
 type Sync interface {
 	Run(stopCh <-chan struct{})
 	Shutdown()
+	IsLeader() bool
 }
 
 // Config ...
@@ -101,6 +102,11 @@ func (s statusSync) Run(stopCh <-chan struct{}) {
 	<-stopCh
 }
 
+// IsLeader returns true if this instance is the elected leader
+func (s statusSync) IsLeader() bool {
+	return s.elector.IsLeader()
+}
+
 func (s *statusSync) update() {
 	// send a dummy object to the queue to force a sync
 	s.syncQueue.Enqueue("sync status")
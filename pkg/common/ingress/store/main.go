@@ -92,14 +92,15 @@ type EndpointLister struct {
 
 // GetServiceEndpoints returns the endpoints of a service, matched on service name.
 func (s *EndpointLister) GetServiceEndpoints(svc *apiv1.Service) (ep apiv1.Endpoints, err error) {
-	for _, m := range s.Store.List() {
-		ep = *m.(*apiv1.Endpoints)
-		if svc.Name == ep.Name && svc.Namespace == ep.Namespace {
-			return ep, nil
-		}
+	m, exists, err := s.Store.GetByKey(svc.Namespace + "/" + svc.Name)
+	if err != nil {
+		return ep, err
 	}
-	err = fmt.Errorf("could not find endpoints for service: %v", svc.Name)
-	return
+	if !exists {
+		err = fmt.Errorf("could not find endpoints for service: %v", svc.Name)
+		return
+	}
+	return *m.(*apiv1.Endpoints), nil
 }
 
 // PodLister makes a store that lists Pods.
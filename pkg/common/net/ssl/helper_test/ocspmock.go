@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"time"
+)
+
+// CertMock has a certificate and its private key
+type CertMock struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+// PEM ...
+func (c *CertMock) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM ...
+func (c *CertMock) KeyPEM() []byte {
+	der, _ := x509.MarshalECPrivateKey(c.Key)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// CreateCA ...
+func CreateCA(name string) *CertMock {
+	return createCert(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil)
+}
+
+// CreateCRL creates a PEM encoded certificate revocation list
+// signed by issuer, revoking the certificates of revoked
+func CreateCRL(issuer *CertMock, nextUpdate time.Time, revoked ...*CertMock) []byte {
+	var revokedCerts []pkix.RevokedCertificate
+	for _, cert := range revoked {
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{
+			SerialNumber:   cert.Cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+	der, _ := issuer.Cert.CreateCRL(rand.Reader, issuer.Key, revokedCerts, time.Now(), nextUpdate)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// CreateCert creates a certificate signed by issuer. ocspServer is
+// added as the OCSP responder, if not empty.
+func CreateCert(name, ocspServer string, issuer *CertMock) *CertMock {
+	template := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}
+	if ocspServer != "" {
+		template.OCSPServer = []string{ocspServer}
+	}
+	return createCert(template, issuer)
+}
+
+// CreateCertValidity creates a certificate signed by issuer with the
+// first name as the common name and all the names as the DNS names
+func CreateCertValidity(names []string, notBefore, notAfter time.Time, issuer *CertMock) *CertMock {
+	return createCert(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: names[0]},
+		DNSNames:  names,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+	}, issuer)
+}
+
+// CreateOCSPSigner creates a delegated OCSP responder certificate
+func CreateOCSPSigner(name string, ocspSigning bool, issuer *CertMock) *CertMock {
+	template := &x509.Certificate{
+		Subject: pkix.Name{CommonName: name},
+	}
+	if ocspSigning {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	}
+	return createCert(template, issuer)
+}
+
+var serial int64
+
+func createCert(template *x509.Certificate, issuer *CertMock) *CertMock {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serial++
+	template.SerialNumber = big.NewInt(serial)
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.Cert, issuer.Key
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	cert, _ := x509.ParseCertificate(der)
+	return &CertMock{Cert: cert, Key: key}
+}
+
+// OCSPResponseMock ...
+type OCSPResponseMock struct {
+	// Status is 0 (good), 1 (revoked) or 2 (unknown)
+	Status     int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	// Signer signs the response and is added to the response as a
+	// delegated responder if it's not the issuer
+	Signer *CertMock
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag   `asn1:"tag:0,optional"`
+	Revoked    revokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag   `asn1:"tag:2,optional"`
+	ThisUpdate time.Time   `asn1:"generalized"`
+	NextUpdate time.Time   `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time `asn1:"generalized"`
+}
+
+type responseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type response struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0"`
+}
+
+// CreateOCSPResponse creates a DER encoded OCSP response of cert
+func CreateOCSPResponse(cert, issuer *CertMock, mock OCSPResponseMock) []byte {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	asn1.Unmarshal(issuer.Cert.RawSubjectPublicKeyInfo, &spki)
+	nameHash := crypto.SHA1.New()
+	nameHash.Write(issuer.Cert.RawSubject)
+	keyHash := crypto.SHA1.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+	single := singleResponse{
+		CertID: certID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26},
+				Parameters: asn1.RawValue{Tag: asn1.TagNull},
+			},
+			NameHash:      nameHash.Sum(nil),
+			IssuerKeyHash: keyHash.Sum(nil),
+			SerialNumber:  cert.Cert.SerialNumber,
+		},
+		ThisUpdate: mock.ThisUpdate.UTC(),
+		NextUpdate: mock.NextUpdate.UTC(),
+	}
+	switch mock.Status {
+	case 0:
+		single.Good = true
+	case 1:
+		single.Revoked = revokedInfo{RevocationTime: mock.ThisUpdate.UTC()}
+	case 2:
+		single.Unknown = true
+	}
+	signer := mock.Signer
+	if signer == nil {
+		signer = issuer
+	}
+	tbs, _ := asn1.Marshal(responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: signer.Cert.RawSubject},
+		ProducedAt:  time.Now().UTC(),
+		Responses:   []singleResponse{single},
+	})
+	hash := sha256.Sum256(tbs)
+	signature, _ := signer.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	basic := basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	}
+	if signer != issuer {
+		basic.Certificates = []asn1.RawValue{{FullBytes: signer.Cert.Raw}}
+	}
+	basicDER, _ := asn1.Marshal(basic)
+	der, _ := asn1.Marshal(response{
+		Response: responseBytes{
+			ResponseType: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1},
+			Response:     basicDER,
+		},
+	})
+	return der
+}
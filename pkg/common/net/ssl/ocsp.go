@@ -0,0 +1,247 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// OCSP certificate status, see RFC 6960
+const (
+	OCSPGood = iota
+	OCSPRevoked
+	OCSPUnknown
+)
+
+// OCSPResponse has the validated content of an OCSP response
+type OCSPResponse struct {
+	Status     int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+}
+
+var (
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSignatureAlgorithms = []struct {
+		oid  asn1.ObjectIdentifier
+		algo x509.SignatureAlgorithm
+	}{
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+		{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	}
+)
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspResponseASN1 struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+func createOCSPCertID(cert, issuer *x509.Certificate) (*ocspCertID, error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return nil, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(publicKeyInfo.PublicKey.RightAlign())
+	return &ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidSHA1,
+			Parameters: asn1.RawValue{Tag: asn1.TagNull},
+		},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+// CreateOCSPRequest creates a DER encoded OCSP request of a certificate
+func CreateOCSPRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	certID, err := createOCSPCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspSingleRequest{{Cert: *certID}},
+		},
+	})
+}
+
+// ParseOCSPResponse parses and validates a DER encoded OCSP response. The response
+// should be signed by the issuer or by a delegated responder certified by the issuer,
+// should refer to the certificate and should be valid at the moment of the call.
+func ParseOCSPResponse(der []byte, cert, issuer *x509.Certificate) (*OCSPResponse, error) {
+	var resp ocspResponseASN1
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data in OCSP response")
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type: %v", resp.Response.ResponseType)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCSP responder certificate: %v", err)
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return nil, fmt.Errorf("OCSP responder certificate is not signed by the issuer: %v", err)
+			}
+			if !hasOCSPSigning(responder) {
+				return nil, fmt.Errorf("OCSP responder certificate does not have OCSP signing usage")
+			}
+		}
+		signer = responder
+	}
+	algo := x509.UnknownSignatureAlgorithm
+	for _, sig := range oidSignatureAlgorithms {
+		if sig.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			algo = sig.algo
+			break
+		}
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response signature: %v", err)
+	}
+	certID, err := createOCSPCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	for _, single := range basic.TBSResponseData.Responses {
+		if single.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		if single.CertID.HashAlgorithm.Algorithm.Equal(oidSHA1) &&
+			(!bytes.Equal(single.CertID.NameHash, certID.NameHash) || !bytes.Equal(single.CertID.IssuerKeyHash, certID.IssuerKeyHash)) {
+			continue
+		}
+		now := time.Now()
+		if single.ThisUpdate.After(now) {
+			return nil, fmt.Errorf("OCSP response is not valid yet: %s", single.ThisUpdate.Format(time.RFC3339))
+		}
+		if !single.NextUpdate.IsZero() && single.NextUpdate.Before(now) {
+			return nil, fmt.Errorf("OCSP response is expired: %s", single.NextUpdate.Format(time.RFC3339))
+		}
+		response := &OCSPResponse{
+			ThisUpdate: single.ThisUpdate,
+			NextUpdate: single.NextUpdate,
+		}
+		switch {
+		case bool(single.Good):
+			response.Status = OCSPGood
+		case bool(single.Unknown):
+			response.Status = OCSPUnknown
+		default:
+			response.Status = OCSPRevoked
+			response.RevokedAt = single.Revoked.RevocationTime
+		}
+		return response, nil
+	}
+	return nil, fmt.Errorf("OCSP response does not refer to the certificate")
+}
+
+func hasOCSPSigning(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ssl
+
+import (
+	"testing"
+	"time"
+
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
+)
+
+func TestParseOCSPResponse(t *testing.T) {
+	ca := ssl_helper.CreateCA("ca")
+	otherCA := ssl_helper.CreateCA("other-ca")
+	cert := ssl_helper.CreateCert("d1.local", "http://ocsp.local", ca)
+	otherCert := ssl_helper.CreateCert("d2.local", "http://ocsp.local", ca)
+	delegated := ssl_helper.CreateOCSPSigner("ocsp", true, ca)
+	noUsage := ssl_helper.CreateOCSPSigner("ocsp", false, ca)
+	foreign := ssl_helper.CreateOCSPSigner("ocsp", true, otherCA)
+	now := time.Now().Truncate(time.Second)
+	testCase := []struct {
+		cert      *ssl_helper.CertMock
+		mock      ssl_helper.OCSPResponseMock
+		expStatus int
+		expError  string
+	}{
+		// 0
+		{
+			mock:      ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour)},
+			expStatus: OCSPGood,
+		},
+		// 1
+		{
+			mock:      ssl_helper.OCSPResponseMock{Status: 1, ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour)},
+			expStatus: OCSPRevoked,
+		},
+		// 2
+		{
+			mock:      ssl_helper.OCSPResponseMock{Status: 2, ThisUpdate: now.Add(-time.Hour)},
+			expStatus: OCSPUnknown,
+		},
+		// 3
+		{
+			mock:      ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour), Signer: delegated},
+			expStatus: OCSPGood,
+		},
+		// 4
+		{
+			mock:     ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour), Signer: noUsage},
+			expError: "OCSP responder certificate does not have OCSP signing usage",
+		},
+		// 5
+		{
+			mock:     ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(time.Hour), Signer: foreign},
+			expError: "OCSP responder certificate is not signed by the issuer: x509: ECDSA verification failure",
+		},
+		// 6
+		{
+			mock:     ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-2 * time.Hour), NextUpdate: now.Add(-time.Hour)},
+			expError: "OCSP response is expired: " + now.Add(-time.Hour).UTC().Format(time.RFC3339),
+		},
+		// 7
+		{
+			mock:     ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(time.Hour)},
+			expError: "OCSP response is not valid yet: " + now.Add(time.Hour).UTC().Format(time.RFC3339),
+		},
+		// 8
+		{
+			cert:     otherCert,
+			mock:     ssl_helper.OCSPResponseMock{ThisUpdate: now.Add(-time.Hour)},
+			expError: "OCSP response does not refer to the certificate",
+		},
+	}
+	for i, test := range testCase {
+		respCert := test.cert
+		if respCert == nil {
+			respCert = cert
+		}
+		der := ssl_helper.CreateOCSPResponse(respCert, ca, test.mock)
+		resp, err := ParseOCSPResponse(der, cert.Cert, ca.Cert)
+		if test.expError != "" {
+			if err == nil || err.Error() != test.expError {
+				t.Errorf("expected error '%s' on %d but was '%v'", test.expError, i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error on %d: %v", i, err)
+			continue
+		}
+		if resp.Status != test.expStatus {
+			t.Errorf("expected status %d on %d but was %d", test.expStatus, i, resp.Status)
+		}
+		if !resp.ThisUpdate.Equal(test.mock.ThisUpdate) || !resp.NextUpdate.Equal(test.mock.NextUpdate) {
+			t.Errorf("unexpected update times on %d: %v %v", i, resp.ThisUpdate, resp.NextUpdate)
+		}
+	}
+}
+
+func TestParseOCSPResponseSignature(t *testing.T) {
+	ca := ssl_helper.CreateCA("ca")
+	otherCA := ssl_helper.CreateCA("ca")
+	cert := ssl_helper.CreateCert("d1.local", "http://ocsp.local", ca)
+	der := ssl_helper.CreateOCSPResponse(cert, ca, ssl_helper.OCSPResponseMock{ThisUpdate: time.Now().Add(-time.Hour)})
+	if _, err := ParseOCSPResponse(der, cert.Cert, otherCA.Cert); err == nil {
+		t.Errorf("expected signature error")
+	}
+	if _, err := ParseOCSPResponse(der[:len(der)-10], cert.Cert, ca.Cert); err == nil {
+		t.Errorf("expected parse error")
+	}
+	if _, err := CreateOCSPRequest(cert.Cert, ca.Cert); err != nil {
+		t.Errorf("unexpected error creating request: %v", err)
+	}
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package ssl
 
 import (
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -30,7 +33,9 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -105,29 +110,7 @@ func AddOrUpdateCertAndKey(name string, cert, key, ca []byte) (*ingress.SSLCert,
 		return nil, err
 	}
 
-	cn := sets.NewString(pemCert.Subject.CommonName)
-	for _, dns := range pemCert.DNSNames {
-		if !cn.Has(dns) {
-			cn.Insert(dns)
-		}
-	}
-
-	if len(pemCert.Extensions) > 0 {
-		glog.V(3).Info("parsing ssl certificate extensions")
-		for _, ext := range getExtension(pemCert, oidExtensionSubjectAltName) {
-			dns, _, _, err := parseSANExtension(ext.Value)
-			if err != nil {
-				glog.Warningf("unexpected error parsing certificate extensions: %v", err)
-				continue
-			}
-
-			for _, dns := range dns {
-				if !cn.Has(dns) {
-					cn.Insert(dns)
-				}
-			}
-		}
-	}
+	cn := sets.NewString(CertNames(pemCert)...)
 
 	err = os.Rename(tempPemFile.Name(), pemFileName)
 	if err != nil {
@@ -179,6 +162,182 @@ func AddOrUpdateCertAndKey(name string, cert, key, ca []byte) (*ingress.SSLCert,
 	}, nil
 }
 
+// CertKeyPair has the PEM encoded certificate and private key of a bundle
+type CertKeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+var bundleKeyTypes = []string{"rsa", "dsa", "ecdsa"}
+
+// AddOrUpdateCertBundle creates a HAProxy multi-cert bundle with the specified name
+// in the dir directory. Every pair is written to a .pem.rsa or .pem.ecdsa file
+// depending on its key type, and the returned PemFileName is the name of the bundle,
+// without the key type suffix. Files of key types not found in pairs are removed.
+func AddOrUpdateCertBundle(dir, name string, pairs []CertKeyPair) (*ingress.SSLCert, error) {
+	pemFileName := fmt.Sprintf("%v/%v.pem", dir, name)
+	contents := map[string][]byte{}
+	var certs []*x509.Certificate
+	for _, pair := range pairs {
+		keyPair, err := tls.X509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			return nil, err
+		}
+		var keyType string
+		switch keyPair.PrivateKey.(type) {
+		case *rsa.PrivateKey:
+			keyType = "rsa"
+		case *ecdsa.PrivateKey:
+			keyType = "ecdsa"
+		default:
+			return nil, fmt.Errorf("unsupported private key type: %T", keyPair.PrivateKey)
+		}
+		if _, found := contents[keyType]; found {
+			return nil, fmt.Errorf("more than one %s certificate found", strings.ToUpper(keyType))
+		}
+		cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		content := append([]byte{}, pair.Cert...)
+		content = append(content, '\n')
+		content = append(content, pair.Key...)
+		contents[keyType] = content
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("certificate not found")
+	}
+	hash := sha1.New()
+	cn := sets.NewString()
+	for _, cert := range certs {
+		cn.Insert(cert.Subject.CommonName)
+		cn.Insert(cert.DNSNames...)
+	}
+	expire := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(expire) {
+			expire = cert.NotAfter
+		}
+	}
+	for _, keyType := range bundleKeyTypes {
+		filename := pemFileName + "." + keyType
+		content, found := contents[keyType]
+		if !found {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("could not remove stale pem file %v: %v", filename, err)
+			}
+			continue
+		}
+		if err := writeFileAtomic(filename, content); err != nil {
+			return nil, err
+		}
+		hash.Write(content)
+	}
+	glog.V(3).Infof("created certificate bundle %v with %v key type(s)", pemFileName, len(contents))
+	return &ingress.SSLCert{
+		Certificate: certs[0],
+		PemFileName: pemFileName,
+		PemSHA:      fmt.Sprintf("%x", hash.Sum(nil)),
+		CN:          cn.List(),
+		ExpireTime:  expire,
+	}, nil
+}
+
+func writeFileAtomic(filename string, content []byte) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
+	if err != nil {
+		return fmt.Errorf("could not create temp pem file %v: %v", filename, err)
+	}
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("could not write to pem file %v: %v", tempFile.Name(), err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("could not close temp pem file %v: %v", tempFile.Name(), err)
+	}
+	if err := os.Rename(tempFile.Name(), filename); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("could not move temp pem file %v to destination %v: %v", tempFile.Name(), filename, err)
+	}
+	return nil
+}
+
+// CertNames returns the common name and the DNS names
+// of the subject alternative name extension of a certificate
+func CertNames(cert *x509.Certificate) []string {
+	cn := sets.NewString(cert.Subject.CommonName)
+	for _, dns := range cert.DNSNames {
+		if !cn.Has(dns) {
+			cn.Insert(dns)
+		}
+	}
+
+	if len(cert.Extensions) > 0 {
+		glog.V(3).Info("parsing ssl certificate extensions")
+		for _, ext := range getExtension(cert, oidExtensionSubjectAltName) {
+			dns, _, _, err := parseSANExtension(ext.Value)
+			if err != nil {
+				glog.Warningf("unexpected error parsing certificate extensions: %v", err)
+				continue
+			}
+
+			for _, dns := range dns {
+				if !cn.Has(dns) {
+					cn.Insert(dns)
+				}
+			}
+		}
+	}
+	return cn.List()
+}
+
+// CertMatchesHost returns true if one of the names of the certificate
+// covers hostname. A wildcard name covers one single label, and a wildcard
+// hostname is only covered by the same wildcard name.
+func CertMatchesHost(cert *x509.Certificate, hostname string) bool {
+	hostname = strings.ToLower(hostname)
+	for _, name := range CertNames(cert) {
+		name = strings.ToLower(name)
+		if name == hostname {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && !strings.HasPrefix(hostname, "*.") {
+			if i := strings.Index(hostname, "."); i > 0 && hostname[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadCertificate parses the first certificate of a PEM encoded file.
+// The RSA or the ECDSA certificate is read if filename is a multi-cert
+// bundle, see AddOrUpdateCertBundle.
+func ReadCertificate(filename string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		for _, keyType := range bundleKeyTypes {
+			if data, err = ioutil.ReadFile(filename + "." + keyType); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open %s: no such file or bundle", filename)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return nil, fmt.Errorf("no certificate found in %s", filename)
+}
+
 func getExtension(c *x509.Certificate, id asn1.ObjectIdentifier) []pkix.Extension {
 	var exts []pkix.Extension
 	for _, ext := range c.Extensions {
@@ -279,6 +438,102 @@ func AddCertAuth(name string, ca []byte) (*ingress.SSLCert, error) {
 	}, nil
 }
 
+// AddOrUpdateCRL creates a .pem file in the dir directory with the certificate
+// revocation list used in Cert Authentication. If it's already exists, it's clobbered.
+func AddOrUpdateCRL(dir, name string, crl []byte) (string, error) {
+	if _, err := CRLNextUpdate(crl); err != nil {
+		return "", err
+	}
+	crlFileName := fmt.Sprintf("%v/crl-%v.pem", dir, name)
+	if err := writeFileAtomic(crlFileName, crl); err != nil {
+		return "", err
+	}
+	glog.V(3).Infof("Created CRL for Authentication: %v", crlFileName)
+	return crlFileName, nil
+}
+
+// CRLNextUpdate parses a PEM or DER encoded certificate revocation
+// list and returns the time its issuer will publish a newer one.
+func CRLNextUpdate(crl []byte) (time.Time, error) {
+	certList, err := x509.ParseCRL(crl)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing CRL: %v", err)
+	}
+	return certList.TBSCertList.NextUpdate, nil
+}
+
+// TicketKeysSecretKey is the key of a secret that stores TLS session
+// ticket keys, one base64 encoded key per line, from the oldest to the newest.
+const TicketKeysSecretKey = "tls-ticket-keys"
+
+// ticketKeysMin is the number of keys HAProxy uses from a tls-ticket-keys
+// file: the penultimate one encrypts new tickets, all of them decrypt them.
+const ticketKeysMin = 3
+
+// ParseTicketKeys validates the content of a TLS session ticket keys file
+// and returns its keys. All the keys should have the same size, 48 bytes
+// (aes128) or 80 bytes (aes256) after decoded.
+func ParseTicketKeys(content []byte) ([]string, error) {
+	var keys []string
+	size := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding ticket key %d: %v", len(keys)+1, err)
+		}
+		if len(decoded) != 48 && len(decoded) != 80 {
+			return nil, fmt.Errorf("ticket key %d should have 48 or 80 bytes, found %d", len(keys)+1, len(decoded))
+		}
+		if size > 0 && len(decoded) != size {
+			return nil, fmt.Errorf("ticket key %d size differs from the former keys", len(keys)+1)
+		}
+		size = len(decoded)
+		keys = append(keys, key)
+	}
+	if len(keys) < ticketKeysMin {
+		return nil, fmt.Errorf("at least %d ticket keys are needed, found %d", ticketKeysMin, len(keys))
+	}
+	return keys, nil
+}
+
+// NewTicketKey generates a random TLS session ticket key of size bytes,
+// 48 (aes128) or 80 (aes256), base64 encoded.
+func NewTicketKey(size int) (string, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// RotateTicketKeys appends a new key and removes the oldest one, so the
+// amount of keys is preserved. The new key has the size of the other ones,
+// HAProxy doesn't accept keys of distinct sizes. An empty or invalid list
+// is replaced with a new one with the minimum amount of 48 bytes keys.
+func RotateTicketKeys(keys []string) ([]string, error) {
+	count := len(keys)
+	size := 48
+	if count < ticketKeysMin {
+		keys = nil
+		count = ticketKeysMin
+	} else if decoded, err := base64.StdEncoding.DecodeString(keys[count-1]); err == nil && len(decoded) == 80 {
+		size = 80
+	}
+	keys = append([]string{}, keys...)
+	for len(keys) < count+1 {
+		key, err := NewTicketKey(size)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys[len(keys)-count:], nil
+}
+
 // AddOrUpdateDHParam creates a dh parameters file with the specified name
 func AddOrUpdateDHParam(name string, dh []byte) (string, error) {
 	pemName := fmt.Sprintf("%v.pem", name)
@@ -18,8 +18,12 @@ package ssl
 
 import (
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,6 +31,7 @@ import (
 	"k8s.io/client-go/util/cert/triple"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
 )
 
 // generateRSACerts generates a self signed certificate using a self generated ca
@@ -152,3 +157,241 @@ func TestAddCertAuth(t *testing.T) {
 		t.Fatalf("expected a valid CA file name")
 	}
 }
+
+func TestAddOrUpdateCertBundle(t *testing.T) {
+	td, err := ioutil.TempDir("", "ssl")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temporal directory: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	rsaCert, _, err := generateRSACerts("d1.local")
+	if err != nil {
+		t.Fatalf("unexpected error creating SSL certificate: %v", err)
+	}
+	rsaPair := CertKeyPair{
+		Cert: certutil.EncodeCertPEM(rsaCert.Cert),
+		Key:  certutil.EncodePrivateKeyPEM(rsaCert.Key),
+	}
+	ecdsaCert := ssl_helper.CreateCert("d1.local", "", ssl_helper.CreateCA("ca"))
+	ecdsaPair := CertKeyPair{
+		Cert: ecdsaCert.PEM(),
+		Key:  ecdsaCert.KeyPEM(),
+	}
+
+	bundle, err := AddOrUpdateCertBundle(td, "d1", []CertKeyPair{rsaPair, ecdsaPair})
+	if err != nil {
+		t.Fatalf("unexpected error creating bundle: %v", err)
+	}
+	if bundle.PemFileName != td+"/d1.pem" {
+		t.Errorf("expected bundle name '%s' but was '%s'", td+"/d1.pem", bundle.PemFileName)
+	}
+	for _, ext := range []string{".rsa", ".ecdsa"} {
+		if _, err := os.Stat(bundle.PemFileName + ext); err != nil {
+			t.Errorf("expected %s file: %v", ext, err)
+		}
+	}
+	if _, err := os.Stat(bundle.PemFileName); !os.IsNotExist(err) {
+		t.Errorf("expected bundle without a single certificate file")
+	}
+	if !reflect.DeepEqual(bundle.CN, []string{"d1.local"}) {
+		t.Errorf("expected CN [d1.local] but was %v", bundle.CN)
+	}
+
+	rsaOnly, err := AddOrUpdateCertBundle(td, "d1", []CertKeyPair{rsaPair})
+	if err != nil {
+		t.Fatalf("unexpected error updating bundle: %v", err)
+	}
+	if _, err := os.Stat(bundle.PemFileName + ".ecdsa"); !os.IsNotExist(err) {
+		t.Errorf("expected stale .ecdsa file to be removed")
+	}
+	if rsaOnly.PemSHA == bundle.PemSHA {
+		t.Errorf("expected distinct hash after bundle update")
+	}
+
+	if _, err := AddOrUpdateCertBundle(td, "d2", []CertKeyPair{ecdsaPair, ecdsaPair}); err == nil {
+		t.Errorf("expected error on duplicated key type")
+	} else if err.Error() != "more than one ECDSA certificate found" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	if _, err := AddOrUpdateCertBundle(td, "d3", []CertKeyPair{{Cert: rsaPair.Cert, Key: ecdsaPair.Key}}); err == nil {
+		t.Errorf("expected error on mismatched key pair")
+	}
+}
+
+func TestAddOrUpdateCRL(t *testing.T) {
+	td, err := ioutil.TempDir("", "ssl")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temporal directory: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	ca := ssl_helper.CreateCA("ca")
+	nextUpdate := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	crl := ssl_helper.CreateCRL(ca, nextUpdate, ssl_helper.CreateCert("d1.local", "", ca))
+
+	crlFileName, err := AddOrUpdateCRL(td, "default_ca", crl)
+	if err != nil {
+		t.Fatalf("unexpected error creating CRL: %v", err)
+	}
+	if crlFileName != td+"/crl-default_ca.pem" {
+		t.Errorf("expected CRL name '%s' but was '%s'", td+"/crl-default_ca.pem", crlFileName)
+	}
+	data, err := ioutil.ReadFile(crlFileName)
+	if err != nil {
+		t.Fatalf("unexpected error reading CRL file: %v", err)
+	}
+	actual, err := CRLNextUpdate(data)
+	if err != nil {
+		t.Errorf("unexpected error parsing CRL file: %v", err)
+	} else if !actual.Equal(nextUpdate) {
+		t.Errorf("expected next update '%v' but was '%v'", nextUpdate, actual)
+	}
+
+	if _, err := AddOrUpdateCRL(td, "invalid", ca.PEM()); err == nil {
+		t.Errorf("expected error on invalid CRL")
+	}
+	if _, err := os.Stat(td + "/crl-invalid.pem"); !os.IsNotExist(err) {
+		t.Errorf("expected invalid CRL not to be written")
+	}
+}
+
+func TestParseTicketKeys(t *testing.T) {
+	key48 := base64.StdEncoding.EncodeToString(make([]byte, 48))
+	key80 := base64.StdEncoding.EncodeToString(make([]byte, 80))
+	testCases := []struct {
+		content  string
+		expected []string
+		expErr   string
+	}{
+		// 0
+		{
+			content:  key48 + "\n" + key48 + "\n" + key48 + "\n",
+			expected: []string{key48, key48, key48},
+		},
+		// 1
+		{
+			content:  "\n" + key80 + "\n  " + key80 + "\n\n" + key80 + "\n" + key80,
+			expected: []string{key80, key80, key80, key80},
+		},
+		// 2
+		{
+			content: key48 + "\n" + key48,
+			expErr:  "at least 3 ticket keys are needed, found 2",
+		},
+		// 3
+		{
+			content: key48 + "\n" + key80 + "\n" + key48,
+			expErr:  "ticket key 2 size differs from the former keys",
+		},
+		// 4
+		{
+			content: key48 + "\n" + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			expErr:  "ticket key 2 should have 48 or 80 bytes, found 32",
+		},
+		// 5
+		{
+			content: "invalid*key",
+			expErr:  "error decoding ticket key 1: illegal base64 data at input byte 7",
+		},
+	}
+	for i, test := range testCases {
+		keys, err := ParseTicketKeys([]byte(test.content))
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		if errMsg != test.expErr {
+			t.Errorf("expected error '%s' on %d but was '%s'", test.expErr, i, errMsg)
+		}
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("expected keys %v on %d but was %v", test.expected, i, keys)
+		}
+	}
+}
+
+func TestRotateTicketKeys(t *testing.T) {
+	keys, err := RotateTicketKeys(nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating keys: %v", err)
+	}
+	if _, err := ParseTicketKeys([]byte(strings.Join(keys, "\n"))); err != nil {
+		t.Errorf("unexpected error parsing new keys: %v", err)
+	}
+	keys = append(keys, keys[0])
+	rotated, err := RotateTicketKeys(keys)
+	if err != nil {
+		t.Fatalf("unexpected error rotating keys: %v", err)
+	}
+	if len(rotated) != 4 {
+		t.Errorf("expected 4 keys but found %d", len(rotated))
+	}
+	if !reflect.DeepEqual(rotated[:3], keys[1:]) {
+		t.Errorf("expected keys %v to be moved to %v", keys[1:], rotated[:3])
+	}
+	if rotated[3] == keys[3] {
+		t.Errorf("expected a new key at the end of %v", rotated)
+	}
+
+	// aes256 keys, the new key has the same size
+	key80 := base64.StdEncoding.EncodeToString(make([]byte, 80))
+	keys = []string{key80, key80, key80}
+	rotated, err = RotateTicketKeys(keys)
+	if err != nil {
+		t.Fatalf("unexpected error rotating keys: %v", err)
+	}
+	if _, err := ParseTicketKeys([]byte(strings.Join(rotated, "\n"))); err != nil {
+		t.Errorf("unexpected error parsing rotated aes256 keys: %v", err)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(rotated[2]); len(decoded) != 80 {
+		t.Errorf("expected a new key with 80 bytes but found %d", len(decoded))
+	}
+}
+
+func TestCertMatchesHost(t *testing.T) {
+	ca := ssl_helper.CreateCA("ca")
+	now := time.Now()
+	cert := ssl_helper.CreateCertValidity([]string{"d1.local", "*.d2.local"}, now, now.Add(time.Hour), ca).Cert
+	testCases := []struct {
+		hostname string
+		expected bool
+	}{
+		{"d1.local", true},
+		{"D1.local", true},
+		{"sub.d1.local", false},
+		{"d2.local", false},
+		{"sub.d2.local", true},
+		{"sub.sub.d2.local", false},
+		{"*.d2.local", true},
+		{"*.d1.local", false},
+	}
+	for i, test := range testCases {
+		if actual := CertMatchesHost(cert, test.hostname); actual != test.expected {
+			t.Errorf("expected match of '%s' on %d to be %v but was %v", test.hostname, i, test.expected, actual)
+		}
+	}
+}
+
+func TestReadCertificate(t *testing.T) {
+	td, err := ioutil.TempDir("", "ssl")
+	if err != nil {
+		t.Fatalf("Unexpected error creating temporal directory: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	cert := ssl_helper.CreateCert("d1.local", "", ssl_helper.CreateCA("ca"))
+	content := append(cert.KeyPEM(), cert.PEM()...)
+	ioutil.WriteFile(td+"/d1.pem", content, 0644)
+	ioutil.WriteFile(td+"/d2.pem.ecdsa", content, 0644)
+	for _, file := range []string{td + "/d1.pem", td + "/d2.pem"} {
+		actual, err := ReadCertificate(file)
+		if err != nil {
+			t.Errorf("unexpected error reading '%s': %v", file, err)
+		} else if !actual.Equal(cert.Cert) {
+			t.Errorf("expected certificate %s on '%s'", cert.Cert.Subject, file)
+		}
+	}
+	if _, err := ReadCertificate(td + "/d3.pem"); err == nil {
+		t.Errorf("expected error reading missing certificate")
+	}
+}
@@ -79,6 +79,28 @@ func (t *Queue) Enqueue(obj interface{}) {
 	})
 }
 
+// EnqueueAfter enqueues ns/name of the given api object in the task
+// queue after delay. The item is not skipped by syncs which happen
+// before it's added to the queue.
+func (t *Queue) EnqueueAfter(obj interface{}, delay time.Duration) {
+	if t.IsShuttingDown() {
+		glog.Errorf("queue has been shutdown, failed to enqueue: %v", obj)
+		return
+	}
+
+	ts := time.Now().Add(delay).UnixNano()
+	glog.V(3).Infof("queuing item %v after %v", obj, delay)
+	key, err := t.fn(obj)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return
+	}
+	t.queue.AddAfter(Element{
+		Key:       key,
+		Timestamp: ts,
+	}, delay)
+}
+
 func (t *Queue) defaultKeyFunc(obj interface{}) (interface{}, error) {
 	key, err := keyFunc(obj)
 	if err != nil {
@@ -17,10 +17,14 @@ limitations under the License.
 package controller
 
 import (
+	"crypto/sha1"
+	"crypto/tls"
 	"fmt"
 	"strings"
 
 	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/file"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
@@ -59,6 +63,9 @@ func (c *cache) GetPod(podName string) (*api.Pod, error) {
 }
 
 func (c *cache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
+	if secret, err := c.listers.Secret.GetByName(secretName); err == nil && hasBundleKeys(secret) {
+		return c.GetTLSSecretBundlePath([]string{secretName})
+	}
 	sslCert, err := c.controller.GetCertificate(secretName)
 	if err != nil {
 		return ingtypes.File{}, err
@@ -72,20 +79,100 @@ func (c *cache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
 	}, nil
 }
 
-func (c *cache) GetCASecretPath(secretName string) (ingtypes.File, error) {
-	sslCert, err := c.controller.GetCertificate(secretName)
+// bundleKeys are the additional key pairs of a TLS secret, used to
+// build a multi-cert bundle with distinct key types, eg RSA and ECDSA
+var bundleKeys = []string{"tls-rsa", "tls-ecdsa"}
+
+func hasBundleKeys(secret *api.Secret) bool {
+	for _, key := range bundleKeys {
+		if _, found := secret.Data[key+".crt"]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cache) GetTLSSecretBundlePath(secretNames []string) (ingtypes.File, error) {
+	name, pairs, err := c.readBundlePairs(secretNames)
 	if err != nil {
 		return ingtypes.File{}, err
 	}
-	if sslCert.CAFileName == "" {
-		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
+	sslCert, err := ssl.AddOrUpdateCertBundle(ingress.DefaultSSLDirectory, name, pairs)
+	if err != nil {
+		return ingtypes.File{}, fmt.Errorf("error creating certificate bundle of '%s': %v", strings.Join(secretNames, ","), err)
 	}
 	return ingtypes.File{
-		Filename: sslCert.CAFileName,
+		Filename: sslCert.PemFileName,
 		SHA1Hash: sslCert.PemSHA,
 	}, nil
 }
 
+// readBundlePairs returns the name of the bundle of secretNames
+// and the key pairs found in the secrets
+func (c *cache) readBundlePairs(secretNames []string) (string, []ssl.CertKeyPair, error) {
+	var pairs []ssl.CertKeyPair
+	names := make([]string, len(secretNames))
+	for i, secretName := range secretNames {
+		secret, err := c.listers.Secret.GetByName(secretName)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, key := range append([]string{"tls"}, bundleKeys...) {
+			crt, foundCrt := secret.Data[key+".crt"]
+			pkey, foundKey := secret.Data[key+".key"]
+			if foundCrt != foundKey {
+				return "", nil, fmt.Errorf("secret '%s' should have both keys '%s.crt' and '%s.key'", secretName, key, key)
+			}
+			if foundCrt {
+				pairs = append(pairs, ssl.CertKeyPair{Cert: crt, Key: pkey})
+			}
+		}
+		names[i] = strings.Replace(secretName, "/", "_", -1)
+	}
+	if len(pairs) == 0 {
+		return "", nil, fmt.Errorf("secret(s) '%s' does not have keys 'tls.crt' and 'tls.key'", strings.Join(secretNames, ","))
+	}
+	// the name should not collide with the single certificate file,
+	// HAProxy only reads the bundle if the pem file without suffix does not exist
+	return strings.Join(names, "+") + "_bundle", pairs, nil
+}
+
+// crlFilename is the optional key of a CA secret with the certificate revocation list
+const crlFilename = "ca.crl"
+
+func (c *cache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	sslCert, err := c.controller.GetCertificate(secretName)
+	if err != nil {
+		return ca, crl, err
+	}
+	if sslCert.CAFileName == "" {
+		return ca, crl, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
+	}
+	ca = ingtypes.File{
+		Filename: sslCert.CAFileName,
+		SHA1Hash: sslCert.PemSHA,
+	}
+	secret, err := c.listers.Secret.GetByName(secretName)
+	if err != nil {
+		return ca, crl, err
+	}
+	crlData, found := secret.Data[crlFilename]
+	if !found {
+		// crl is optional
+		return ca, crl, nil
+	}
+	crlName := strings.Replace(secretName, "/", "_", -1)
+	crlFileName, err := ssl.AddOrUpdateCRL(ingress.DefaultCACertsDirectory, crlName, crlData)
+	if err != nil {
+		return ca, crl, fmt.Errorf("error creating crl file of secret '%s': %v", secretName, err)
+	}
+	crl = ingtypes.File{
+		Filename: crlFileName,
+		SHA1Hash: file.SHA1(crlFileName),
+	}
+	return ca, crl, nil
+}
+
 func (c *cache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
 	secret, err := c.listers.Secret.GetByName(secretName)
 	if err != nil {
@@ -117,3 +204,147 @@ func (c *cache) GetSecretContent(secretName, keyName string) ([]byte, error) {
 	}
 	return data, nil
 }
+
+func (c *cache) CreateOrUpdateSecret(secretName, secretType string, data map[string][]byte) error {
+	return c.UpdateSecret(secretName, secretType, func(secretData map[string][]byte) bool {
+		for key, value := range data {
+			secretData[key] = value
+		}
+		return true
+	})
+}
+
+// updateSecretRetries is the number of times UpdateSecret reads the secret
+// again after a conflict with a concurrent write, e.g. from another replica
+const updateSecretRetries = 5
+
+func (c *cache) UpdateSecret(secretName, secretType string, update func(data map[string][]byte) bool) error {
+	sname := strings.Split(secretName, "/")
+	if len(sname) != 2 {
+		return fmt.Errorf("invalid secret name: '%s'", secretName)
+	}
+	client := c.controller.GetConfig().Client.CoreV1().Secrets(sname[0])
+	var err error
+	for i := 0; i < updateSecretRetries; i++ {
+		var secret *api.Secret
+		secret, err = client.Get(sname[1], metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			data := map[string][]byte{}
+			if !update(data) {
+				return nil
+			}
+			_, err = client.Create(&api.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: sname[0],
+					Name:      sname[1],
+				},
+				Type: api.SecretType(secretType),
+				Data: data,
+			})
+		} else if err == nil {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			if !update(secret.Data) {
+				return nil
+			}
+			_, err = client.Update(secret)
+		}
+		if !errors.IsConflict(err) && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *cache) GetConfigMapContent(configMapName string) (map[string]string, error) {
+	c.controller.TrackConfigMap(configMapName)
+	configMap, err := c.listers.ConfigMap.GetByName(configMapName)
+	if err != nil {
+		return nil, err
+	}
+	return configMap.Data, nil
+}
+
+// readOnlyCache implements ingtypes.Cache without writing certificate,
+// CRL and DH param files. The secrets are validated and the returned
+// files are named after them, with hashes of their content. Used to
+// convert ingress objects which weren't applied yet.
+type readOnlyCache struct {
+	*cache
+}
+
+func (c *readOnlyCache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
+	secret, err := c.listers.Secret.GetByName(secretName)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	if hasBundleKeys(secret) {
+		return c.GetTLSSecretBundlePath([]string{secretName})
+	}
+	crt, foundCrt := secret.Data[api.TLSCertKey]
+	key, foundKey := secret.Data[api.TLSPrivateKeyKey]
+	if !foundCrt || !foundKey {
+		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have keys 'tls.crt' and 'tls.key'", secretName)
+	}
+	if _, err := tls.X509KeyPair(crt, key); err != nil {
+		return ingtypes.File{}, fmt.Errorf("error reading certificate of secret '%s': %v", secretName, err)
+	}
+	return readOnlyFile(ingress.DefaultSSLDirectory, secretName, crt, key), nil
+}
+
+func (c *readOnlyCache) GetTLSSecretBundlePath(secretNames []string) (ingtypes.File, error) {
+	name, pairs, err := c.readBundlePairs(secretNames)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	var content [][]byte
+	for _, pair := range pairs {
+		if _, err := tls.X509KeyPair(pair.Cert, pair.Key); err != nil {
+			return ingtypes.File{}, fmt.Errorf("error creating certificate bundle of '%s': %v", strings.Join(secretNames, ","), err)
+		}
+		content = append(content, pair.Cert, pair.Key)
+	}
+	return readOnlyFile(ingress.DefaultSSLDirectory, name, content...), nil
+}
+
+func (c *readOnlyCache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	secret, err := c.listers.Secret.GetByName(secretName)
+	if err != nil {
+		return ca, crl, err
+	}
+	caData, found := secret.Data["ca.crt"]
+	if !found {
+		return ca, crl, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
+	}
+	ca = readOnlyFile(ingress.DefaultCACertsDirectory, "ca-"+secretName, caData)
+	crlData, found := secret.Data[crlFilename]
+	if !found {
+		return ca, crl, nil
+	}
+	if _, err := ssl.CRLNextUpdate(crlData); err != nil {
+		return ca, crl, fmt.Errorf("error creating crl file of secret '%s': %v", secretName, err)
+	}
+	crl = readOnlyFile(ingress.DefaultCACertsDirectory, "crl-"+secretName, crlData)
+	return ca, crl, nil
+}
+
+func (c *readOnlyCache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
+	dh, err := c.GetSecretContent(secretName, dhparamFilename)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	return readOnlyFile(ingress.DefaultSSLDirectory, secretName, dh), nil
+}
+
+// readOnlyFile names a file of a secret, which isn't written
+func readOnlyFile(dir, name string, content ...[]byte) ingtypes.File {
+	hash := sha1.New()
+	for _, c := range content {
+		hash.Write(c)
+	}
+	return ingtypes.File{
+		Filename: fmt.Sprintf("%s/%s.pem", dir, strings.Replace(name, "/", "_", -1)),
+		SHA1Hash: fmt.Sprintf("%x", hash.Sum(nil)),
+	}
+}
@@ -26,10 +26,12 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/acme"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/controller"
@@ -40,6 +42,7 @@ import (
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/version"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/webhook"
 )
 
 // HAProxyController has internal data of a HAProxyController instance
@@ -50,8 +53,14 @@ type HAProxyController struct {
 	configMap         *api.ConfigMap
 	storeLister       *ingress.StoreLister
 	converterOptions  *ingtypes.ConverterOptions
+	converterTracker  *ingressconverter.Tracker
+	supervisor        haproxy.Supervisor
+	quarantine        ingressconverter.Quarantine
+	metrics           types.Metrics
 	command           string
 	reloadStrategy    *string
+	reloadInterval    *time.Duration
+	logFormat         *string
 	configDir         string
 	configFilePrefix  string
 	configFileSuffix  string
@@ -60,6 +69,23 @@ type HAProxyController struct {
 	modsecConfigFile  string
 	modsecTemplate    *template
 	currentConfig     *types.ControllerConfig
+	acmeServer        *bool
+	acmeCheckPeriod   *time.Duration
+	acmeSecretKeyName *string
+	acmeTokenSecret   *string
+	acmeSigner        acme.Signer
+	ocspStapling      *bool
+	ocspCheckPeriod   *time.Duration
+	ocspUpdater       haproxy.OCSPUpdater
+	statsPeriod       *time.Duration
+	statsCollector    haproxy.StatsCollector
+	crlCollector      haproxy.CRLCollector
+	ticketKeysRotate  *time.Duration
+	ticketKeysRotator haproxy.TicketKeysRotator
+	webhookBind       *string
+	webhookCertFile   *string
+	webhookKeyFile    *string
+	stopCh            chan struct{}
 }
 
 // NewHAProxyController constructor
@@ -96,28 +122,130 @@ func (hc *HAProxyController) configController() {
 	}
 
 	// starting v0.8 only config
-	logger := &logger{depth: 1}
+	var logger types.Logger = &logger{depth: 1}
+	if *hc.logFormat == "json" {
+		logger = newJSONLogger(os.Stderr)
+	}
+	hc.supervisor = haproxy.NewSupervisor(logger, haproxy.SupervisorOptions{
+		HAProxyCmd:   "haproxy",
+		ConfigFile:   "/etc/haproxy/haproxy.cfg",
+		MasterSocket: "/var/run/haproxy-master.sock",
+		AdminSocket:  "/var/run/haproxy-stats.sock",
+		StateFile:    "/var/lib/haproxy/state-global",
+	})
+	prometheus.MustRegister(hc.supervisor)
+	hc.metrics = controller.NewMetrics()
+	haproxyVersion, err := haproxy.HAProxyVersion("haproxy")
+	if err != nil {
+		logger.Warn("cannot read the HAProxy version: %v", err)
+	}
 	instanceOptions := haproxy.InstanceOptions{
+		AdminSocket:       "/var/run/haproxy-stats.sock",
 		HAProxyCmd:        "haproxy",
-		ReloadCmd:         "/haproxy-reload.sh",
 		HAProxyConfigFile: "/etc/haproxy/haproxy.cfg",
-		ReloadStrategy:    *hc.reloadStrategy,
+		HAProxyVersion:    haproxyVersion,
+		Supervisor:        hc.supervisor,
 		MaxOldConfigFiles: *hc.maxOldConfigFiles,
+		ReloadInterval:    *hc.reloadInterval,
+		ReloadDeferred:    hc.controller.SyncAfter,
+		Metrics:           hc.metrics,
 	}
-	hc.instance = haproxy.CreateInstance(logger, hc, instanceOptions)
+	hc.instance = haproxy.CreateInstance(logger, instanceOptions)
 	if err := hc.instance.ParseTemplates(); err != nil {
 		glog.Fatalf("error creating HAProxy instance: %v", err)
 	}
+	prometheus.MustRegister(hc.instance)
+	hc.crlCollector = haproxy.NewCRLCollector(logger)
+	prometheus.MustRegister(hc.crlCollector)
+	certCollector := ingressconverter.NewCertCollector()
+	prometheus.MustRegister(certCollector)
 	cache := newCache(hc.storeLister, hc.controller)
+	hc.stopCh = make(chan struct{})
+	var acmeSocket string
+	if *hc.acmeServer {
+		acmeSocket = "/var/run/acme.sock"
+		hc.startAcme(logger, cache, acmeSocket)
+	}
+	if *hc.ocspStapling {
+		hc.ocspUpdater = haproxy.NewOCSPUpdater(logger, haproxy.OCSPOptions{
+			Socket:      "/var/run/haproxy-stats.sock",
+			CheckPeriod: *hc.ocspCheckPeriod,
+		})
+		prometheus.MustRegister(hc.ocspUpdater)
+		go hc.ocspUpdater.Start(hc.stopCh)
+	}
+	if *hc.statsPeriod > 0 {
+		hc.statsCollector = haproxy.NewStatsCollector(logger, haproxy.StatsOptions{
+			CollectPeriod: *hc.statsPeriod,
+		})
+		prometheus.MustRegister(hc.statsCollector)
+		go hc.statsCollector.Start(hc.stopCh)
+	}
+	if rotate := *hc.ticketKeysRotate; rotate > 0 {
+		checkPeriod := time.Minute
+		if rotate < checkPeriod {
+			checkPeriod = rotate
+		}
+		hc.ticketKeysRotator = haproxy.NewTicketKeysRotator(logger, haproxy.TicketKeysOptions{
+			Cache:          cache,
+			RotationPeriod: rotate,
+			CheckPeriod:    checkPeriod,
+		})
+		go hc.ticketKeysRotator.Start(hc.stopCh)
+	}
 	hc.converterOptions = &ingtypes.ConverterOptions{
 		Logger:           logger,
 		Cache:            cache,
 		AnnotationPrefix: "ingress.kubernetes.io",
 		DefaultBackend:   hc.cfg.DefaultService,
 		DefaultSSLFile:   hc.createDefaultSSLFile(cache),
+		AcmeSocket:       acmeSocket,
+		CertCollector:    certCollector,
+		EventRecorder:    hc.controller.GetRecorder(),
+		ConflictNotifier: ingressconverter.NewConflictNotifier(),
+		Metrics:          hc.metrics,
+		HAProxyVersion:   haproxyVersion,
+	}
+	hc.converterTracker = ingressconverter.NewTracker()
+	hc.quarantine = ingressconverter.NewQuarantine(logger, hc.controller.GetRecorder())
+	prometheus.MustRegister(hc.quarantine)
+	if *hc.webhookBind != "" {
+		server := webhook.NewServer(logger, webhook.Options{
+			BindAddress: *hc.webhookBind,
+			CertFile:    *hc.webhookCertFile,
+			KeyFile:     *hc.webhookKeyFile,
+			Validator:   &validator{hc: hc, cache: &readOnlyCache{cache}},
+		})
+		if err := server.Listen(hc.stopCh); err != nil {
+			glog.Fatalf("error creating the validating webhook server: %v", err)
+		}
 	}
 }
 
+func (hc *HAProxyController) startAcme(logger types.Logger, cache *cache, socket string) {
+	accountSecret := *hc.acmeSecretKeyName
+	if !strings.Contains(accountSecret, "/") {
+		accountSecret = os.Getenv("POD_NAMESPACE") + "/" + accountSecret
+	}
+	tokenSecret := *hc.acmeTokenSecret
+	if !strings.Contains(tokenSecret, "/") {
+		tokenSecret = os.Getenv("POD_NAMESPACE") + "/" + tokenSecret
+	}
+	server := acme.NewServer(logger, socket, acme.NewSecretTokenStore(cache, tokenSecret))
+	if err := server.Listen(hc.stopCh); err != nil {
+		glog.Fatalf("error creating the ACME server: %v", err)
+	}
+	hc.acmeSigner = acme.NewSigner(&acme.SignerOptions{
+		Logger:        logger,
+		Cache:         cache,
+		Resolver:      server,
+		AccountSecret: accountSecret,
+		CheckPeriod:   *hc.acmeCheckPeriod,
+		IsLeader:      hc.controller.IsLeader,
+	})
+	go hc.acmeSigner.Start(hc.stopCh)
+}
+
 func (hc *HAProxyController) createDefaultSSLFile(cache *cache) (tlsFile ingtypes.File) {
 	if hc.cfg.DefaultSSLCertificate != "" {
 		tlsFile, err := cache.GetTLSSecretPath(hc.cfg.DefaultSSLCertificate)
@@ -136,31 +264,15 @@ func (hc *HAProxyController) createDefaultSSLFile(cache *cache) (tlsFile ingtype
 	return tlsFile
 }
 
-// CreateX509CertsDir hard link files from certs to a single directory.
-func (hc *HAProxyController) CreateX509CertsDir(bindName string, certs []string) (string, error) {
-	x509dir := "/var/haproxy/certs/" + bindName
-	if err := os.RemoveAll(x509dir); err != nil {
-		return "", err
-	}
-	if err := os.MkdirAll(x509dir, 0700); err != nil {
-		return "", err
-	}
-	for _, cert := range certs {
-		srcFile, err := os.Stat(cert)
-		if err != nil {
-			return "", err
-		}
-		dstFile := x509dir + "/" + srcFile.Name()
-		if err := os.Link(cert, dstFile); err != nil {
-			return "", err
-		}
-	}
-	return x509dir, nil
-}
-
 // Stop shutdown the controller process
 func (hc *HAProxyController) Stop() error {
+	if hc.stopCh != nil {
+		close(hc.stopCh)
+	}
 	err := hc.controller.Stop()
+	if hc.supervisor != nil {
+		hc.supervisor.Stop()
+	}
 	return err
 }
 
@@ -194,9 +306,35 @@ func (hc *HAProxyController) UpdateIngressStatus(*extensions.Ingress) []api.Load
 // command line arguments
 func (hc *HAProxyController) ConfigureFlags(flags *pflag.FlagSet) {
 	hc.reloadStrategy = flags.String("reload-strategy", "native",
-		`Name of the reload strategy. Options are: native (default) or reusesocket`)
+		`Name of the reload strategy. Options are: native (default) or reusesocket. Only v0.7 controller uses the reload strategy, v0.8 controller runs HAProxy in master-worker mode`)
+	hc.reloadInterval = flags.Duration("reload-interval", 0,
+		`Minimum time between two HAProxy reloads. Changes which need a reload before the interval are applied together on the next reload. Changes applied without reloading HAProxy, e.g. certificate updates, are not delayed. Zero, the default value, reloads HAProxy on every change which needs it. Only v0.8 controller supports reload interval`)
+	hc.logFormat = flags.String("log-format", "text",
+		`Format of the messages logged by the controller. Options are: text (default) or json. JSON messages have the level and, if the message is about an ingress or a service, its namespace, name, hostname and backend as fields. Only v0.8 controller supports json log format`)
 	hc.maxOldConfigFiles = flags.Int("max-old-config-files", 0,
 		`Maximum old haproxy timestamped config files to allow before being cleaned up. A value <= 0 indicates a single non-timestamped config file will be used`)
+	hc.ocspStapling = flags.Bool("ocsp-stapling", false,
+		`Enables OCSP stapling of the certificates whose issuer provides an OCSP responder. Only v0.8 controller supports OCSP stapling`)
+	hc.ocspCheckPeriod = flags.Duration("ocsp-check-period", 5*time.Minute,
+		`Time between checks of OCSP responses which should be refreshed`)
+	hc.statsPeriod = flags.Duration("stats-collect-period", 0,
+		`Time between reads of the HAProxy stats, exported as metrics of the frontends, backends and servers labeled with their ingress, namespace and service. Zero, the default value, disables the stats metrics. Only v0.8 controller supports stats metrics`)
+	hc.ticketKeysRotate = flags.Duration("tls-ticket-keys-rotation", 0,
+		`Time between rotations of the TLS session ticket keys stored in the secret of the tls-ticket-keys configmap option. The secret is created if it does not exist. Zero, the default value, disables the rotation. Only v0.8 controller supports TLS ticket keys`)
+	hc.webhookBind = flags.String("validate-webhook-bind-address", "",
+		`Address and port of the HTTPS server of the validating admission webhook, e.g. :8443. Ingress and the global ConfigMap are rejected if they have invalid configurations. An empty value, the default, disables the webhook. Only v0.8 controller supports the validating webhook`)
+	hc.webhookCertFile = flags.String("validate-webhook-cert-file", "/var/run/webhook/tls.crt",
+		`Certificate file of the validating webhook server`)
+	hc.webhookKeyFile = flags.String("validate-webhook-key-file", "/var/run/webhook/tls.key",
+		`Private key file of the validating webhook server`)
+	hc.acmeServer = flags.Bool("acme-server", false,
+		`Enables the ACME server, used to answer the HTTP-01 challenges of Let's Encrypt or other ACME implementation. Only v0.8 controller supports ACME`)
+	hc.acmeCheckPeriod = flags.Duration("acme-check-period", 24*time.Hour,
+		`Time between checks of invalid or expiring certificates signed by the ACME server`)
+	hc.acmeSecretKeyName = flags.String("acme-secret-key-name", "acme-private-key",
+		`Name and an optional namespace of the secret which will store the ACME account private key. If a namespace is not provided, the secret will be created in the same namespace of the controller pod`)
+	hc.acmeTokenSecret = flags.String("acme-token-secret-name", "acme-challenge-tokens",
+		`Name and an optional namespace of the secret which will store the tokens of the pending HTTP-01 challenges, shared by all the controller replicas. If a namespace is not provided, the secret will be created in the same namespace of the controller pod`)
 	ingressClass := flags.Lookup("ingress-class")
 	if ingressClass != nil {
 		ingressClass.Value.Set("haproxy")
@@ -217,6 +355,9 @@ func (hc *HAProxyController) OverrideFlags(flags *pflag.FlagSet) {
 	if !(*hc.reloadStrategy == "native" || *hc.reloadStrategy == "reusesocket" || *hc.reloadStrategy == "multibinder") {
 		glog.Fatalf("Unsupported reload strategy: %v", *hc.reloadStrategy)
 	}
+	if !(*hc.logFormat == "text" || *hc.logFormat == "json") {
+		glog.Fatalf("Unsupported log format: %v", *hc.logFormat)
+	}
 }
 
 // SetConfig receives the ConfigMap the user has configured
@@ -252,6 +393,65 @@ func (hc *HAProxyController) DrainSupport() (drainSupport bool) {
 
 // SyncIngress sync HAProxy config from a very early stage
 func (hc *HAProxyController) SyncIngress(item interface{}) error {
+	start := time.Now()
+	defer func() {
+		hc.metrics.ObserveSync(time.Since(start))
+	}()
+	for {
+		ingress := hc.quarantine.Filter(hc.validIngress())
+		globalConfig := hc.globalConfig()
+		if err := hc.syncConfig(ingress, globalConfig); err == nil {
+			return nil
+		}
+		check := ingressconverter.NewScratchCheck(hc.converterOptions, hc.instance, globalConfig)
+		if !hc.quarantine.Isolate(ingress, check) {
+			return nil
+		}
+		// apply the configuration without the quarantined ingress
+	}
+}
+
+// syncConfig converts ingress and updates HAProxy, returns
+// the error of the check of an invalid configuration
+func (hc *HAProxyController) syncConfig(ingress []*extensions.Ingress, globalConfig map[string]string) error {
+	converterStart := time.Now()
+	converter := ingressconverter.NewIncrementalConverter(
+		hc.converterOptions,
+		hc.instance.Config(),
+		globalConfig,
+		hc.converterTracker,
+	)
+	converter.Sync(ingress)
+	hc.metrics.ObserveConverter(time.Since(converterStart))
+	hc.updateConfigMetrics()
+	if hc.acmeSigner != nil {
+		hc.acmeSigner.Notify(&hc.instance.Config().Global().Acme)
+	}
+	if hc.ocspUpdater != nil {
+		hc.ocspUpdater.Notify(hc.servedCerts())
+	}
+	if hc.statsCollector != nil {
+		hc.statsCollector.Notify(hc.instance.Config())
+	}
+	hc.crlCollector.Notify(hc.servedCRLs())
+	if hc.ticketKeysRotator != nil {
+		hc.ticketKeysRotator.Notify(globalConfig["tls-ticket-keys"])
+	}
+	return hc.instance.Update()
+}
+
+// updateConfigMetrics reports the size of the configuration being built
+func (hc *HAProxyController) updateConfigMetrics() {
+	config := hc.instance.Config()
+	var endpoints int
+	for _, backend := range config.Backends() {
+		endpoints += len(backend.Endpoints)
+	}
+	hc.metrics.SetConfigSize(len(config.Hosts()), len(config.Backends()), endpoints, len(config.Userlists()))
+}
+
+// validIngress lists the ingress resources of the ingress class of the controller
+func (hc *HAProxyController) validIngress() []*extensions.Ingress {
 	var ingress []*extensions.Ingress
 	for _, iing := range hc.storeLister.Ingress.List() {
 		ing := iing.(*extensions.Ingress)
@@ -259,25 +459,62 @@ func (hc *HAProxyController) SyncIngress(item interface{}) error {
 			ingress = append(ingress, ing)
 		}
 	}
-	sort.SliceStable(ingress, func(i, j int) bool {
-		return ingress[i].ResourceVersion < ingress[j].ResourceVersion
-	})
+	return ingress
+}
 
-	var globalConfig map[string]string
+func (hc *HAProxyController) globalConfig() map[string]string {
 	if hc.configMap != nil {
-		globalConfig = hc.configMap.Data
+		return hc.configMap.Data
 	}
-	converter := ingressconverter.NewIngressConverter(
-		hc.converterOptions,
-		hc.instance.Config(),
-		globalConfig,
-	)
-	converter.Sync(ingress)
-	hc.instance.Update()
-
 	return nil
 }
 
+// servedCerts lists the certificate files of the HAProxy configuration
+// being built, it should be called before the instance update
+func (hc *HAProxyController) servedCerts() []string {
+	certs := []string{hc.converterOptions.DefaultSSLFile.Filename}
+	added := map[string]bool{certs[0]: true}
+	for _, host := range hc.instance.Config().Hosts() {
+		if file := host.TLS.TLSFilename; file != "" && !added[file] {
+			added[file] = true
+			certs = append(certs, bundleFiles(file)...)
+		}
+	}
+	return certs
+}
+
+// servedCRLs lists the certificate revocation list files
+// of the HAProxy configuration being built
+func (hc *HAProxyController) servedCRLs() []string {
+	var crls []string
+	added := map[string]bool{}
+	for _, host := range hc.instance.Config().Hosts() {
+		if file := host.TLS.CRLFilename; file != "" && !added[file] {
+			added[file] = true
+			crls = append(crls, file)
+		}
+	}
+	return crls
+}
+
+// bundleFiles expands a multi-cert bundle to its .rsa and .ecdsa files,
+// HAProxy only reads the bundle if the file without suffix does not exist
+func bundleFiles(file string) []string {
+	if _, err := os.Stat(file); err == nil {
+		return []string{file}
+	}
+	var files []string
+	for _, keyType := range []string{"rsa", "ecdsa"} {
+		if _, err := os.Stat(file + "." + keyType); err == nil {
+			files = append(files, file+"."+keyType)
+		}
+	}
+	if len(files) == 0 {
+		return []string{file}
+	}
+	return files
+}
+
 // OnUpdate regenerate the configuration file of the backend
 func (hc *HAProxyController) OnUpdate(cfg ingress.Configuration) error {
 	updatedConfig, err := newControllerConfig(&cfg, hc)
@@ -17,9 +17,16 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
 )
 
 type logger struct {
@@ -54,3 +61,81 @@ func (l *logger) Error(msg string, args ...interface{}) {
 func (l *logger) Fatal(msg string, args ...interface{}) {
 	glog.FatalDepth(l.depth, l.build(msg, args))
 }
+
+// jsonLogger implements types.FieldLogger, writing one JSON
+// object per message, with its level and structured fields
+type jsonLogger struct {
+	out    *jsonOutput
+	fields types.LogFields
+}
+
+type jsonOutput struct {
+	mutex sync.Mutex
+	w     io.Writer
+	now   func() time.Time
+}
+
+type jsonEntry struct {
+	Time            string `json:"ts"`
+	Level           string `json:"level"`
+	Message         string `json:"msg"`
+	SourceType      string `json:"source_type,omitempty"`
+	SourceNamespace string `json:"source_namespace,omitempty"`
+	SourceName      string `json:"source_name,omitempty"`
+	Backend         string `json:"backend,omitempty"`
+	Hostname        string `json:"hostname,omitempty"`
+}
+
+func newJSONLogger(w io.Writer) *jsonLogger {
+	return &jsonLogger{out: &jsonOutput{w: w, now: time.Now}}
+}
+
+func (l *jsonLogger) WithFields(fields types.LogFields) types.Logger {
+	return &jsonLogger{out: l.out, fields: l.fields.Merge(fields)}
+}
+
+func (l *jsonLogger) log(level, msg string, args []interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	line, err := json.Marshal(&jsonEntry{
+		Time:            l.out.now().UTC().Format(time.RFC3339Nano),
+		Level:           level,
+		Message:         msg,
+		SourceType:      l.fields.SourceType,
+		SourceNamespace: l.fields.SourceNamespace,
+		SourceName:      l.fields.SourceName,
+		Backend:         l.fields.Backend,
+		Hostname:        l.fields.Hostname,
+	})
+	if err != nil {
+		glog.Errorf("error encoding log message '%s': %v", msg, err)
+		return
+	}
+	l.out.mutex.Lock()
+	defer l.out.mutex.Unlock()
+	l.out.w.Write(append(line, '\n'))
+}
+
+func (l *jsonLogger) InfoV(v int, msg string, args ...interface{}) {
+	if glog.V(glog.Level(v)) {
+		l.log("info", msg, args)
+	}
+}
+
+func (l *jsonLogger) Info(msg string, args ...interface{}) {
+	l.log("info", msg, args)
+}
+
+func (l *jsonLogger) Warn(msg string, args ...interface{}) {
+	l.log("warn", msg, args)
+}
+
+func (l *jsonLogger) Error(msg string, args ...interface{}) {
+	l.log("error", msg, args)
+}
+
+func (l *jsonLogger) Fatal(msg string, args ...interface{}) {
+	l.log("fatal", msg, args)
+	os.Exit(1)
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+func TestJSONLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := newJSONLogger(out)
+	l.out.now = func() time.Time { return time.Date(2019, 10, 1, 10, 0, 0, 0, time.UTC) }
+
+	l.Info("config %s", "updated")
+	ingLogger := types.WithFields(l, types.LogFields{
+		SourceType:      "ingress",
+		SourceNamespace: "default",
+		SourceName:      "echo",
+	})
+	types.WithFields(ingLogger, types.LogFields{Hostname: "echo.local"}).Warn("invalid '%s'", "app-root")
+	types.WithFields(ingLogger, types.LogFields{Backend: "default_echo_8080"}).Error("error")
+	ingLogger.Warn("100%")
+
+	expected := `{"ts":"2019-10-01T10:00:00Z","level":"info","msg":"config updated"}
+{"ts":"2019-10-01T10:00:00Z","level":"warn","msg":"invalid 'app-root'","source_type":"ingress","source_namespace":"default","source_name":"echo","hostname":"echo.local"}
+{"ts":"2019-10-01T10:00:00Z","level":"error","msg":"error","source_type":"ingress","source_namespace":"default","source_name":"echo","backend":"default_echo_8080"}
+{"ts":"2019-10-01T10:00:00Z","level":"warn","msg":"100%","source_type":"ingress","source_namespace":"default","source_name":"echo"}
+`
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\nbut was:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
+	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// validator implements webhook.Validator, merging the incoming
+// object with the ingress and the global config of the cluster
+type validator struct {
+	hc    *HAProxyController
+	cache *readOnlyCache
+}
+
+func (v *validator) ValidateIngress(ing *extensions.Ingress) []string {
+	hc := v.hc
+	if !class.IsValid(ing, hc.cfg.IngressClass, hc.cfg.DefaultIngressClass) {
+		return nil
+	}
+	if ing.CreationTimestamp.IsZero() {
+		// created objects don't have a timestamp yet, and
+		// the newest ingress loses on conflicts
+		ing = ing.DeepCopy()
+		ing.CreationTimestamp = metav1.Now()
+	}
+	var old *extensions.Ingress
+	ingress := hc.validIngress()
+	for _, cur := range ingress {
+		if cur.Namespace == ing.Namespace && cur.Name == ing.Name {
+			old = cur
+			break
+		}
+	}
+	curIngress := ingressconverter.AffectedIngress(ingress, ing, old)
+	newIngress := make([]*extensions.Ingress, 0, len(curIngress)+1)
+	for _, cur := range curIngress {
+		if cur != old {
+			newIngress = append(newIngress, cur)
+		}
+	}
+	newIngress = append(newIngress, ing)
+	globalConfig := hc.globalConfig()
+	return ingressconverter.ValidateChanges(v.options(), curIngress, newIngress, globalConfig, globalConfig)
+}
+
+func (v *validator) ValidateConfigMap(configMap *api.ConfigMap) []string {
+	hc := v.hc
+	if configMap.Namespace+"/"+configMap.Name != hc.cfg.ConfigMapName {
+		return nil
+	}
+	ingress := hc.validIngress()
+	return ingressconverter.ValidateChanges(v.options(), ingress, ingress, hc.globalConfig(), configMap.Data)
+}
+
+// options returns the converter options of the controller
+// using a cache which doesn't write files
+func (v *validator) options() *ingtypes.ConverterOptions {
+	options := *v.hc.converterOptions
+	options.Cache = v.cache
+	return &options
+}
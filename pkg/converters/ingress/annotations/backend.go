@@ -28,7 +28,7 @@ import (
 func (c *updater) buildBackendAffinity(d *backData) {
 	if d.ann.Affinity != "cookie" {
 		if d.ann.Affinity != "" {
-			c.logger.Error("unsupported affinity type on %v: %s", d.ann.Source, d.ann.Affinity)
+			c.loggerFor(d).Error("unsupported affinity type on %v: %s", d.ann.Source, d.ann.Affinity)
 		}
 		return
 	}
@@ -41,7 +41,7 @@ func (c *updater) buildBackendAffinity(d *backData) {
 	case "insert", "rewrite", "prefix":
 	default:
 		if strategy != "" {
-			c.logger.Warn("invalid affinity cookie strategy '%s' on %v, using 'insert' instead", strategy, d.ann.Source)
+			c.loggerFor(d).Warn("invalid affinity cookie strategy '%s' on %v, using 'insert' instead", strategy, d.ann.Source)
 		}
 		strategy = "insert"
 	}
@@ -53,12 +53,12 @@ func (c *updater) buildBackendAffinity(d *backData) {
 func (c *updater) buildBackendAuthHTTP(d *backData) {
 	if d.ann.AuthType != "basic" {
 		if d.ann.AuthType != "" {
-			c.logger.Error("unsupported authentication type on %v: %s", d.ann.Source, d.ann.AuthType)
+			c.loggerFor(d).Error("unsupported authentication type on %v: %s", d.ann.Source, d.ann.AuthType)
 		}
 		return
 	}
 	if d.ann.AuthSecret == "" {
-		c.logger.Error("missing secret name on basic authentication on %v", d.ann.Source)
+		c.loggerFor(d).Error("missing secret name on basic authentication on %v", d.ann.Source)
 		return
 	}
 	secretName := utils.FullQualifiedName(d.ann.Source.Namespace, d.ann.AuthSecret)
@@ -67,17 +67,17 @@ func (c *updater) buildBackendAuthHTTP(d *backData) {
 	if userlist == nil {
 		userb, err := c.cache.GetSecretContent(secretName, "auth")
 		if err != nil {
-			c.logger.Error("error reading basic authentication on %v: %v", d.ann.Source, err)
+			c.loggerFor(d).Error("error reading basic authentication on %v: %v", d.ann.Source, err)
 			return
 		}
 		userstr := string(userb)
 		users, errs := c.buildBackendAuthHTTPExtractUserlist(d.ann.Source.Name, secretName, userstr)
 		for _, err := range errs {
-			c.logger.Warn("ignoring malformed usr/passwd on secret '%s', declared on %v: %v", secretName, d.ann.Source, err)
+			c.loggerFor(d).Warn("ignoring malformed usr/passwd on secret '%s', declared on %v: %v", secretName, d.ann.Source, err)
 		}
 		userlist = c.haproxy.AddUserlist(listName, users)
 		if len(users) == 0 {
-			c.logger.Warn("userlist on %v for basic authentication is empty", d.ann.Source)
+			c.loggerFor(d).Warn("userlist on %v for basic authentication is empty", d.ann.Source)
 		}
 	}
 	d.backend.HreqValidateUserlist(userlist)
@@ -143,20 +143,20 @@ func (c *updater) buildBackendBlueGreen(d *backData) {
 	for _, weight := range strings.Split(balance, ",") {
 		dwSlice := strings.Split(weight, "=")
 		if len(dwSlice) != 3 {
-			c.logger.Error("blue/green config on %v has an invalid weight format: %s", d.ann.Source, weight)
+			c.loggerFor(d).Error("blue/green config on %v has an invalid weight format: %s", d.ann.Source, weight)
 			return
 		}
 		w, err := strconv.ParseInt(dwSlice[2], 10, 0)
 		if err != nil {
-			c.logger.Error("blue/green config on %v has an invalid weight value: %v", d.ann.Source, err)
+			c.loggerFor(d).Error("blue/green config on %v has an invalid weight value: %v", d.ann.Source, err)
 			return
 		}
 		if w < 0 {
-			c.logger.Warn("invalid weight '%d' on %v, using '0' instead", w, d.ann.Source)
+			c.loggerFor(d).Warn("invalid weight '%d' on %v, using '0' instead", w, d.ann.Source)
 			w = 0
 		}
 		if w > 256 {
-			c.logger.Warn("invalid weight '%d' on %v, using '256' instead", w, d.ann.Source)
+			c.loggerFor(d).Warn("invalid weight '%d' on %v, using '256' instead", w, d.ann.Source)
 			w = 256
 		}
 		dw := &deployWeight{
@@ -184,7 +184,7 @@ func (c *updater) buildBackendBlueGreen(d *backData) {
 			if ep.TargetRef == "" {
 				err = fmt.Errorf("endpoint does not reference a pod")
 			}
-			c.logger.Warn("endpoint '%s:%d' on %v was removed from balance: %v", ep.IP, ep.Port, d.ann.Source, err)
+			c.loggerFor(d).Warn("endpoint '%s:%d' on %v was removed from balance: %v", ep.IP, ep.Port, d.ann.Source, err)
 		}
 		if !hasLabel {
 			// no label match, set weight as zero to remove new traffic
@@ -194,7 +194,7 @@ func (c *updater) buildBackendBlueGreen(d *backData) {
 	}
 	for _, dw := range deployWeights {
 		if len(dw.endpoints) == 0 {
-			c.logger.InfoV(3, "blue/green balance label '%s=%s' on %v does not reference any endpoint", dw.labelName, dw.labelValue, d.ann.Source)
+			c.loggerFor(d).InfoV(3, "blue/green balance label '%s=%s' on %v does not reference any endpoint", dw.labelName, dw.labelValue, d.ann.Source)
 		}
 	}
 	if mode := d.ann.BlueGreenMode; mode == "pod" {
@@ -202,7 +202,7 @@ func (c *updater) buildBackendBlueGreen(d *backData) {
 		// no need to rebalance
 		return
 	} else if mode != "" && mode != "deploy" {
-		c.logger.Warn("unsupported blue/green mode '%s' on %v, falling back to 'deploy'", d.ann.BlueGreenMode, d.ann.Source)
+		c.loggerFor(d).Warn("unsupported blue/green mode '%s' on %v, falling back to 'deploy'", d.ann.BlueGreenMode, d.ann.Source)
 	}
 	// mode == deploy, need to recalc based on the number of replicas
 	lcmCount := 0
@@ -261,3 +261,25 @@ func (c *updater) buildBackendBlueGreen(d *backData) {
 		}
 	}
 }
+
+func (c *updater) buildBackendTunnel(d *backData) {
+	if !d.ann.TunnelUpgrade {
+		return
+	}
+	if d.backend.ModeTCP {
+		c.loggerFor(d).Warn("ignoring tunnel-upgrade on %v: backend is using tcp mode", d.ann.Source)
+		return
+	}
+	maxconn := d.ann.TunnelMaxconnServer
+	if maxconn < 0 {
+		c.loggerFor(d).Warn("ignoring invalid tunnel-maxconn-server '%d' on %v", maxconn, d.ann.Source)
+		maxconn = 0
+	}
+	timeout := d.ann.TunnelTimeout
+	if timeout == "" {
+		timeout = d.ann.TimeoutTunnel
+	}
+	d.backend.Tunnel.Enabled = true
+	d.backend.Tunnel.MaxConnServer = maxconn
+	copyHAProxyTime(&d.backend.Tunnel.Timeout, timeout)
+}
@@ -501,3 +501,54 @@ INFO-V(3) blue/green balance label 'v=3' on ingress 'default/ing1' does not refe
 		c.teardown()
 	}
 }
+
+func TestTunnel(t *testing.T) {
+	testCase := []struct {
+		ann        types.BackendAnnotations
+		modeTCP    bool
+		expTunnel  hatypes.BackendTunnelConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:       types.BackendAnnotations{TunnelTimeout: "1d"},
+			expTunnel: hatypes.BackendTunnelConfig{},
+		},
+		// 1
+		{
+			ann:       types.BackendAnnotations{TunnelUpgrade: true, TimeoutTunnel: "1h"},
+			expTunnel: hatypes.BackendTunnelConfig{Enabled: true, Timeout: "1h"},
+		},
+		// 2
+		{
+			ann:       types.BackendAnnotations{TunnelUpgrade: true, TimeoutTunnel: "1h", TunnelTimeout: "1d", TunnelMaxconnServer: 500},
+			expTunnel: hatypes.BackendTunnelConfig{Enabled: true, MaxConnServer: 500, Timeout: "1d"},
+		},
+		// 3
+		{
+			ann:        types.BackendAnnotations{TunnelUpgrade: true, TunnelMaxconnServer: -1},
+			expTunnel:  hatypes.BackendTunnelConfig{Enabled: true},
+			expLogging: "WARN ignoring invalid tunnel-maxconn-server '-1' on ingress 'default/ing1'",
+		},
+		// 4
+		{
+			ann:        types.BackendAnnotations{TunnelUpgrade: true},
+			modeTCP:    true,
+			expTunnel:  hatypes.BackendTunnelConfig{},
+			expLogging: "WARN ignoring tunnel-upgrade on ingress 'default/ing1': backend is using tcp mode",
+		},
+	}
+
+	for i, test := range testCase {
+		c := setup(t)
+		u := c.createUpdater()
+		d := c.createBackendData("default", "ing1", &test.ann)
+		d.backend.ModeTCP = test.modeTCP
+		u.buildBackendTunnel(d)
+		if !reflect.DeepEqual(test.expTunnel, d.backend.Tunnel) {
+			t.Errorf("config %d differs - expected: %+v - actual: %+v", i, test.expTunnel, d.backend.Tunnel)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
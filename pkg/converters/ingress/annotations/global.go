@@ -18,9 +18,19 @@ package annotations
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/acme"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
 )
 
+var acmeEndpoints = map[string]string{
+	"v2":         "https://acme-v02.api.letsencrypt.org/directory",
+	"v2-staging": "https://acme-staging-v02.api.letsencrypt.org/directory",
+}
+
 func (c *updater) buildGlobalProc(d *globalData) {
 	balance := d.config.NbprocBalance
 	if balance < 1 {
@@ -98,7 +108,27 @@ func (c *updater) buildGlobalSSL(d *globalData) {
 	}
 	d.global.SSL.DHParam.DefaultMaxSize = d.config.SSLDHDefaultMaxSize
 	d.global.SSL.Engine = d.config.SSLEngine
+	d.global.SSL.HeadersPrefix = d.config.SSLHeadersPrefix
 	d.global.SSL.ModeAsync = d.config.SSLModeAsync
+	if d.config.TLSTicketKeys != "" {
+		content, err := c.cache.GetSecretContent(d.config.TLSTicketKeys, ssl.TicketKeysSecretKey)
+		if err == nil {
+			d.global.SSL.TicketKeys, err = ssl.ParseTicketKeys(content)
+		}
+		if err != nil {
+			c.logger.Error("error reading TLS ticket keys: %v", err)
+		}
+	}
+	if len(d.global.SSL.TicketKeys) > 0 {
+		// no-tls-tickets is a default option, it would disable the configured keys
+		var options []string
+		for _, opt := range strings.Fields(d.global.SSL.Options) {
+			if opt != "no-tls-tickets" {
+				options = append(options, opt)
+			}
+		}
+		d.global.SSL.Options = strings.Join(options, " ")
+	}
 }
 
 func (c *updater) buildGlobalModSecurity(d *globalData) {
@@ -113,3 +143,44 @@ func (c *updater) buildGlobalCustomConfig(d *globalData) {
 		d.global.CustomConfig = strings.Split(strings.TrimRight(d.config.ConfigGlobal, "\n"), "\n")
 	}
 }
+
+func (c *updater) buildGlobalErrorPages(d *globalData) {
+	if d.config.ErrorPages == "" {
+		return
+	}
+	d.global.ErrorPages = c.readErrorPages(c.logger, d.config.ErrorPages, "global config")
+}
+
+func (c *updater) buildGlobalAcme(d *globalData) {
+	endpoint := d.config.AcmeEndpoint
+	if endpoint == "" {
+		return
+	}
+	if c.acmeSocket == "" {
+		c.logger.Warn("ignoring acme-endpoint configmap option, the ACME server is disabled (--acme-server command-line option)")
+		return
+	}
+	if !d.config.AcmeTermsAgreed {
+		c.logger.Warn("ignoring ACME config, terms of service should be agreed with acme-terms-agreed configmap option")
+		return
+	}
+	if url, found := acmeEndpoints[endpoint]; found {
+		endpoint = url
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		c.logger.Warn("ignoring invalid acme-endpoint configmap option: %s", endpoint)
+		return
+	}
+	expiring := d.config.AcmeExpiring
+	if expiring <= 0 {
+		c.logger.Warn("invalid value of acme-expiring configmap option (%v), using 30", expiring)
+		expiring = 30
+	}
+	d.global.Acme.Enabled = true
+	d.global.Acme.Emails = d.config.AcmeEmails
+	d.global.Acme.Endpoint = endpoint
+	d.global.Acme.Expiring = time.Duration(expiring) * 24 * time.Hour
+	d.global.Acme.Prefix = acme.ChallengePrefix
+	d.global.Acme.Socket = c.acmeSocket
+	d.global.Acme.TermsAgreed = true
+}
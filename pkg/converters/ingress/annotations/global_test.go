@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package annotations
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+func TestAcme(t *testing.T) {
+	testCase := []struct {
+		config     types.ConfigGlobals
+		socket     string
+		expected   hatypes.AcmeConfig
+		expLogging string
+	}{
+		// 0
+		{
+			config: types.ConfigGlobals{},
+			socket: "/var/run/acme.sock",
+		},
+		// 1
+		{
+			config:     types.ConfigGlobals{AcmeEndpoint: "v2", AcmeTermsAgreed: true},
+			expLogging: "WARN ignoring acme-endpoint configmap option, the ACME server is disabled (--acme-server command-line option)",
+		},
+		// 2
+		{
+			config:     types.ConfigGlobals{AcmeEndpoint: "v2"},
+			socket:     "/var/run/acme.sock",
+			expLogging: "WARN ignoring ACME config, terms of service should be agreed with acme-terms-agreed configmap option",
+		},
+		// 3
+		{
+			config:     types.ConfigGlobals{AcmeEndpoint: "acme.local", AcmeTermsAgreed: true, AcmeExpiring: 30},
+			socket:     "/var/run/acme.sock",
+			expLogging: "WARN ignoring invalid acme-endpoint configmap option: acme.local",
+		},
+		// 4
+		{
+			config: types.ConfigGlobals{AcmeEndpoint: "v2-staging", AcmeEmails: "admin@example.com", AcmeTermsAgreed: true, AcmeExpiring: 20},
+			socket: "/var/run/acme.sock",
+			expected: hatypes.AcmeConfig{
+				Enabled:     true,
+				Emails:      "admin@example.com",
+				Endpoint:    "https://acme-staging-v02.api.letsencrypt.org/directory",
+				Expiring:    20 * 24 * time.Hour,
+				Prefix:      "/.well-known/acme-challenge/",
+				Socket:      "/var/run/acme.sock",
+				TermsAgreed: true,
+			},
+		},
+		// 5
+		{
+			config: types.ConfigGlobals{AcmeEndpoint: "https://localhost:14000/dir", AcmeTermsAgreed: true},
+			socket: "/var/run/acme.sock",
+			expected: hatypes.AcmeConfig{
+				Enabled:     true,
+				Endpoint:    "https://localhost:14000/dir",
+				Expiring:    30 * 24 * time.Hour,
+				Prefix:      "/.well-known/acme-challenge/",
+				Socket:      "/var/run/acme.sock",
+				TermsAgreed: true,
+			},
+			expLogging: "WARN invalid value of acme-expiring configmap option (0), using 30",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		u := c.createUpdater()
+		u.acmeSocket = test.socket
+		d := &globalData{
+			global: &hatypes.Global{},
+			config: &types.Config{ConfigGlobals: test.config},
+		}
+		u.buildGlobalAcme(d)
+		if !reflect.DeepEqual(d.global.Acme, test.expected) {
+			t.Errorf("expected acme config %+v on %d but was %+v", test.expected, i, d.global.Acme)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestTLSTicketKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 48))
+	testCase := []struct {
+		secret     string
+		content    string
+		expected   []string
+		expOptions string
+		expLogging string
+	}{
+		// 0
+		{
+			expOptions: "no-sslv3 no-tls-tickets",
+		},
+		// 1
+		{
+			secret:     "ingress/tickets",
+			content:    key + "\n" + key + "\n" + key + "\n",
+			expected:   []string{key, key, key},
+			expOptions: "no-sslv3",
+		},
+		// 2
+		{
+			secret:     "ingress/tickets",
+			content:    key + "\n" + key + "\n",
+			expOptions: "no-sslv3 no-tls-tickets",
+			expLogging: "ERROR error reading TLS ticket keys: at least 3 ticket keys are needed, found 2",
+		},
+		// 3
+		{
+			secret:     "ingress/notfound",
+			expOptions: "no-sslv3 no-tls-tickets",
+			expLogging: "ERROR error reading TLS ticket keys: secret not found: 'ingress/notfound'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.SecretContent = ing_helper.SecretContent{
+			"ingress/tickets": {"tls-ticket-keys": []byte(test.content)},
+		}
+		d := &globalData{
+			global: &hatypes.Global{},
+			config: &types.Config{ConfigGlobals: types.ConfigGlobals{
+				SSLOptions:    "no-sslv3 no-tls-tickets",
+				TLSTicketKeys: test.secret,
+			}},
+		}
+		c.createUpdater().buildGlobalSSL(d)
+		if !reflect.DeepEqual(d.global.SSL.TicketKeys, test.expected) {
+			t.Errorf("expected ticket keys %v on %d but was %v", test.expected, i, d.global.SSL.TicketKeys)
+		}
+		if d.global.SSL.Options != test.expOptions {
+			t.Errorf("expected ssl-options '%s' on %d but was '%s'", test.expOptions, i, d.global.SSL.Options)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
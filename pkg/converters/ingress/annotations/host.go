@@ -16,6 +16,19 @@ limitations under the License.
 
 package annotations
 
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/utils"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+)
+
 func (c *updater) buildHostAuthTLS(d *hostData) {
 	if d.ann.AuthTLSSecret == "" {
 		return
@@ -24,29 +37,119 @@ func (c *updater) buildHostAuthTLS(d *hostData) {
 	if verify == "off" {
 		return
 	}
-	if cafile, err := c.cache.GetCASecretPath(d.ann.AuthTLSSecret); err == nil {
+	cafile, crlfile, err := c.cache.GetCASecretPath(d.ann.AuthTLSSecret)
+	if err != nil && cafile.Filename != "" {
+		// the crl is optional, an invalid one shouldn't disable the client verification
+		c.loggerFor(d).Warn("ignoring CRL of secret '%s' on %s: %v", d.ann.AuthTLSSecret, d.ann.Source, err)
+		crlfile = ingtypes.File{}
+		err = nil
+	}
+	if err == nil {
 		d.host.TLS.CAFilename = cafile.Filename
 		d.host.TLS.CAHash = cafile.SHA1Hash
+		d.host.TLS.CRLFilename = crlfile.Filename
+		d.host.TLS.CRLHash = crlfile.SHA1Hash
 		d.host.TLS.CAVerifyOptional = verify == "optional" || verify == "optional_no_ca"
 		d.host.TLS.CAErrorPage = d.ann.AuthTLSErrorPage
 		d.host.TLS.AddCertHeader = d.ann.AuthTLSCertHeader
+		d.host.TLS.CertHeaders = c.readCertHeaders(d)
 	} else {
-		c.logger.Error("error building TLS auth config: %v", err)
+		c.loggerFor(d).Error("error building TLS auth config: %v", err)
 	}
 }
 
+// certHeaders are the supported client certificate fields, see
+// HostTLSConfig.CertHeaders and the frontend section of the template
+var certHeaders = map[string]bool{
+	"cn":        true,
+	"dn":        true,
+	"issuer":    true,
+	"serial":    true,
+	"sha1":      true,
+	"sha256":    true,
+	"notbefore": true,
+	"notafter":  true,
+	"cert":      true,
+	"pem":       true,
+}
+
+// certHeadersVersion are the fields which need a newer HAProxy
+// than the oldest one supported by the template
+var certHeadersVersion = map[string]string{
+	// sha2 converter
+	"sha256": "2.1",
+}
+
+func (c *updater) readCertHeaders(d *hostData) []string {
+	var headers []string
+	added := map[string]bool{}
+	addHeader := func(header string) {
+		if !added[header] {
+			headers = append(headers, header)
+			added[header] = true
+		}
+	}
+	for _, header := range strings.Split(d.ann.AuthTLSCertHeaders, ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header == "" {
+			continue
+		}
+		if !certHeaders[header] {
+			c.loggerFor(d).Warn("ignoring invalid auth-tls-cert-headers field '%s' on %s", header, d.ann.Source)
+			continue
+		}
+		if version := certHeadersVersion[header]; !haproxy.VersionAtLeast(c.haproxyVersion, version) {
+			c.loggerFor(d).Warn("ignoring auth-tls-cert-headers field '%s' on %s: it needs HAProxy %s or newer, found %s",
+				header, d.ann.Source, version, c.haproxyVersion)
+			continue
+		}
+		addHeader(header)
+	}
+	if d.ann.AuthTLSCertHeader {
+		// auth-tls-cert-header is the same of adding `cert` to the list
+		addHeader("cert")
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+func (c *updater) buildHostErrorPages(d *hostData) {
+	globalPages := c.haproxy.Global().ErrorPages
+	if d.ann.ErrorPages == "" {
+		d.host.ErrorPages = globalPages
+		return
+	}
+	configMapName := utils.FullQualifiedName(d.ann.Source.Namespace, d.ann.ErrorPages)
+	hostPages := c.readErrorPages(c.loggerFor(d), configMapName, d.ann.Source.String())
+	codes := make(map[int]bool, len(hostPages))
+	for _, page := range hostPages {
+		codes[page.Code] = true
+	}
+	pages := hostPages
+	for _, page := range globalPages {
+		// pages from the host take precedence over global ones
+		if !codes[page.Code] {
+			pages = append(pages, page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Code < pages[j].Code
+	})
+	d.host.ErrorPages = pages
+}
+
 func (c *updater) buildHostSSLPassthrough(d *hostData) {
 	if !d.ann.SSLPassthrough {
 		return
 	}
 	rootPath := d.host.FindPath("/")
 	if rootPath == nil {
-		c.logger.Warn("skipping SSL of %s: root path was not configured", d.ann.Source)
+		c.loggerFor(d).Warn("skipping SSL of %s: root path was not configured", d.ann.Source)
 		return
 	}
 	for _, path := range d.host.Paths {
 		if path.Path != "/" {
-			c.logger.Warn("ignoring path '%s' from '%s': ssl-passthrough only support root path", path.Path, d.ann.Source)
+			c.loggerFor(d).Warn("ignoring path '%s' from '%s': ssl-passthrough only support root path", path.Path, d.ann.Source)
 		}
 	}
 	if d.ann.SSLPassthroughHTTPPort != 0 {
@@ -56,3 +159,191 @@ func (c *updater) buildHostSSLPassthrough(d *hostData) {
 	rootPath.Backend.ModeTCP = true
 	d.host.SSLPassthrough = true
 }
+
+var tlsVersions = map[string]int{
+	"SSLv3":   0,
+	"TLSv1.0": 1,
+	"TLSv1.1": 2,
+	"TLSv1.2": 3,
+	"TLSv1.3": 4,
+}
+
+// tlsNoVersions are the global ssl-options which disable a TLS version
+var tlsNoVersions = map[string]string{
+	"no-sslv3":  "SSLv3",
+	"no-tlsv10": "TLSv1.0",
+	"no-tlsv11": "TLSv1.1",
+	"no-tlsv12": "TLSv1.2",
+	"no-tlsv13": "TLSv1.3",
+}
+
+func (c *updater) buildHostTLSConfig(d *hostData) {
+	if d.ann.TLSCiphers == "" && d.ann.TLSCipherSuites == "" && d.ann.TLSMinVersion == "" && d.ann.TLSMaxVersion == "" {
+		return
+	}
+	if d.host.Hostname == "*" {
+		c.loggerFor(d).Warn("ignoring TLS config on %s: default host does not support TLS config", d.ann.Source)
+		return
+	}
+	if d.host.SSLPassthrough {
+		c.loggerFor(d).Warn("ignoring TLS config on %s: ssl-passthrough does not support TLS config", d.ann.Source)
+		return
+	}
+	minVersion := d.ann.TLSMinVersion
+	maxVersion := d.ann.TLSMaxVersion
+	if _, found := tlsVersions[minVersion]; minVersion != "" && !found {
+		c.loggerFor(d).Warn("ignoring invalid tls-min-version '%s' on %s", minVersion, d.ann.Source)
+		minVersion = ""
+	}
+	if _, found := tlsVersions[maxVersion]; maxVersion != "" && !found {
+		c.loggerFor(d).Warn("ignoring invalid tls-max-version '%s' on %s", maxVersion, d.ann.Source)
+		maxVersion = ""
+	}
+	if minVersion != "" && maxVersion != "" && tlsVersions[minVersion] > tlsVersions[maxVersion] {
+		c.loggerFor(d).Warn("ignoring TLS versions on %s: tls-min-version '%s' is greater than tls-max-version '%s'", d.ann.Source, minVersion, maxVersion)
+		minVersion = ""
+		maxVersion = ""
+	}
+	if (minVersion != "" || maxVersion != "") && strings.Contains(c.haproxy.Global().SSL.Options, "force-") {
+		c.loggerFor(d).Warn("ignoring TLS versions on %s: global ssl-options '%s' forces a TLS version", d.ann.Source, c.haproxy.Global().SSL.Options)
+		minVersion = ""
+		maxVersion = ""
+	}
+	if minVersion != "" || maxVersion != "" {
+		for _, opt := range strings.Fields(c.haproxy.Global().SSL.Options) {
+			if version := tlsNoVersions[opt]; version != "" && (version == minVersion || version == maxVersion) {
+				c.loggerFor(d).Warn("ignoring TLS versions on %s: global ssl-options '%s' disables %s", d.ann.Source, c.haproxy.Global().SSL.Options, version)
+				minVersion = ""
+				maxVersion = ""
+				break
+			}
+		}
+	}
+	// ciphers and ciphersuites are written in a crt-list line, a whitespace would split the option
+	ciphers := d.ann.TLSCiphers
+	if strings.ContainsAny(ciphers, " \t[]") {
+		c.loggerFor(d).Warn("ignoring invalid tls-ciphers '%s' on %s", ciphers, d.ann.Source)
+		ciphers = ""
+	}
+	cipherSuites := d.ann.TLSCipherSuites
+	if strings.ContainsAny(cipherSuites, " \t[]") {
+		c.loggerFor(d).Warn("ignoring invalid tls-ciphersuites '%s' on %s", cipherSuites, d.ann.Source)
+		cipherSuites = ""
+	}
+	if ciphers != "" && minVersion == "TLSv1.3" {
+		c.loggerFor(d).Warn("ignoring tls-ciphers on %s: TLS 1.2 ciphers are not used if tls-min-version is TLSv1.3", d.ann.Source)
+		ciphers = ""
+	}
+	if cipherSuites != "" && maxVersion != "" && tlsVersions[maxVersion] < tlsVersions["TLSv1.3"] {
+		c.loggerFor(d).Warn("ignoring tls-ciphersuites on %s: TLS 1.3 ciphersuites are not used if tls-max-version is '%s'", d.ann.Source, maxVersion)
+		cipherSuites = ""
+	}
+	d.host.TLS.Ciphers = ciphers
+	d.host.TLS.CipherSuites = cipherSuites
+	d.host.TLS.MinVersion = minVersion
+	d.host.TLS.MaxVersion = maxVersion
+}
+
+const defaultMaintenancePage = `<html><body><h1>503 Service Unavailable</h1>
+This service is under maintenance, please try again later.
+</body></html>
+`
+
+func (c *updater) buildHostMaintenance(d *hostData) {
+	if !d.ann.Maintenance {
+		return
+	}
+	if d.host.Hostname == "*" {
+		c.loggerFor(d).Warn("ignoring maintenance mode on %s: default host does not support maintenance mode", d.ann.Source)
+		return
+	}
+	if d.host.SSLPassthrough {
+		c.loggerFor(d).Warn("ignoring maintenance mode on %s: ssl-passthrough does not support maintenance mode", d.ann.Source)
+		return
+	}
+	content := defaultMaintenancePage
+	if d.ann.MaintenancePage != "" {
+		// maintenance-page is `<configmap-name>/<key>`
+		page := strings.Split(d.ann.MaintenancePage, "/")
+		if len(page) != 2 {
+			c.loggerFor(d).Warn("ignoring maintenance mode on %s: invalid maintenance page '%s'", d.ann.Source, d.ann.MaintenancePage)
+			return
+		}
+		configMapName := utils.FullQualifiedName(d.ann.Source.Namespace, page[0])
+		data, err := c.cache.GetConfigMapContent(configMapName)
+		if err != nil {
+			c.loggerFor(d).Error("error reading maintenance page on %s: %v", d.ann.Source, err)
+			return
+		}
+		pageContent, found := data[page[1]]
+		if !found {
+			c.loggerFor(d).Error("error reading maintenance page on %s: configmap '%s' does not have key '%s'", d.ann.Source, configMapName, page[1])
+			return
+		}
+		content = pageContent
+	}
+	var headers []string
+	if d.ann.MaintenanceRetryAfter > 0 {
+		headers = append(headers, fmt.Sprintf("Retry-After: %d", d.ann.MaintenanceRetryAfter))
+	}
+	var bypass []string
+	if d.ann.MaintenanceBypass != "" {
+		for _, cidr := range strings.Split(d.ann.MaintenanceBypass, ",") {
+			cidr = strings.TrimSpace(cidr)
+			if cidr == "" {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				if net.ParseIP(cidr) == nil {
+					c.loggerFor(d).Warn("skipping invalid maintenance bypass source '%s' on %s", cidr, d.ann.Source)
+					continue
+				}
+			}
+			bypass = append(bypass, cidr)
+		}
+	}
+	d.host.Maintenance.Enabled = true
+	d.host.Maintenance.BypassCIDRs = bypass
+	d.host.Maintenance.Page = c.haproxy.AcquireErrorPage(503, headers, content)
+}
+
+var supportedRedirectCodes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+func (c *updater) buildPathRedirect(d *pathData) {
+	// temporal has precedence, the same behavior of v0.7
+	var location string
+	var code int
+	if d.ann.TemporalRedirect != "" {
+		location = d.ann.TemporalRedirect
+		code = http.StatusFound
+	} else if d.ann.PermanentRedirect != "" {
+		location = d.ann.PermanentRedirect
+		code = http.StatusMovedPermanently
+	} else {
+		return
+	}
+	if u, err := url.Parse(location); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.loggerFor(d).Warn("ignoring redirect of path '%s' on %s: invalid URL '%s'", d.path.Path, d.ann.Source, location)
+		return
+	}
+	if d.ann.RedirectCode != 0 {
+		if supportedRedirectCodes[d.ann.RedirectCode] {
+			code = d.ann.RedirectCode
+		} else {
+			c.loggerFor(d).Warn("ignoring invalid redirect code '%d' on %s, using %d", d.ann.RedirectCode, d.ann.Source, code)
+		}
+	}
+	if d.ann.RedirectKeepURI {
+		// the original URI, starting with a slash, is concatenated to the prefix
+		location = strings.TrimRight(location, "/")
+	}
+	d.path.Redirect.Code = code
+	d.path.Redirect.KeepURI = d.ann.RedirectKeepURI
+	d.path.Redirect.URL = location
+}
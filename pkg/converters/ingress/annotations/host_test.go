@@ -0,0 +1,497 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package annotations
+
+import (
+	"reflect"
+	"testing"
+
+	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+func TestAuthTLS(t *testing.T) {
+	testCase := []struct {
+		ann        types.HostAnnotations
+		version    string
+		expected   hatypes.HostTLSConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 1
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/var/haproxy/ssl/ca/default_ca.pem",
+			},
+		},
+		// 2
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca-crl", AuthTLSVerifyClient: "optional"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename:       "/var/haproxy/ssl/ca/default_ca-crl.pem",
+				CAVerifyOptional: true,
+				CRLFilename:      "/var/haproxy/ssl/ca/crl-default_ca-crl.pem",
+			},
+		},
+		// 3
+		{
+			ann:      types.HostAnnotations{AuthTLSSecret: "default/ca-crl", AuthTLSVerifyClient: "off"},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 4
+		{
+			ann:        types.HostAnnotations{AuthTLSSecret: "default/missing"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "ERROR error building TLS auth config: secret not found: 'default/missing'",
+		},
+		// 5
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca", AuthTLSCertHeaders: "sha1,dn,cn"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename:  "/var/haproxy/ssl/ca/default_ca.pem",
+				CertHeaders: []string{"cn", "dn", "sha1"},
+			},
+		},
+		// 6
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca", AuthTLSCertHeaders: "DN, sha256,,invalid,dn", AuthTLSCertHeader: true},
+			expected: hatypes.HostTLSConfig{
+				AddCertHeader: true,
+				CAFilename:    "/var/haproxy/ssl/ca/default_ca.pem",
+				CertHeaders:   []string{"cert", "dn", "sha256"},
+			},
+			expLogging: "WARN ignoring invalid auth-tls-cert-headers field 'invalid' on ingress 'default/ing1'",
+		},
+		// 7
+		{
+			ann:     types.HostAnnotations{AuthTLSSecret: "default/ca", AuthTLSCertHeaders: "sha1,sha256"},
+			version: "2.0.10",
+			expected: hatypes.HostTLSConfig{
+				CAFilename:  "/var/haproxy/ssl/ca/default_ca.pem",
+				CertHeaders: []string{"sha1"},
+			},
+			expLogging: "WARN ignoring auth-tls-cert-headers field 'sha256' on ingress 'default/ing1': it needs HAProxy 2.1 or newer, found 2.0.10",
+		},
+		// 8
+		{
+			ann:     types.HostAnnotations{AuthTLSSecret: "default/ca", AuthTLSCertHeaders: "sha1,sha256"},
+			version: "2.1.0",
+			expected: hatypes.HostTLSConfig{
+				CAFilename:  "/var/haproxy/ssl/ca/default_ca.pem",
+				CertHeaders: []string{"sha1", "sha256"},
+			},
+		},
+		// 9
+		{
+			ann: types.HostAnnotations{AuthTLSSecret: "default/ca-invalid-crl"},
+			expected: hatypes.HostTLSConfig{
+				CAFilename: "/var/haproxy/ssl/ca/default_ca-invalid-crl.pem",
+			},
+			expLogging: "WARN ignoring CRL of secret 'default/ca-invalid-crl' on ingress 'default/ing1': invalid crl of secret 'default/ca-invalid-crl'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.SecretCAPath = map[string]string{
+			"default/ca":             "/var/haproxy/ssl/ca/default_ca.pem",
+			"default/ca-crl":         "/var/haproxy/ssl/ca/default_ca-crl.pem",
+			"default/ca-invalid-crl": "/var/haproxy/ssl/ca/default_ca-invalid-crl.pem",
+		}
+		c.cache.SecretCRLPath = map[string]string{
+			"default/ca-crl":         "/var/haproxy/ssl/ca/crl-default_ca-crl.pem",
+			"default/ca-invalid-crl": "",
+		}
+		d := c.createHostData("default", "ing1", &test.ann)
+		u := c.createUpdater()
+		u.haproxyVersion = test.version
+		u.buildHostAuthTLS(d)
+		// hashes are calculated by the cache, only the filenames are compared
+		d.host.TLS.CAHash = ""
+		d.host.TLS.CRLHash = ""
+		if !reflect.DeepEqual(d.host.TLS, test.expected) {
+			t.Errorf("expected TLS auth config %+v on %d but was %+v", test.expected, i, d.host.TLS)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	testCase := []struct {
+		global     string
+		ann        types.HostAnnotations
+		expPages   map[int]string
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expPages: map[int]string{},
+		},
+		// 1
+		{
+			global:   "ingress/errors",
+			ann:      types.HostAnnotations{},
+			expPages: map[int]string{503: "global 503"},
+		},
+		// 2
+		{
+			ann:      types.HostAnnotations{ErrorPages: "pages"},
+			expPages: map[int]string{502: "host 502", 503: "host 503"},
+			expLogging: `
+WARN ignoring unsupported error page '404' of configmap 'default/pages' on ingress 'default/ing1'
+WARN ignoring unsupported error page 'none' of configmap 'default/pages' on ingress 'default/ing1'`,
+		},
+		// 3
+		{
+			global:   "ingress/errors",
+			ann:      types.HostAnnotations{ErrorPages: "pages502"},
+			expPages: map[int]string{502: "host 502", 503: "global 503"},
+		},
+		// 4
+		{
+			global:     "ingress/errors",
+			ann:        types.HostAnnotations{ErrorPages: "missing"},
+			expPages:   map[int]string{503: "global 503"},
+			expLogging: "ERROR error reading error pages on ingress 'default/ing1': configmap not found: 'default/missing'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.ConfigMapContent = ing_helper.ConfigMapContent{
+			"ingress/errors": {
+				"503": "global 503",
+			},
+			"default/pages": {
+				"404":  "host 404",
+				"502":  "host 502",
+				"503":  "host 503",
+				"none": "invalid",
+			},
+			"default/pages502": {
+				"502": "host 502",
+			},
+		}
+		u := c.createUpdater()
+		u.buildGlobalErrorPages(&globalData{
+			global: c.haproxy.Global(),
+			config: &types.Config{ConfigGlobals: types.ConfigGlobals{ErrorPages: test.global}},
+		})
+		d := c.createHostData("default", "ing1", &test.ann)
+		u.buildHostErrorPages(d)
+		pages := map[int]string{}
+		lastCode := 0
+		for _, page := range d.host.ErrorPages {
+			if page.Code <= lastCode {
+				t.Errorf("error pages should be sorted on %d", i)
+			}
+			lastCode = page.Code
+			pages[page.Code] = page.Content
+		}
+		if len(pages) != len(test.expPages) {
+			t.Errorf("expected %d error pages on %d but was %d", len(test.expPages), i, len(pages))
+		}
+		for code, content := range test.expPages {
+			if pages[code] != content {
+				t.Errorf("error page %d differs on %d: expected '%s' but was '%s'", code, i, content, pages[code])
+			}
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	testCase := []struct {
+		hostname       string
+		sslPassthrough bool
+		sslOptions     string
+		ann            types.HostAnnotations
+		expected       hatypes.HostTLSConfig
+		expLogging     string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expected: hatypes.HostTLSConfig{},
+		},
+		// 1
+		{
+			ann:      types.HostAnnotations{TLSMinVersion: "TLSv1.2", TLSMaxVersion: "TLSv1.2", TLSCiphers: "ECDHE-RSA-AES128-GCM-SHA256"},
+			expected: hatypes.HostTLSConfig{MinVersion: "TLSv1.2", MaxVersion: "TLSv1.2", Ciphers: "ECDHE-RSA-AES128-GCM-SHA256"},
+		},
+		// 2
+		{
+			ann:      types.HostAnnotations{TLSMinVersion: "TLSv1.3", TLSCipherSuites: "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384"},
+			expected: hatypes.HostTLSConfig{MinVersion: "TLSv1.3", CipherSuites: "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384"},
+		},
+		// 3
+		{
+			ann:      types.HostAnnotations{TLSMinVersion: "TLSv1.4", TLSMaxVersion: "tls1.2"},
+			expected: hatypes.HostTLSConfig{},
+			expLogging: `
+WARN ignoring invalid tls-min-version 'TLSv1.4' on ingress 'default/ing1'
+WARN ignoring invalid tls-max-version 'tls1.2' on ingress 'default/ing1'`,
+		},
+		// 4
+		{
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.3", TLSMaxVersion: "TLSv1.2"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring TLS versions on ingress 'default/ing1': tls-min-version 'TLSv1.3' is greater than tls-max-version 'TLSv1.2'",
+		},
+		// 5
+		{
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.3", TLSCiphers: "ECDHE-RSA-AES128-GCM-SHA256"},
+			expected:   hatypes.HostTLSConfig{MinVersion: "TLSv1.3"},
+			expLogging: "WARN ignoring tls-ciphers on ingress 'default/ing1': TLS 1.2 ciphers are not used if tls-min-version is TLSv1.3",
+		},
+		// 6
+		{
+			ann:        types.HostAnnotations{TLSMaxVersion: "TLSv1.2", TLSCipherSuites: "TLS_AES_128_GCM_SHA256"},
+			expected:   hatypes.HostTLSConfig{MaxVersion: "TLSv1.2"},
+			expLogging: "WARN ignoring tls-ciphersuites on ingress 'default/ing1': TLS 1.3 ciphersuites are not used if tls-max-version is 'TLSv1.2'",
+		},
+		// 7
+		{
+			ann:      types.HostAnnotations{TLSCiphers: "ECDHE-RSA-AES128-GCM-SHA256 verify none", TLSCipherSuites: "[TLS_AES_128_GCM_SHA256]"},
+			expected: hatypes.HostTLSConfig{},
+			expLogging: `
+WARN ignoring invalid tls-ciphers 'ECDHE-RSA-AES128-GCM-SHA256 verify none' on ingress 'default/ing1'
+WARN ignoring invalid tls-ciphersuites '[TLS_AES_128_GCM_SHA256]' on ingress 'default/ing1'`,
+		},
+		// 8
+		{
+			sslOptions: "no-sslv3 force-tlsv12",
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.3", TLSCipherSuites: "TLS_AES_128_GCM_SHA256"},
+			expected:   hatypes.HostTLSConfig{CipherSuites: "TLS_AES_128_GCM_SHA256"},
+			expLogging: "WARN ignoring TLS versions on ingress 'default/ing1': global ssl-options 'no-sslv3 force-tlsv12' forces a TLS version",
+		},
+		// 9
+		{
+			hostname:   "*",
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.2"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring TLS config on ingress 'default/ing1': default host does not support TLS config",
+		},
+		// 10
+		{
+			sslPassthrough: true,
+			ann:            types.HostAnnotations{TLSMinVersion: "TLSv1.2"},
+			expected:       hatypes.HostTLSConfig{},
+			expLogging:     "WARN ignoring TLS config on ingress 'default/ing1': ssl-passthrough does not support TLS config",
+		},
+		// 11
+		{
+			sslOptions: "no-sslv3 no-tlsv12",
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.2"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring TLS versions on ingress 'default/ing1': global ssl-options 'no-sslv3 no-tlsv12' disables TLSv1.2",
+		},
+		// 12
+		{
+			sslOptions: "no-sslv3 no-tlsv13",
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.2", TLSMaxVersion: "TLSv1.3"},
+			expected:   hatypes.HostTLSConfig{},
+			expLogging: "WARN ignoring TLS versions on ingress 'default/ing1': global ssl-options 'no-sslv3 no-tlsv13' disables TLSv1.3",
+		},
+		// 13
+		{
+			sslOptions: "no-sslv3 no-tlsv10 no-tlsv11",
+			ann:        types.HostAnnotations{TLSMinVersion: "TLSv1.2", TLSMaxVersion: "TLSv1.3"},
+			expected:   hatypes.HostTLSConfig{MinVersion: "TLSv1.2", MaxVersion: "TLSv1.3"},
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.haproxy.Global().SSL.Options = test.sslOptions
+		d := c.createHostData("default", "ing1", &test.ann)
+		d.host.Hostname = test.hostname
+		if d.host.Hostname == "" {
+			d.host.Hostname = "d1.local"
+		}
+		d.host.SSLPassthrough = test.sslPassthrough
+		c.createUpdater().buildHostTLSConfig(d)
+		if !reflect.DeepEqual(d.host.TLS, test.expected) {
+			t.Errorf("expected TLS config %+v on %d but was %+v", test.expected, i, d.host.TLS)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	testCase := []struct {
+		hostname   string
+		ann        types.HostAnnotations
+		expEnabled bool
+		expBypass  []string
+		expHeaders []string
+		expContent string
+		expLogging string
+	}{
+		// 0
+		{
+			ann: types.HostAnnotations{},
+		},
+		// 1
+		{
+			ann:        types.HostAnnotations{Maintenance: true, MaintenanceRetryAfter: 300},
+			expEnabled: true,
+			expHeaders: []string{"Retry-After: 300"},
+			expContent: defaultMaintenancePage,
+		},
+		// 2
+		{
+			ann:        types.HostAnnotations{Maintenance: true, MaintenancePage: "pages/maintenance.html"},
+			expEnabled: true,
+			expContent: "<h1>maintenance</h1>",
+		},
+		// 3
+		{
+			ann:        types.HostAnnotations{Maintenance: true, MaintenancePage: "pages/missing.html"},
+			expLogging: "ERROR error reading maintenance page on ingress 'default/ing1': configmap 'default/pages' does not have key 'missing.html'",
+		},
+		// 4
+		{
+			ann:        types.HostAnnotations{Maintenance: true, MaintenancePage: "pages"},
+			expLogging: "WARN ignoring maintenance mode on ingress 'default/ing1': invalid maintenance page 'pages'",
+		},
+		// 5
+		{
+			ann:        types.HostAnnotations{Maintenance: true, MaintenanceBypass: "10.0.0.0/8, 192.168.1.1,invalid"},
+			expEnabled: true,
+			expBypass:  []string{"10.0.0.0/8", "192.168.1.1"},
+			expContent: defaultMaintenancePage,
+			expLogging: "WARN skipping invalid maintenance bypass source 'invalid' on ingress 'default/ing1'",
+		},
+		// 6
+		{
+			hostname:   "*",
+			ann:        types.HostAnnotations{Maintenance: true},
+			expLogging: "WARN ignoring maintenance mode on ingress 'default/ing1': default host does not support maintenance mode",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		c.cache.ConfigMapContent = ing_helper.ConfigMapContent{
+			"default/pages": {
+				"maintenance.html": "<h1>maintenance</h1>",
+			},
+		}
+		d := c.createHostData("default", "ing1", &test.ann)
+		d.host.Hostname = test.hostname
+		if d.host.Hostname == "" {
+			d.host.Hostname = "d1.local"
+		}
+		c.createUpdater().buildHostMaintenance(d)
+		maint := d.host.Maintenance
+		if maint.Enabled != test.expEnabled {
+			t.Errorf("expected maintenance enabled '%t' on %d but was '%t'", test.expEnabled, i, maint.Enabled)
+		}
+		if !reflect.DeepEqual(maint.BypassCIDRs, test.expBypass) {
+			t.Errorf("expected bypass %v on %d but was %v", test.expBypass, i, maint.BypassCIDRs)
+		}
+		if maint.Page != nil {
+			if !reflect.DeepEqual(maint.Page.Headers, test.expHeaders) {
+				t.Errorf("expected headers %v on %d but was %v", test.expHeaders, i, maint.Page.Headers)
+			}
+			if maint.Page.Content != test.expContent {
+				t.Errorf("expected content '%s' on %d but was '%s'", test.expContent, i, maint.Page.Content)
+			}
+		} else if test.expEnabled {
+			t.Errorf("expected a maintenance page on %d", i)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	testCase := []struct {
+		ann        types.HostAnnotations
+		expected   hatypes.HostRedirectConfig
+		expLogging string
+	}{
+		// 0
+		{
+			ann:      types.HostAnnotations{},
+			expected: hatypes.HostRedirectConfig{},
+		},
+		// 1
+		{
+			ann:      types.HostAnnotations{PermanentRedirect: "https://app.local/"},
+			expected: hatypes.HostRedirectConfig{Code: 301, URL: "https://app.local/"},
+		},
+		// 2
+		{
+			ann:      types.HostAnnotations{TemporalRedirect: "http://app.local/login"},
+			expected: hatypes.HostRedirectConfig{Code: 302, URL: "http://app.local/login"},
+		},
+		// 3
+		{
+			ann: types.HostAnnotations{
+				PermanentRedirect: "https://app1.local",
+				TemporalRedirect:  "https://app2.local",
+			},
+			expected: hatypes.HostRedirectConfig{Code: 302, URL: "https://app2.local"},
+		},
+		// 4
+		{
+			ann:      types.HostAnnotations{PermanentRedirect: "https://app.local/", RedirectKeepURI: true},
+			expected: hatypes.HostRedirectConfig{Code: 301, KeepURI: true, URL: "https://app.local"},
+		},
+		// 5
+		{
+			ann:      types.HostAnnotations{PermanentRedirect: "https://app.local", RedirectCode: 308},
+			expected: hatypes.HostRedirectConfig{Code: 308, URL: "https://app.local"},
+		},
+		// 6
+		{
+			ann:        types.HostAnnotations{PermanentRedirect: "https://app.local", RedirectCode: 200},
+			expected:   hatypes.HostRedirectConfig{Code: 301, URL: "https://app.local"},
+			expLogging: "WARN ignoring invalid redirect code '200' on ingress 'default/ing1', using 301",
+		},
+		// 7
+		{
+			ann:        types.HostAnnotations{PermanentRedirect: "app.local/login"},
+			expected:   hatypes.HostRedirectConfig{},
+			expLogging: "WARN ignoring redirect of path '/app' on ingress 'default/ing1': invalid URL 'app.local/login'",
+		},
+		// 8
+		{
+			ann:        types.HostAnnotations{TemporalRedirect: "ftp://app.local"},
+			expected:   hatypes.HostRedirectConfig{},
+			expLogging: "WARN ignoring redirect of path '/app' on ingress 'default/ing1': invalid URL 'ftp://app.local'",
+		},
+	}
+	for i, test := range testCase {
+		c := setup(t)
+		d := c.createPathData("default", "ing1", "/app", &test.ann)
+		c.createUpdater().buildPathRedirect(d)
+		if !reflect.DeepEqual(d.path.Redirect, test.expected) {
+			t.Errorf("expected redirect %+v on %d but was %+v", test.expected, i, d.path.Redirect)
+		}
+		c.logger.CompareLogging(test.expLogging)
+		c.teardown()
+	}
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package annotations
 
 import (
+	"sort"
+	"strconv"
+
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
@@ -27,22 +30,27 @@ import (
 type Updater interface {
 	UpdateGlobalConfig(global *hatypes.Global, config *ingtypes.Config)
 	UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnnotations)
+	UpdatePathConfig(path *hatypes.HostPath, ann *ingtypes.HostAnnotations)
 	UpdateBackendConfig(backend *hatypes.Backend, ann *ingtypes.BackendAnnotations)
 }
 
 // NewUpdater ...
-func NewUpdater(haproxy haproxy.Config, cache ingtypes.Cache, logger types.Logger) Updater {
+func NewUpdater(haproxy haproxy.Config, options *ingtypes.ConverterOptions) Updater {
 	return &updater{
-		haproxy: haproxy,
-		cache:   cache,
-		logger:  logger,
+		haproxy:        haproxy,
+		cache:          options.Cache,
+		logger:         options.Logger,
+		acmeSocket:     options.AcmeSocket,
+		haproxyVersion: options.HAProxyVersion,
 	}
 }
 
 type updater struct {
-	haproxy haproxy.Config
-	cache   ingtypes.Cache
-	logger  types.Logger
+	haproxy        haproxy.Config
+	cache          ingtypes.Cache
+	logger         types.Logger
+	acmeSocket     string
+	haproxyVersion string
 }
 
 type globalData struct {
@@ -55,16 +63,85 @@ type hostData struct {
 	ann  *ingtypes.HostAnnotations
 }
 
+type pathData struct {
+	path *hatypes.HostPath
+	ann  *ingtypes.HostAnnotations
+}
+
 type backData struct {
 	backend *hatypes.Backend
 	ann     *ingtypes.BackendAnnotations
 }
 
+// logFielder is implemented by the data whose annotations
+// are read from a resource, e.g. an ingress or a service
+type logFielder interface {
+	logFields() types.LogFields
+}
+
+func (d *hostData) logFields() types.LogFields {
+	fields := d.ann.Source.LogFields()
+	fields.Hostname = d.host.Hostname
+	return fields
+}
+
+func (d *pathData) logFields() types.LogFields {
+	fields := d.ann.Source.LogFields()
+	fields.Backend = d.path.BackendID
+	return fields
+}
+
+func (d *backData) logFields() types.LogFields {
+	fields := d.ann.Source.LogFields()
+	fields.Backend = d.backend.ID
+	return fields
+}
+
+// loggerFor returns the logger of the messages about the annotations
+// of d, which reports their source if the logger supports fields
+func (c *updater) loggerFor(d logFielder) types.Logger {
+	return types.WithFields(c.logger, d.logFields())
+}
+
 func copyHAProxyTime(dst *string, src string) {
 	// TODO validate
 	*dst = src
 }
 
+// supportedErrorPages are the status codes HAProxy can customize with errorfile
+var supportedErrorPages = map[int]bool{
+	200: true, 400: true, 403: true, 405: true, 408: true, 425: true,
+	429: true, 500: true, 502: true, 503: true, 504: true,
+}
+
+// readErrorPages reads status code and html content pairs from a configmap
+// and returns the acquired error pages sorted by status code
+func (c *updater) readErrorPages(logger types.Logger, configMapName, source string) []*hatypes.ErrorPage {
+	content, err := c.cache.GetConfigMapContent(configMapName)
+	if err != nil {
+		logger.Error("error reading error pages on %s: %v", source, err)
+		return nil
+	}
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pages := make([]*hatypes.ErrorPage, 0, len(content))
+	for _, key := range keys {
+		code, err := strconv.Atoi(key)
+		if err != nil || !supportedErrorPages[code] {
+			logger.Warn("ignoring unsupported error page '%s' of configmap '%s' on %s", key, configMapName, source)
+			continue
+		}
+		pages = append(pages, c.haproxy.AcquireErrorPage(code, nil, content[key]))
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Code < pages[j].Code
+	})
+	return pages
+}
+
 func (c *updater) UpdateGlobalConfig(global *hatypes.Global, config *ingtypes.Config) {
 	data := &globalData{
 		global: global,
@@ -82,6 +159,8 @@ func (c *updater) UpdateGlobalConfig(global *hatypes.Global, config *ingtypes.Co
 	c.buildGlobalSSL(data)
 	c.buildGlobalModSecurity(data)
 	c.buildGlobalCustomConfig(data)
+	c.buildGlobalErrorPages(data)
+	c.buildGlobalAcme(data)
 }
 
 func (c *updater) UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnnotations) {
@@ -95,7 +174,18 @@ func (c *updater) UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnnotat
 	host.Timeout.Client = ann.TimeoutClient
 	host.Timeout.ClientFin = ann.TimeoutClientFin
 	c.buildHostAuthTLS(data)
+	c.buildHostErrorPages(data)
 	c.buildHostSSLPassthrough(data)
+	c.buildHostTLSConfig(data)
+	c.buildHostMaintenance(data)
+}
+
+func (c *updater) UpdatePathConfig(path *hatypes.HostPath, ann *ingtypes.HostAnnotations) {
+	data := &pathData{
+		path: path,
+		ann:  ann,
+	}
+	c.buildPathRedirect(data)
 }
 
 func (c *updater) UpdateBackendConfig(backend *hatypes.Backend, ann *ingtypes.BackendAnnotations) {
@@ -111,4 +201,5 @@ func (c *updater) UpdateBackendConfig(backend *hatypes.Backend, ann *ingtypes.Ba
 	c.buildBackendAffinity(data)
 	c.buildBackendAuthHTTP(data)
 	c.buildBackendBlueGreen(data)
+	c.buildBackendTunnel(data)
 }
@@ -22,7 +22,6 @@ import (
 	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
 )
@@ -44,7 +43,7 @@ func setup(t *testing.T) *testConfig {
 	logger := &types_helper.LoggerMock{T: t}
 	return &testConfig{
 		t:       t,
-		haproxy: haproxy.CreateInstance(logger, &ha_helper.BindUtilsMock{}, haproxy.InstanceOptions{}).Config(),
+		haproxy: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
 		cache:   &ing_helper.CacheMock{},
 		logger:  logger,
 	}
@@ -62,6 +61,30 @@ func (c *testConfig) createUpdater() *updater {
 	}
 }
 
+func (c *testConfig) createHostData(namespace, name string, ann *types.HostAnnotations) *hostData {
+	ann.Source = types.Source{
+		Namespace: namespace,
+		Name:      name,
+		Type:      "ingress",
+	}
+	return &hostData{
+		host: &hatypes.Host{},
+		ann:  ann,
+	}
+}
+
+func (c *testConfig) createPathData(namespace, name, path string, ann *types.HostAnnotations) *pathData {
+	ann.Source = types.Source{
+		Namespace: namespace,
+		Name:      name,
+		Type:      "ingress",
+	}
+	return &pathData{
+		path: &hatypes.HostPath{Path: path},
+		ann:  ann,
+	}
+}
+
 func (c *testConfig) createBackendData(namespace, name string, ann *types.BackendAnnotations) *backData {
 	ann.Source = types.Source{
 		Namespace: namespace,
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"crypto/x509"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// certSource is the ingress which should be notified about
+// a problem in a certificate, and how the certificate is used
+type certSource struct {
+	ing  *extensions.Ingress
+	desc string
+}
+
+// syncCerts sends the certificates being served to the cert collector: the
+// default one, the certificate and the client CA of the hosts, and the client
+// certificate of the backends. The collector parses a certificate again only
+// if its hash changed, the validity is always evaluated against now. An event
+// is emitted on the ingress if a certificate is expired or doesn't cover the
+// hostname. Hosts using the default certificate are not checked.
+func (c *converter) syncCerts() {
+	collector := c.options.CertCollector
+	if collector == nil {
+		return
+	}
+	now := c.now()
+	failed := map[string]bool{}
+	var certs []*ingtypes.CertInfo
+	sources := map[*ingtypes.CertInfo]*certSource{}
+	addCert := func(filename, hash, usage, hostname string, checkHost bool, source *certSource) {
+		cert, err := collector.ReadCertificate(filename, hash)
+		if err != nil {
+			if !failed[filename] {
+				failed[filename] = true
+				c.logger.InfoV(2, "skipping validity of certificate '%s': %v", filename, err)
+			}
+			return
+		}
+		info := &ingtypes.CertInfo{
+			Filename:  filename,
+			Usage:     usage,
+			Hostname:  hostname,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Expired:   now.After(cert.NotAfter),
+		}
+		if checkHost {
+			info.HostnameMismatch = !ssl.CertMatchesHost(cert, hostname)
+		}
+		certs = append(certs, info)
+		if source != nil && source.ing != nil {
+			sources[info] = source
+		}
+	}
+	defaultCert := c.options.DefaultSSLFile.Filename
+	if defaultCert != "" {
+		addCert(defaultCert, c.options.DefaultSSLFile.SHA1Hash, "default", "", false, nil)
+	}
+	for _, host := range c.haproxy.Hosts() {
+		ing := c.hostIngress[host]
+		if file := host.TLS.TLSFilename; file != "" && file != defaultCert && !host.SSLPassthrough {
+			addCert(file, host.TLS.TLSHash, "host", host.Hostname, host.Hostname != "*", &certSource{
+				ing:  ing,
+				desc: "host '" + host.Hostname + "'",
+			})
+		}
+		if file := host.TLS.CAFilename; file != "" {
+			addCert(file, host.TLS.CAHash, "ca", host.Hostname, false, &certSource{
+				ing:  ing,
+				desc: "client CA of host '" + host.Hostname + "'",
+			})
+		}
+	}
+	added := map[string]bool{}
+	for _, backend := range c.haproxy.Backends() {
+		if file := backend.SSL.CertFilename; file != "" && !added[file] {
+			added[file] = true
+			addCert(file, backend.SSL.CertHash, "backend", "", false, &certSource{
+				ing:  c.backendIngress[backend],
+				desc: "client certificate of backend '" + backend.ID + "'",
+			})
+		}
+	}
+	recorder := c.options.EventRecorder
+	for _, cert := range collector.Notify(certs) {
+		source, found := sources[cert]
+		if !found || recorder == nil {
+			continue
+		}
+		if cert.Expired {
+			recorder.Eventf(source.ing, api.EventTypeWarning, "CertificateExpired",
+				"certificate '%s' of %s expired on %s", cert.Filename, source.desc, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if cert.HostnameMismatch {
+			recorder.Eventf(source.ing, api.EventTypeWarning, "CertificateHostMismatch",
+				"certificate '%s' of %s doesn't cover the hostname", cert.Filename, source.desc)
+		}
+	}
+}
+
+// readCertificate parses a certificate file, the certificates already
+// parsed by the cert collector are reused if it is configured.
+func (c *converter) readCertificate(filename, hash string) (*x509.Certificate, error) {
+	if collector := c.options.CertCollector; collector != nil {
+		return collector.ReadCertificate(filename, hash)
+	}
+	return ssl.ReadCertificate(filename)
+}
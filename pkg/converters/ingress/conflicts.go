@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"sync"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// NewConflictNotifier creates a notifier which remembers the conflicts
+// of every ingress, a conflict is notified again only if its ingress,
+// reason or winner changes. It should be shared between conversions.
+func NewConflictNotifier() ingtypes.ConflictNotifier {
+	return &conflictNotifier{
+		notified: map[string]map[conflictKey]bool{},
+	}
+}
+
+type conflictNotifier struct {
+	mutex    sync.Mutex
+	notified map[string]map[conflictKey]bool
+}
+
+type conflictKey struct {
+	reason string
+	winner string
+}
+
+func (n *conflictNotifier) Notify(current, synced []string, conflicts []*ingtypes.Conflict) []*ingtypes.Conflict {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	found := map[string]map[conflictKey]bool{}
+	for _, ing := range synced {
+		found[ing] = map[conflictKey]bool{}
+	}
+	var notify []*ingtypes.Conflict
+	for _, conflict := range conflicts {
+		key := conflictKey{reason: conflict.Reason, winner: conflict.Winner}
+		keys := found[conflict.Ingress]
+		if keys == nil {
+			keys = map[conflictKey]bool{}
+			found[conflict.Ingress] = keys
+		}
+		if !keys[key] && !n.notified[conflict.Ingress][key] {
+			notify = append(notify, conflict)
+		}
+		keys[key] = true
+	}
+	// ingress not synced keep the conflicts notified before
+	notified := make(map[string]map[conflictKey]bool, len(current))
+	for _, ing := range current {
+		if keys, found := found[ing]; found {
+			notified[ing] = keys
+		} else if keys, found := n.notified[ing]; found {
+			notified[ing] = keys
+		}
+	}
+	n.notified = notified
+	return notify
+}
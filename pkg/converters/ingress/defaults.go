@@ -28,12 +28,14 @@ const (
 func createDefaults() *types.Config {
 	return &types.Config{
 		ConfigDefaults: types.ConfigDefaults{
-			BalanceAlgorithm: "roundrobin",
+			AuthTLSCertHeaders: "cn,dn,sha1",
+			BalanceAlgorithm:   "roundrobin",
 			CookieKey:        "Ingress",
 			HSTS:             true,
 			HSTSIncludeSubdomains: false,
 			HSTSMaxAge:            "15768000",
 			HSTSPreload:           false,
+			MaintenanceRetryAfter: 300,
 			ProxyBodySize:         "",
 			SSLRedirect:           true,
 			TimeoutClient:         "50s",
@@ -47,6 +49,10 @@ func createDefaults() *types.Config {
 			TimeoutTunnel:         "1h",
 		},
 		ConfigGlobals: types.ConfigGlobals{
+			AcmeEmails:                   "",
+			AcmeEndpoint:                 "",
+			AcmeExpiring:                 30,
+			AcmeTermsAgreed:              false,
 			BackendCheckInterval:         "2s",
 			BackendServerSlotsIncrement:  32,
 			BindIPAddrHealthz:            "*",
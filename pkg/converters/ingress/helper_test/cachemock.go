@@ -29,15 +29,20 @@ import (
 // SecretContent ...
 type SecretContent map[string]map[string][]byte
 
+// ConfigMapContent ...
+type ConfigMapContent map[string]map[string]string
+
 // CacheMock ...
 type CacheMock struct {
-	SvcList       []*api.Service
-	EpList        map[string]*api.Endpoints
-	PodList       map[string]*api.Pod
-	SecretTLSPath map[string]string
-	SecretCAPath  map[string]string
-	SecretDHPath  map[string]string
-	SecretContent SecretContent
+	SvcList          []*api.Service
+	EpList           map[string]*api.Endpoints
+	PodList          map[string]*api.Pod
+	SecretTLSPath    map[string]string
+	SecretCAPath     map[string]string
+	SecretCRLPath    map[string]string
+	SecretDHPath     map[string]string
+	SecretContent    SecretContent
+	ConfigMapContent ConfigMapContent
 }
 
 // GetService ...
@@ -81,15 +86,43 @@ func (c *CacheMock) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
 	return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
 }
 
+// GetTLSSecretBundlePath ...
+func (c *CacheMock) GetTLSSecretBundlePath(secretNames []string) (ingtypes.File, error) {
+	paths := make([]string, len(secretNames))
+	for i, secretName := range secretNames {
+		path, found := c.SecretTLSPath[secretName]
+		if !found {
+			return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
+		}
+		paths[i] = path
+	}
+	path := strings.Join(paths, "+")
+	return ingtypes.File{
+		Filename: path,
+		SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
+	}, nil
+}
+
 // GetCASecretPath ...
-func (c *CacheMock) GetCASecretPath(secretName string) (ingtypes.File, error) {
-	if path, found := c.SecretCAPath[secretName]; found {
-		return ingtypes.File{
-			Filename: path,
-			SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
-		}, nil
+func (c *CacheMock) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	path, found := c.SecretCAPath[secretName]
+	if !found {
+		return ca, crl, fmt.Errorf("secret not found: '%s'", secretName)
 	}
-	return ingtypes.File{}, fmt.Errorf("secret not found: '%s'", secretName)
+	ca = ingtypes.File{
+		Filename: path,
+		SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(path))),
+	}
+	if crlPath, found := c.SecretCRLPath[secretName]; found {
+		if crlPath == "" {
+			return ca, crl, fmt.Errorf("invalid crl of secret '%s'", secretName)
+		}
+		crl = ingtypes.File{
+			Filename: crlPath,
+			SHA1Hash: fmt.Sprintf("%x", sha1.Sum([]byte(crlPath))),
+		}
+	}
+	return ca, crl, nil
 }
 
 // GetDHSecretPath ...
@@ -113,3 +146,11 @@ func (c *CacheMock) GetSecretContent(secretName, keyName string) ([]byte, error)
 	}
 	return nil, fmt.Errorf("secret not found: '%s'", secretName)
 }
+
+// GetConfigMapContent ...
+func (c *CacheMock) GetConfigMapContent(configMapName string) (map[string]string, error) {
+	if content, found := c.ConfigMapContent[configMapName]; found {
+		return content, nil
+	}
+	return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+}
@@ -34,6 +34,12 @@ func (u *UpdaterMock) UpdateHostConfig(host *hatypes.Host, ann *ingtypes.HostAnn
 	host.RootRedirect = ann.AppRoot
 }
 
+// UpdatePathConfig ...
+func (u *UpdaterMock) UpdatePathConfig(path *hatypes.HostPath, ann *ingtypes.HostAnnotations) {
+	path.Redirect.URL = ann.PermanentRedirect
+	path.Redirect.KeepURI = ann.RedirectKeepURI
+}
+
 // UpdateBackendConfig ...
 func (u *UpdaterMock) UpdateBackendConfig(backend *hatypes.Backend, ann *ingtypes.BackendAnnotations) {
 	backend.MaxConnServer = ann.MaxconnServer
@@ -18,11 +18,14 @@ package ingress
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
 
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/annotations"
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/utils"
@@ -38,24 +41,49 @@ type Config interface {
 
 // NewIngressConverter ...
 func NewIngressConverter(options *ingtypes.ConverterOptions, haproxy haproxy.Config, globalConfig map[string]string) Config {
+	return NewIncrementalConverter(options, haproxy, globalConfig, nil)
+}
+
+// NewIncrementalConverter creates a converter which rebuilds only the hosts
+// and backends affected by the objects changed since the last conversion,
+// the remaining ones are reused from the last configuration. tracker should
+// be shared between conversions, a nil tracker always rebuilds everything.
+func NewIncrementalConverter(options *ingtypes.ConverterOptions, haproxy haproxy.Config, globalConfig map[string]string, tracker *Tracker) Config {
 	c := &converter{
 		haproxy:            haproxy,
 		options:            options,
 		logger:             options.Logger,
 		cache:              options.Cache,
-		updater:            annotations.NewUpdater(haproxy, options.Cache, options.Logger),
 		globalConfig:       mergeConfig(createDefaults(), globalConfig),
+		rawGlobalConfig:    globalConfig,
 		hostAnnotations:    map[*hatypes.Host]*ingtypes.HostAnnotations{},
+		pathAnnotations:    map[*hatypes.HostPath]*ingtypes.HostAnnotations{},
 		backendAnnotations: map[*hatypes.Backend]*ingtypes.BackendAnnotations{},
+		hostIngress:        map[*hatypes.Host]*extensions.Ingress{},
+		hostSources:        map[*hatypes.Host][]*extensions.Ingress{},
+		pathIngress:        map[*hatypes.HostPath]*extensions.Ingress{},
+		tlsIngress:         map[*hatypes.Host]*extensions.Ingress{},
+		backendIngress:     map[*hatypes.Backend]*extensions.Ingress{},
+		acmeDomains:        map[string]map[string][]string{},
+		invalidAnnotations: map[ingtypes.Source]map[string]bool{},
+		conflictIngress:    map[*ingtypes.Conflict]*extensions.Ingress{},
+		now:                time.Now,
 	}
-	haproxy.ConfigDefaultX509Cert(options.DefaultSSLFile.Filename)
-	if options.DefaultBackend != "" {
-		if backend, err := c.addBackend(options.DefaultBackend, 0, &ingtypes.BackendAnnotations{}); err == nil {
-			haproxy.ConfigDefaultBackend(backend)
-		} else {
-			c.logger.Error("error reading default service: %v", err)
-		}
+	if tracker != nil {
+		// the updater should also read the cache via the tracking
+		// one, so the lookups made by the annotations are recorded
+		c.tracker = tracker
+		c.trackingCache = newTrackingCache(options.Cache)
+		c.cache = c.trackingCache
+		c.reusedBackends = map[*hatypes.Backend]bool{}
+		c.userlists = map[*hatypes.Backend][]*hatypes.Userlist{}
+		trackingOptions := *options
+		trackingOptions.Cache = c.trackingCache
+		c.updater = annotations.NewUpdater(haproxy, &trackingOptions)
+	} else {
+		c.updater = annotations.NewUpdater(haproxy, options)
 	}
+	haproxy.ConfigDefaultX509Cert(options.DefaultSSLFile.Filename)
 	return c
 }
 
@@ -66,29 +94,172 @@ type converter struct {
 	cache              ingtypes.Cache
 	updater            annotations.Updater
 	globalConfig       *ingtypes.Config
+	rawGlobalConfig    map[string]string
 	hostAnnotations    map[*hatypes.Host]*ingtypes.HostAnnotations
+	pathAnnotations    map[*hatypes.HostPath]*ingtypes.HostAnnotations
 	backendAnnotations map[*hatypes.Backend]*ingtypes.BackendAnnotations
+	hostIngress        map[*hatypes.Host]*extensions.Ingress
+	hostSources        map[*hatypes.Host][]*extensions.Ingress
+	pathIngress        map[*hatypes.HostPath]*extensions.Ingress
+	tlsIngress         map[*hatypes.Host]*extensions.Ingress
+	backendIngress     map[*hatypes.Backend]*extensions.Ingress
+	wwwRedirects       []*wwwRedirect
+	acmeDomains        map[string]map[string][]string
+	invalidAnnotations map[ingtypes.Source]map[string]bool
+	conflicts          []*ingtypes.Conflict
+	conflictIngress    map[*ingtypes.Conflict]*extensions.Ingress
+	tracker            *Tracker
+	trackingCache      *trackingCache
+	reusedBackends     map[*hatypes.Backend]bool
+	userlists          map[*hatypes.Backend][]*hatypes.Userlist
+	now                func() time.Time
+}
+
+type wwwRedirect struct {
+	host      *hatypes.Host
+	ann       *ingtypes.HostAnnotations
+	namespace string
+	tls       []extensions.IngressTLS
 }
 
 func (c *converter) Sync(ingress []*extensions.Ingress) {
-	for _, ing := range ingress {
+	ingress = sortIngress(ingress)
+	sync := ingress
+	var affected map[string]bool
+	if c.tracker != nil {
+		var partial bool
+		affected, partial = c.tracker.affectedKeys(c.options, c.rawGlobalConfig, ingress)
+		if partial {
+			sync = c.tracker.reuse(c, ingress, affected)
+		} else {
+			affected = nil
+		}
+	}
+	c.syncDefaultBackend()
+	for _, ing := range sync {
 		c.syncIngress(ing)
 	}
+	c.syncFromToWWW()
 	c.syncAnnotations()
+	c.syncCerts()
+	c.emitConflicts(ingress, sync)
+	invalid := c.invalidAnnotations
+	if c.tracker != nil {
+		c.tracker.commit(c, ingress, affected, c.trackingCache)
+		invalid = c.tracker.invalidAnnotations
+	}
+	c.updateAnnotationErrors(invalid)
+}
+
+// updateAnnotationErrors reports, for every annotation, the number of
+// objects whose value of the annotation couldn't be parsed
+func (c *converter) updateAnnotationErrors(invalid map[ingtypes.Source]map[string]bool) {
+	if c.options.Metrics == nil {
+		return
+	}
+	errors := map[string]int{}
+	for _, names := range invalid {
+		for name := range names {
+			errors[name]++
+		}
+	}
+	c.options.Metrics.SetAnnotationErrors(errors)
+}
+
+// sortIngress sorts a copy of the ingress list by precedence: the older
+// ingress, based on its creation timestamp, and then the namespace and name
+// of the ingress. The first ingress declaring a path, a TLS secret or an
+// annotation of a host wins a conflict with the ingress declaring it later.
+func sortIngress(ingress []*extensions.Ingress) []*extensions.Ingress {
+	sorted := make([]*extensions.Ingress, len(ingress))
+	copy(sorted, ingress)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ts1 := sorted[i].CreationTimestamp
+		ts2 := sorted[j].CreationTimestamp
+		if !ts1.Equal(&ts2) {
+			return ts1.Before(&ts2)
+		}
+		return ingressName(sorted[i]) < ingressName(sorted[j])
+	})
+	return sorted
+}
+
+// notifyConflict adds a conflict lost by ing to the ingress which has
+// precedence, see sortIngress() and emitConflicts()
+func (c *converter) notifyConflict(ing, winner *extensions.Ingress, reason, msgFmt string, args ...interface{}) {
+	if c.options.EventRecorder == nil || ing == nil || winner == nil {
+		return
+	}
+	conflict := &ingtypes.Conflict{
+		Ingress: ingressName(ing),
+		Winner:  ingressName(winner),
+		Reason:  reason,
+		Message: fmt.Sprintf(msgFmt, args...),
+	}
+	c.conflicts = append(c.conflicts, conflict)
+	c.conflictIngress[conflict] = ing
+}
+
+// emitConflicts emits an event on the ingress which lost a conflict,
+// naming the ingress which has precedence. The conflict notifier, if
+// assigned, filters the conflicts already notified.
+func (c *converter) emitConflicts(ingress, synced []*extensions.Ingress) {
+	recorder := c.options.EventRecorder
+	if recorder == nil {
+		return
+	}
+	conflicts := c.conflicts
+	if notifier := c.options.ConflictNotifier; notifier != nil {
+		conflicts = notifier.Notify(ingressNames(ingress), ingressNames(synced), conflicts)
+	}
+	for _, conflict := range conflicts {
+		recorder.Eventf(c.conflictIngress[conflict], api.EventTypeWarning, conflict.Reason,
+			"%s, ingress '%s' has precedence", conflict.Message, conflict.Winner)
+	}
+}
+
+func ingressNames(ingress []*extensions.Ingress) []string {
+	names := make([]string, len(ingress))
+	for i, ing := range ingress {
+		names[i] = ingressName(ing)
+	}
+	return names
+}
+
+// track defines the hosts and services which depend
+// on the next objects read from the cache
+func (c *converter) track(keys ...string) {
+	if c.trackingCache != nil {
+		c.trackingCache.setOwners(keys...)
+	}
+}
+
+func (c *converter) syncDefaultBackend() {
+	if c.options.DefaultBackend == "" {
+		return
+	}
+	c.track(serviceKey(c.options.DefaultBackend))
+	if backend, err := c.addBackend(c.options.DefaultBackend, 0, &ingtypes.BackendAnnotations{}); err == nil {
+		c.haproxy.ConfigDefaultBackend(backend)
+	} else {
+		c.logger.Error("error reading default service: %v", err)
+	}
 }
 
 func (c *converter) syncIngress(ing *extensions.Ingress) {
 	fullIngName := fmt.Sprintf("%s/%s", ing.Namespace, ing.Name)
-	ingFrontAnn, ingBackAnn := c.readAnnotations(&ingtypes.Source{
+	c.track(ingressKeys(ing)...)
+	source := &ingtypes.Source{
 		Namespace: ing.Namespace,
 		Name:      ing.Name,
 		Type:      "ingress",
-	}, ing.Annotations)
+	}
+	ingFrontAnn, ingBackAnn := c.readAnnotations(source, ing.Annotations)
 	if ing.Spec.Backend != nil {
 		svcName, svcPort := readServiceNamePort(ing.Spec.Backend)
-		err := c.addDefaultHostBackend(utils.FullQualifiedName(ing.Namespace, svcName), svcPort, ingFrontAnn, ingBackAnn)
+		err := c.addDefaultHostBackend(ing, utils.FullQualifiedName(ing.Namespace, svcName), svcPort, ingFrontAnn, ingBackAnn)
 		if err != nil {
-			c.logger.Warn("skipping default backend of ingress '%s': %v", fullIngName, err)
+			c.loggerFor(source, types.LogFields{Hostname: "*"}).Warn("skipping default backend of ingress '%s': %v", fullIngName, err)
 		}
 	}
 	for _, rule := range ing.Spec.Rules {
@@ -99,64 +270,219 @@ func (c *converter) syncIngress(ing *extensions.Ingress) {
 		if hostname == "" {
 			hostname = "*"
 		}
-		host := c.addHost(hostname, ingFrontAnn)
+		host := c.addHost(hostname, ing, ingFrontAnn)
+		logger := c.loggerFor(source, types.LogFields{Hostname: hostname})
 		for _, path := range rule.HTTP.Paths {
 			uri := path.Path
 			if uri == "" {
 				uri = "/"
 			}
-			if host.FindPath(uri) != nil {
-				c.logger.Warn("skipping redeclared path '%s' of ingress '%s'", uri, fullIngName)
+			if hostPath := host.FindPath(uri); hostPath != nil {
+				logger.Warn("skipping redeclared path '%s' of ingress '%s'", uri, fullIngName)
+				c.notifyConflict(ing, c.pathIngress[hostPath], "PathConflict",
+					"path '%s' of host '%s' was already declared", uri, hostname)
 				continue
 			}
 			svcName, svcPort := readServiceNamePort(&path.Backend)
 			fullSvcName := utils.FullQualifiedName(ing.Namespace, svcName)
 			backend, err := c.addBackend(fullSvcName, svcPort, ingBackAnn)
 			if err != nil {
-				c.logger.Warn("skipping backend config of ingress '%s': %v", fullIngName, err)
+				logger.Warn("skipping backend config of ingress '%s': %v", fullIngName, err)
 				continue
 			}
+			if _, found := c.backendIngress[backend]; !found {
+				c.backendIngress[backend] = ing
+			}
+			if backend.Ingress == "" {
+				backend.Ingress = ing.Name
+			}
 			host.AddPath(backend, uri)
+			hostPath := host.FindPath(uri)
+			c.pathAnnotations[hostPath] = ingFrontAnn
+			c.pathIngress[hostPath] = ing
 			c.addHTTPPassthrough(fullSvcName, ingFrontAnn, ingBackAnn)
 		}
-		for _, tls := range ing.Spec.TLS {
-			for _, tlshost := range tls.Hosts {
-				if tlshost == hostname {
-					tlsPath := c.addTLS(ing.Namespace, tls.SecretName)
-					if host.TLS.TLSHash == "" {
-						host.TLS.TLSFilename = tlsPath.Filename
-						host.TLS.TLSHash = tlsPath.SHA1Hash
-					} else if host.TLS.TLSHash != tlsPath.SHA1Hash {
-						msg := fmt.Sprintf("TLS of host '%s' was already assigned", host.Hostname)
-						if tls.SecretName != "" {
-							c.logger.Warn("skipping TLS secret '%s' of ingress '%s': %s", tls.SecretName, fullIngName, msg)
-						} else {
-							c.logger.Warn("skipping default TLS secret of ingress '%s': %s", fullIngName, msg)
-						}
-					}
+		if tlsSecrets, found := readTLSSecrets(ing.Spec.TLS, hostname); found {
+			tlsPath := c.addTLS(ing.Namespace, tlsSecrets)
+			if host.TLS.TLSHash == "" {
+				host.TLS.TLSFilename = tlsPath.Filename
+				host.TLS.TLSHash = tlsPath.SHA1Hash
+				c.tlsIngress[host] = ing
+			} else if host.TLS.TLSHash != tlsPath.SHA1Hash {
+				msg := fmt.Sprintf("TLS of host '%s' was already assigned", host.Hostname)
+				if len(tlsSecrets) > 0 {
+					logger.Warn("skipping TLS secret '%s' of ingress '%s': %s", strings.Join(tlsSecrets, ","), fullIngName, msg)
+				} else {
+					logger.Warn("skipping default TLS secret of ingress '%s': %s", fullIngName, msg)
 				}
+				c.notifyConflict(ing, c.tlsIngress[host], "TLSConflict", "%s", msg)
+			}
+		}
+		if ingFrontAnn.FromToWWWRedirect {
+			c.wwwRedirects = append(c.wwwRedirects, &wwwRedirect{
+				host:      host,
+				ann:       ingFrontAnn,
+				namespace: ing.Namespace,
+				tls:       ing.Spec.TLS,
+			})
+		}
+	}
+	if ingFrontAnn.CertSigner != "" {
+		c.addAcmeDomains(ing, ingFrontAnn)
+	}
+}
+
+// addAcmeDomains registers the TLS secrets and hostnames of an ingress
+// that should be signed by the ACME server. http-01 challenge, the only
+// one currently supported, cannot validate wildcard hostnames.
+func (c *converter) addAcmeDomains(ing *extensions.Ingress, ann *ingtypes.HostAnnotations) {
+	logger := c.loggerFor(&ann.Source, types.LogFields{})
+	if ann.CertSigner != "acme" {
+		logger.Warn("ignoring cert-signer '%s' on %v, only 'acme' is supported", ann.CertSigner, ann.Source)
+		return
+	}
+	for _, tls := range ing.Spec.TLS {
+		if tls.SecretName == "" {
+			logger.Warn("skipping cert signer of %v: TLS without secret name", ann.Source)
+			continue
+		}
+		var domains []string
+		for _, host := range tls.Hosts {
+			if strings.HasPrefix(host, "*.") {
+				logger.Warn("skipping cert signer of wildcard host '%s' on %v", host, ann.Source)
+				continue
+			}
+			domains = append(domains, host)
+		}
+		if len(domains) > 0 {
+			secretName := ing.Namespace + "/" + tls.SecretName
+			c.haproxy.Global().Acme.AddDomains(secretName, domains)
+			ingName := ingressName(ing)
+			if c.acmeDomains[ingName] == nil {
+				c.acmeDomains[ingName] = map[string][]string{}
+			}
+			c.acmeDomains[ingName][secretName] = append(c.acmeDomains[ingName][secretName], domains...)
+		}
+	}
+}
+
+// syncFromToWWW adds the www or non-www peer of the hosts configured
+// with from-to-www-redirect. The peer hostname is added to the frontend maps
+// and has its own TLS config, so it should be synced before the annotations.
+func (c *converter) syncFromToWWW() {
+	synced := map[*hatypes.Host]bool{}
+	for _, r := range c.wwwRedirects {
+		host := r.host
+		if synced[host] {
+			continue
+		}
+		synced[host] = true
+		logger := c.loggerFor(&r.ann.Source, types.LogFields{Hostname: host.Hostname})
+		if host.Hostname == "*" {
+			logger.Warn("skipping from-to-www redirect of default host on %v", r.ann.Source)
+			continue
+		}
+		if host.IsWildcard() {
+			logger.Warn("skipping from-to-www redirect of wildcard host '%s' on %v", host.Hostname, r.ann.Source)
+			continue
+		}
+		if r.ann.SSLPassthrough {
+			logger.Warn("skipping from-to-www redirect of host '%s' on %v: ssl-passthrough does not support redirect", host.Hostname, r.ann.Source)
+			continue
+		}
+		if len(host.Paths) == 0 {
+			continue
+		}
+		var peerName string
+		if strings.HasPrefix(host.Hostname, "www.") {
+			peerName = strings.TrimPrefix(host.Hostname, "www.")
+		} else {
+			peerName = "www." + host.Hostname
+		}
+		if c.haproxy.FindHost(peerName) != nil {
+			logger.Warn("skipping from-to-www redirect of host '%s' on %v: host '%s' was already declared", host.Hostname, r.ann.Source, peerName)
+			continue
+		}
+		c.track(hostKey(host.Hostname), hostKey(peerName))
+		scheme := "http"
+		var tlsFilename, tlsHash string
+		if host.TLS.TLSHash != "" {
+			scheme = "https"
+			tlsFilename = host.TLS.TLSFilename
+			tlsHash = host.TLS.TLSHash
+			if tlsSecrets, found := readTLSSecrets(r.tls, peerName); found {
+				tlsPath := c.addTLS(r.namespace, tlsSecrets)
+				tlsFilename = tlsPath.Filename
+				tlsHash = tlsPath.SHA1Hash
+			}
+			// certificates that cannot be read are reported by syncCerts
+			if cert, err := c.readCertificate(tlsFilename, tlsHash); err == nil && !ssl.CertMatchesHost(cert, peerName) {
+				logger.Warn("skipping from-to-www redirect of host '%s' on %v: certificate '%s' doesn't cover the hostname '%s'", host.Hostname, r.ann.Source, tlsFilename, peerName)
+				continue
 			}
 		}
+		peer := c.haproxy.AcquireHost(peerName)
+		c.hostIngress[peer] = c.hostIngress[host]
+		// paths are sorted in descending order, the shortest one is the last;
+		// the backend is only used on maps, the whole host is redirected
+		peer.AddPath(host.Paths[len(host.Paths)-1].Backend, "/")
+		peer.TLS.TLSFilename = tlsFilename
+		peer.TLS.TLSHash = tlsHash
+		peerAnn := *r.ann
+		peerAnn.FromToWWWRedirect = false
+		peerAnn.TemporalRedirect = ""
+		peerAnn.PermanentRedirect = scheme + "://" + host.Hostname
+		peerAnn.RedirectKeepURI = true
+		c.hostAnnotations[peer] = &peerAnn
+		c.pathAnnotations[peer.FindPath("/")] = &peerAnn
 	}
 }
 
 func (c *converter) syncAnnotations() {
+	c.track(globalKey)
 	c.updater.UpdateGlobalConfig(c.haproxy.Global(), c.globalConfig)
 	for _, host := range c.haproxy.Hosts() {
+		c.track(hostKey(host.Hostname))
 		if ann, found := c.hostAnnotations[host]; found {
 			c.updater.UpdateHostConfig(host, ann)
 		}
+		for _, path := range host.Paths {
+			if ann, found := c.pathAnnotations[path]; found {
+				c.updater.UpdatePathConfig(path, ann)
+			}
+		}
 	}
 	for _, backend := range c.haproxy.Backends() {
 		if ann, found := c.backendAnnotations[backend]; found {
-			c.updater.UpdateBackendConfig(backend, ann)
+			c.track(serviceKey(backend.Namespace + "/" + backend.Name))
+			c.updateBackendConfig(backend, ann)
+		}
+	}
+}
+
+// updateBackendConfig updates a backend, and records the userlists
+// added by the backend, so they can be reused with the backend
+func (c *converter) updateBackendConfig(backend *hatypes.Backend, ann *ingtypes.BackendAnnotations) {
+	if c.userlists == nil {
+		c.updater.UpdateBackendConfig(backend, ann)
+		return
+	}
+	userlists := map[*hatypes.Userlist]bool{}
+	for _, userlist := range c.haproxy.Userlists() {
+		userlists[userlist] = true
+	}
+	c.updater.UpdateBackendConfig(backend, ann)
+	for _, userlist := range c.haproxy.Userlists() {
+		if !userlists[userlist] {
+			c.userlists[backend] = append(c.userlists[backend], userlist)
 		}
 	}
 }
 
-func (c *converter) addDefaultHostBackend(fullSvcName string, svcPort int, ingFrontAnn *ingtypes.HostAnnotations, ingBackAnn *ingtypes.BackendAnnotations) error {
+func (c *converter) addDefaultHostBackend(ing *extensions.Ingress, fullSvcName string, svcPort int, ingFrontAnn *ingtypes.HostAnnotations, ingBackAnn *ingtypes.BackendAnnotations) error {
 	if fr := c.haproxy.FindHost("*"); fr != nil {
-		if fr.FindPath("/") != nil {
+		if hostPath := fr.FindPath("/"); hostPath != nil {
+			c.notifyConflict(ing, c.pathIngress[hostPath], "PathConflict", "path / of the default host was already declared")
 			return fmt.Errorf("path / was already defined on default host")
 		}
 	}
@@ -164,24 +490,64 @@ func (c *converter) addDefaultHostBackend(fullSvcName string, svcPort int, ingFr
 	if err != nil {
 		return err
 	}
-	host := c.addHost("*", ingFrontAnn)
+	if backend.Ingress == "" {
+		backend.Ingress = ing.Name
+	}
+	host := c.addHost("*", ing, ingFrontAnn)
 	host.AddPath(backend, "/")
+	c.pathIngress[host.FindPath("/")] = ing
 	return nil
 }
 
-func (c *converter) addHost(hostname string, ingAnn *ingtypes.HostAnnotations) *hatypes.Host {
+func (c *converter) addHost(hostname string, ing *extensions.Ingress, ingAnn *ingtypes.HostAnnotations) *hatypes.Host {
 	host := c.haproxy.AcquireHost(hostname)
 	if ann, found := c.hostAnnotations[host]; found {
 		skipped, _ := utils.UpdateStruct(c.globalConfig.ConfigDefaults, ingAnn, ann)
 		if len(skipped) > 0 {
-			c.logger.Info("skipping host annotation(s) from %v due to conflict: %v", ingAnn.Source, skipped)
+			c.loggerFor(&ingAnn.Source, types.LogFields{Hostname: hostname}).Info("skipping host annotation(s) from %v due to conflict: %v", ingAnn.Source, skipped)
+			c.notifyAnnotationConflict(host, ing, skipped)
 		}
 	} else {
-		c.hostAnnotations[host] = ingAnn
+		// host annotations are merged from all the ingress of the same
+		// hostname, ingAnn is also used by the paths and should stay untouched
+		hostAnn := *ingAnn
+		c.hostAnnotations[host] = &hostAnn
+	}
+	if _, found := c.hostIngress[host]; !found {
+		c.hostIngress[host] = ing
 	}
+	c.hostSources[host] = append(c.hostSources[host], ing)
 	return host
 }
 
+// notifyAnnotationConflict notifies an ingress about its skipped host
+// annotations. The winner of an annotation is the first ingress of the
+// host declaring it with another value.
+func (c *converter) notifyAnnotationConflict(host *hatypes.Host, ing *extensions.Ingress, skipped []string) {
+	if c.options.EventRecorder == nil {
+		return
+	}
+	prefix := c.options.AnnotationPrefix + "/"
+	var winners []*extensions.Ingress
+	winnerAnns := map[*extensions.Ingress][]string{}
+	for _, name := range skipped {
+		value := ing.Annotations[prefix+name]
+		for _, source := range c.hostSources[host] {
+			if sourceValue, found := source.Annotations[prefix+name]; found && sourceValue != value {
+				if winnerAnns[source] == nil {
+					winners = append(winners, source)
+				}
+				winnerAnns[source] = append(winnerAnns[source], name)
+				break
+			}
+		}
+	}
+	for _, winner := range winners {
+		c.notifyConflict(ing, winner, "AnnotationConflict",
+			"annotation(s) %s of host '%s' were already declared", strings.Join(winnerAnns[winner], ","), host.Hostname)
+	}
+}
+
 func (c *converter) addBackend(fullSvcName string, svcPort int, ingAnn *ingtypes.BackendAnnotations) (*hatypes.Backend, error) {
 	svc, err := c.cache.GetService(fullSvcName)
 	if err != nil {
@@ -197,24 +563,30 @@ func (c *converter) addBackend(fullSvcName string, svcPort int, ingAnn *ingtypes
 		svcPort = svc.Spec.Ports[0].TargetPort.IntValue()
 	}
 	backend := c.haproxy.AcquireBackend(namespace, svcName, svcPort)
+	if c.reusedBackends[backend] {
+		// only the default backend, the ingress using a reused
+		// backend aren't synced, see Tracker.affectedKeys()
+		return backend, nil
+	}
 	ann, found := c.backendAnnotations[backend]
 	if !found {
 		// New backend, configure endpoints and svc annotations
-		if err := c.addEndpoints(svc, svcPort, backend); err != nil {
-			c.logger.Error("error adding endpoints of service '%s': %v", fullSvcName, err)
-		}
-		// Initialize with service annotations, giving precedence
-		_, ann = c.readAnnotations(&ingtypes.Source{
+		source := &ingtypes.Source{
 			Namespace: namespace,
 			Name:      svcName,
 			Type:      "service",
-		}, svc.Annotations)
+		}
+		if err := c.addEndpoints(svc, svcPort, backend); err != nil {
+			c.loggerFor(source, types.LogFields{Backend: backend.ID}).Error("error adding endpoints of service '%s': %v", fullSvcName, err)
+		}
+		// Initialize with service annotations, giving precedence
+		_, ann = c.readAnnotations(source, svc.Annotations)
 		c.backendAnnotations[backend] = ann
 	}
 	// Merging Ingress annotations
 	skipped, _ := utils.UpdateStruct(c.globalConfig.ConfigDefaults, ingAnn, ann)
 	if len(skipped) > 0 {
-		c.logger.Info("skipping backend '%s/%s:%d' annotation(s) from %v due to conflict: %v",
+		c.loggerFor(&ingAnn.Source, types.LogFields{Backend: backend.ID}).Info("skipping backend '%s/%s:%d' annotation(s) from %v due to conflict: %v",
 			backend.Namespace, backend.Name, backend.Port, ingAnn.Source, skipped)
 	}
 	return backend, nil
@@ -228,14 +600,46 @@ func (c *converter) addHTTPPassthrough(fullSvcName string, ingFrontAnn *ingtypes
 	}
 }
 
-func (c *converter) addTLS(namespace, secretName string) ingtypes.File {
-	if secretName != "" {
-		tlsSecretName := namespace + "/" + secretName
+// readTLSSecrets returns the distinct secret names of the TLS entries
+// declaring hostname. found is true if hostname has at least one TLS
+// entry, secretNames is empty if they use the default certificate.
+func readTLSSecrets(ingTLS []extensions.IngressTLS, hostname string) (secretNames []string, found bool) {
+	added := map[string]bool{}
+	for _, tls := range ingTLS {
+		for _, tlshost := range tls.Hosts {
+			if tlshost == hostname {
+				found = true
+				if tls.SecretName != "" && !added[tls.SecretName] {
+					secretNames = append(secretNames, tls.SecretName)
+					added[tls.SecretName] = true
+				}
+			}
+		}
+	}
+	return secretNames, found
+}
+
+// addTLS returns the certificate of a list of secrets. More than one
+// secret creates a multi-cert bundle, where HAProxy chooses the key
+// type, eg RSA or ECDSA, supported by the client.
+func (c *converter) addTLS(namespace string, secretNames []string) ingtypes.File {
+	if len(secretNames) == 1 {
+		tlsSecretName := namespace + "/" + secretNames[0]
 		tlsFile, err := c.cache.GetTLSSecretPath(tlsSecretName)
 		if err == nil {
 			return tlsFile
 		}
 		c.logger.Warn("using default certificate due to an error reading secret '%s': %v", tlsSecretName, err)
+	} else if len(secretNames) > 1 {
+		tlsSecretNames := make([]string, len(secretNames))
+		for i, secretName := range secretNames {
+			tlsSecretNames[i] = namespace + "/" + secretName
+		}
+		tlsFile, err := c.cache.GetTLSSecretBundlePath(tlsSecretNames)
+		if err == nil {
+			return tlsFile
+		}
+		c.logger.Warn("using default certificate due to an error reading secrets '%s': %v", strings.Join(tlsSecretNames, ","), err)
 	}
 	return c.options.DefaultSSLFile
 }
@@ -252,7 +656,12 @@ func (c *converter) addEndpoints(svc *api.Service, servicePort int, backend *hat
 		for _, port := range subset.Ports {
 			if int(port.Port) == servicePort && port.Protocol == api.ProtocolTCP {
 				for _, addr := range subset.Addresses {
-					backend.NewEndpoint(addr.IP, servicePort, addr.TargetRef.Namespace+"/"+addr.TargetRef.Name)
+					var targetRef string
+					if addr.TargetRef != nil {
+						// nil on endpoints of services without selector
+						targetRef = addr.TargetRef.Namespace + "/" + addr.TargetRef.Name
+					}
+					backend.NewEndpoint(addr.IP, servicePort, targetRef)
 				}
 			}
 		}
@@ -273,15 +682,42 @@ func (c *converter) readAnnotations(source *ingtypes.Source, annotations map[str
 	backAnn := &ingtypes.BackendAnnotations{Source: *source}
 	utils.UpdateStruct(struct{}{}, c.globalConfig.ConfigDefaults, frontAnn)
 	utils.UpdateStruct(struct{}{}, c.globalConfig.ConfigDefaults, backAnn)
+	logger := c.loggerFor(source, types.LogFields{})
+	invalid := map[string]bool{}
 	if err := utils.MergeMap(ann, frontAnn); err != nil {
-		c.logger.Error("error merging host annotations from %v: %v", source, err)
+		logger.Error("error merging host annotations from %v: %v", source, err)
+		addInvalidAnnotations(invalid, err)
 	}
 	if err := utils.MergeMap(ann, backAnn); err != nil {
-		c.logger.Error("error merging backend annotations from %v: %v", source, err)
+		logger.Error("error merging backend annotations from %v: %v", source, err)
+		addInvalidAnnotations(invalid, err)
+	}
+	if len(invalid) > 0 {
+		if names, found := c.invalidAnnotations[*source]; found {
+			addKeys(names, keysOf(invalid))
+		} else {
+			c.invalidAnnotations[*source] = invalid
+		}
 	}
 	return frontAnn, backAnn
 }
 
+// addInvalidAnnotations adds the annotations which couldn't be decoded to
+// invalid, an annotation read by both the host and the backend is added once
+func addInvalidAnnotations(invalid map[string]bool, err error) {
+	if mergeErr, ok := err.(*utils.MergeError); ok {
+		for _, name := range mergeErr.Keys {
+			invalid[name] = true
+		}
+	}
+}
+
+// loggerFor returns the logger of the messages about source, fields
+// has the host or the backend the message is about, if any
+func (c *converter) loggerFor(source *ingtypes.Source, fields types.LogFields) types.Logger {
+	return types.WithFields(c.logger, source.LogFields().Merge(fields))
+}
+
 func readServiceNamePort(backend *extensions.IngressBackend) (string, int) {
 	serviceName := backend.ServiceName
 	servicePort := backend.ServicePort.IntValue()
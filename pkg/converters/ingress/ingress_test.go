@@ -17,21 +17,29 @@ limitations under the License.
 package ingress
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kylelemons/godebug/diff"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	yaml "gopkg.in/yaml.v2"
 	api "k8s.io/api/core/v1"
 	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
 
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
 	ing_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/helper_test"
 	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
 )
@@ -342,18 +350,61 @@ func TestSyncRedeclareTLS(t *testing.T) {
 	c.createSvc1Auto()
 	c.createSecretTLS1("default/tls-echo1")
 	c.createSecretTLS1("default/tls-echo2")
-	c.Sync(c.createIngTLS1("default/echo1", "echo.example.com", "/", "echo:8080", "tls-echo1:echo.example.com;tls-echo2:echo.example.com"))
+	c.Sync(
+		c.createIngTLS1("default/echo1", "echo.example.com", "/", "echo:8080", "tls-echo1:echo.example.com"),
+		c.createIngTLS1("default/echo2", "echo.example.com", "/app", "echo:8080", "tls-echo2:echo.example.com"),
+	)
 
 	c.compareConfigFront(`
 - hostname: echo.example.com
   paths:
+  - path: /app
+    backend: default_echo_8080
   - path: /
     backend: default_echo_8080
   tls:
     tlsfilename: /tls/default/tls-echo1.pem`)
 
 	c.compareLogging(`
-WARN skipping TLS secret 'tls-echo2' of ingress 'default/echo1': TLS of host 'echo.example.com' was already assigned`)
+WARN skipping TLS secret 'tls-echo2' of ingress 'default/echo2': TLS of host 'echo.example.com' was already assigned`)
+}
+
+func TestSyncTLSBundle(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo-rsa")
+	c.createSecretTLS1("default/tls-echo-ecdsa")
+	c.Sync(c.createIngTLS1("default/echo1", "echo.example.com", "/", "echo:8080", "tls-echo-rsa:echo.example.com;tls-echo-ecdsa:echo.example.com;tls-echo-rsa:echo.example.com"))
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-echo-rsa.pem+/tls/default/tls-echo-ecdsa.pem`)
+}
+
+func TestSyncTLSBundleSecretNotFound(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo-rsa")
+	c.Sync(c.createIngTLS1("default/echo1", "echo.example.com", "/", "echo:8080", "tls-echo-rsa:echo.example.com;tls-echo-ecdsa:echo.example.com"))
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/tls-default.pem`)
+
+	c.compareLogging(`
+WARN using default certificate due to an error reading secrets 'default/tls-echo-rsa,default/tls-echo-ecdsa': secret not found: 'default/tls-echo-ecdsa'`)
 }
 
 func TestSyncRedeclareSameTLS(t *testing.T) {
@@ -751,6 +802,218 @@ func TestSyncAnnFrontDefault(t *testing.T) {
     client: 1s`)
 }
 
+func TestSyncAnnPathRedirect(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1("default/echo1", "echo.example.com", "/", "echo:8080"),
+		c.createIng1Ann("default/echo2", "echo.example.com", "/old", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/permanent-redirect": "https://app.example.com",
+			"ingress.kubernetes.io/redirect-keep-uri":  "true",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /old
+    backend: default_echo_8080
+    redirect: https://app.example.com
+    keepuri: true
+  - path: /
+    backend: default_echo_8080`)
+}
+
+func TestSyncAnnCertSigner(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo;tls-wildcard:*.example.com")
+	ing1.SetAnnotations(map[string]string{"ingress.kubernetes.io/cert-signer": "acme"})
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-echo:echo2.example.com,www.example.com")
+	ing2.SetAnnotations(map[string]string{"ingress.kubernetes.io/cert-signer": "acme"})
+	ing3 := c.createIngTLS1("default/echo3", "echo3.example.com", "/", "echo:8080", "tls-echo3")
+	ing3.SetAnnotations(map[string]string{"ingress.kubernetes.io/cert-signer": "self"})
+	c.Sync(ing1, ing2, ing3)
+
+	acme := c.hconfig.Global().Acme
+	expected := map[string][]string{
+		"default/tls-echo": {"echo1.example.com", "echo2.example.com", "www.example.com"},
+	}
+	if len(acme.Certs) != len(expected) {
+		t.Errorf("expected %d acme certs but was %d: %v", len(expected), len(acme.Certs), acme.Certs)
+	}
+	for secret, domains := range expected {
+		if actual := acme.Domains(secret); !reflect.DeepEqual(actual, domains) {
+			t.Errorf("expected domains %v of secret '%s' but was %v", domains, secret, actual)
+		}
+	}
+
+	c.logger.CompareLogging(`
+WARN using default certificate due to an error reading secret 'default/tls-echo': secret not found: 'default/tls-echo'
+WARN skipping cert signer of wildcard host '*.example.com' on ingress 'default/echo1'
+WARN using default certificate due to an error reading secret 'default/tls-echo': secret not found: 'default/tls-echo'
+WARN using default certificate due to an error reading secret 'default/tls-echo3': secret not found: 'default/tls-echo3'
+WARN ignoring cert-signer 'self' on ingress 'default/echo3', only 'acme' is supported`)
+}
+
+func TestSyncAnnFromToWWW(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo", "www.example.com", "/app", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/from-to-www-redirect": "true",
+			"ingress.kubernetes.io/timeout-client":       "1m",
+		}),
+	)
+
+	c.compareConfigFront(`
+- hostname: example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+    redirect: http://www.example.com
+    keepuri: true
+  timeout:
+    client: 1m
+- hostname: www.example.com
+  paths:
+  - path: /app
+    backend: default_echo_8080
+  timeout:
+    client: 1m`)
+}
+
+func TestSyncAnnFromToWWWTLS(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.createSecretTLS1("default/tls-echo")
+	c.createSecretTLS1("default/tls-www")
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo")
+	ing1.SetAnnotations(map[string]string{"ingress.kubernetes.io/from-to-www-redirect": "true"})
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-echo;tls-www:www.echo2.example.com")
+	ing2.SetAnnotations(map[string]string{"ingress.kubernetes.io/from-to-www-redirect": "true"})
+	c.Sync(ing1, ing2)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-echo.pem
+- hostname: echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: /tls/default/tls-echo.pem
+- hostname: www.echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+    redirect: https://echo1.example.com
+    keepuri: true
+  tls:
+    tlsfilename: /tls/default/tls-echo.pem
+- hostname: www.echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+    redirect: https://echo2.example.com
+    keepuri: true
+  tls:
+    tlsfilename: /tls/default/tls-www.pem`)
+}
+
+func TestSyncAnnFromToWWWTLSMismatch(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	ca := ssl_helper.CreateCA("ca")
+	writeCert := func(name string, hostnames ...string) string {
+		now := time.Now()
+		cert := ssl_helper.CreateCertValidity(hostnames, now.Add(-time.Hour), now.Add(time.Hour), ca)
+		filename := tempdir + "/" + name + ".pem"
+		if err := ioutil.WriteFile(filename, append(cert.PEM(), cert.KeyPEM()...), 0644); err != nil {
+			t.Fatalf("error writing certificate: %v", err)
+		}
+		return filename
+	}
+	c.cache.SecretTLSPath["default/tls-echo1"] = writeCert("echo1", "echo1.example.com")
+	c.cache.SecretTLSPath["default/tls-echo2"] = writeCert("echo2", "echo2.example.com", "www.echo2.example.com")
+
+	c.createSvc1Auto()
+	ing1 := c.createIngTLS1("default/echo1", "echo1.example.com", "/", "echo:8080", "tls-echo1")
+	ing1.SetAnnotations(map[string]string{"ingress.kubernetes.io/from-to-www-redirect": "true"})
+	ing2 := c.createIngTLS1("default/echo2", "echo2.example.com", "/", "echo:8080", "tls-echo2")
+	ing2.SetAnnotations(map[string]string{"ingress.kubernetes.io/from-to-www-redirect": "true"})
+	c.Sync(ing1, ing2)
+
+	c.compareConfigFront(`
+- hostname: echo1.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: ` + tempdir + `/echo1.pem
+- hostname: echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+  tls:
+    tlsfilename: ` + tempdir + `/echo2.pem
+- hostname: www.echo2.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+    redirect: https://echo2.example.com
+    keepuri: true
+  tls:
+    tlsfilename: ` + tempdir + `/echo2.pem`)
+
+	c.compareLogging(`
+WARN skipping from-to-www redirect of host 'echo1.example.com' on ingress 'default/echo1': certificate '` + tempdir + `/echo1.pem' doesn't cover the hostname 'www.echo1.example.com'`)
+}
+
+func TestSyncAnnFromToWWWConflict(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(
+		c.createIng1Ann("default/echo1", "echo.example.com", "/", "echo:8080", map[string]string{
+			"ingress.kubernetes.io/from-to-www-redirect": "true",
+		}),
+		c.createIng1("default/echo2", "www.echo.example.com", "/", "echo:8080"),
+	)
+
+	c.compareConfigFront(`
+- hostname: echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080
+- hostname: www.echo.example.com
+  paths:
+  - path: /
+    backend: default_echo_8080`)
+
+	c.compareLogging(`
+WARN skipping from-to-www redirect of host 'echo.example.com' on ingress 'default/echo1': host 'www.echo.example.com' was already declared`)
+}
+
 func TestSyncAnnBack(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -827,6 +1090,29 @@ func TestSyncAnnBacksSvcIng(t *testing.T) {
   maxconnserver: 10` + defaultBackendConfig)
 }
 
+func TestSyncAnnParseError(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.Sync(c.createIng1Ann("default/echo", "echo.example.com", "/", "echo:8080", map[string]string{
+		"ingress.kubernetes.io/maxconn-server":  "ten",
+		"ingress.kubernetes.io/ssl-passthrough": "maybe",
+	}))
+
+	expected := map[string]int{"maxconn-server": 1, "ssl-passthrough": 1}
+	if !reflect.DeepEqual(c.metrics.AnnotationErrors, expected) {
+		t.Errorf("expected annotation errors %v but was %v", expected, c.metrics.AnnotationErrors)
+	}
+	c.compareLogging(`
+ERROR error merging host annotations from ingress 'default/echo': error decoding config: 1 error(s) decoding:
+
+* cannot parse 'ssl-passthrough' as bool: strconv.ParseBool: parsing "maybe": invalid syntax
+ERROR error merging backend annotations from ingress 'default/echo': error decoding config: 1 error(s) decoding:
+
+* cannot parse 'maxconn-server' as int: strconv.ParseInt: parsing "ten": invalid syntax`)
+}
+
 func TestSyncAnnBackDefault(t *testing.T) {
 	c := setup(t)
 	defer c.teardown()
@@ -907,6 +1193,620 @@ func TestSyncAnnBackDefault(t *testing.T) {
 INFO skipping backend 'default/echo5:8080' annotation(s) from ingress 'default/echo5' due to conflict: [balance-algorithm]`)
 }
 
+/* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
+ *
+ *  CERTIFICATES
+ *
+ * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
+
+type recorderMock struct {
+	events []string
+}
+
+func (r *recorderMock) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	ing := object.(*extensions.Ingress)
+	r.events = append(r.events, fmt.Sprintf("%s/%s %s %s ", ing.Namespace, ing.Name, eventtype, reason)+fmt.Sprintf(messageFmt, args...))
+}
+
+func TestSyncCerts(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	ca := ssl_helper.CreateCA("ca")
+	now := time.Date(2019, 10, 1, 10, 0, 0, 0, time.UTC)
+	expiry := now.Add(-time.Hour)
+	writeCert := func(name string, notAfter time.Time) string {
+		cert := ssl_helper.CreateCertValidity([]string{name}, now.Add(-24*time.Hour), notAfter, ca)
+		filename := tempdir + "/" + name + ".pem"
+		if err := ioutil.WriteFile(filename, append(cert.PEM(), cert.KeyPEM()...), 0644); err != nil {
+			t.Fatalf("error writing certificate: %v", err)
+		}
+		return filename
+	}
+	defaultCert := writeCert("localhost", now.Add(time.Hour))
+	d1Cert := writeCert("d1.local", now.Add(time.Hour))
+	d2Cert := writeCert("d2.local", expiry)
+	c.cache.SecretTLSPath["default/tls-d1"] = d1Cert
+	c.cache.SecretTLSPath["default/tls-d2"] = d2Cert
+
+	c.createSvc1Auto()
+	ings := []*extensions.Ingress{
+		c.createIngTLS1("default/echo1", "d1.local", "/", "echo:8080", "tls-d1"),
+		c.createIngTLS1("default/echo2", "d2.local", "/", "echo:8080", "tls-d2"),
+		c.createIngTLS1("default/echo3", "d3.local", "/", "echo:8080", "tls-d1"),
+	}
+	collector := NewCertCollector()
+	recorder := &recorderMock{}
+	sync := func() {
+		conv := NewIngressConverter(
+			&ingtypes.ConverterOptions{
+				Cache:          c.cache,
+				Logger:         c.logger,
+				DefaultSSLFile: ingtypes.File{Filename: defaultCert, SHA1Hash: "1"},
+				CertCollector:  collector,
+				EventRecorder:  recorder,
+			},
+			c.hconfig,
+			map[string]string{},
+		).(*converter)
+		conv.updater = c.updater
+		conv.now = func() time.Time { return now }
+		conv.Sync(ings)
+		c.hconfig = haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{}).Config()
+	}
+
+	sync()
+	expected := []string{
+		"default/echo2 Warning CertificateExpired certificate '" + d2Cert + "' of host 'd2.local' expired on " + expiry.Format(time.RFC3339),
+		"default/echo3 Warning CertificateHostMismatch certificate '" + d1Cert + "' of host 'd3.local' doesn't cover the hostname",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("expected events:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(recorder.events, "\n"))
+	}
+
+	// already notified
+	recorder.events = nil
+	sync()
+	if len(recorder.events) > 0 {
+		t.Errorf("expected no events but was:\n%s", strings.Join(recorder.events, "\n"))
+	}
+
+	// parsed certificates are reused, validity is evaluated against now
+	if err := os.Remove(d1Cert); err != nil {
+		t.Fatalf("error removing certificate: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	sync()
+	expected = []string{
+		"default/echo1 Warning CertificateExpired certificate '" + d1Cert + "' of host 'd1.local' expired on " + now.Add(-time.Hour).Format(time.RFC3339),
+		"default/echo3 Warning CertificateExpired certificate '" + d1Cert + "' of host 'd3.local' expired on " + now.Add(-time.Hour).Format(time.RFC3339),
+		"default/echo3 Warning CertificateHostMismatch certificate '" + d1Cert + "' of host 'd3.local' doesn't cover the hostname",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("expected events:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(recorder.events, "\n"))
+	}
+	now = now.Add(-2 * time.Hour)
+	recorder.events = nil
+	sync()
+
+	ch := make(chan prometheus.Metric, 20)
+	collector.Collect(ch)
+	close(ch)
+	var metrics []string
+	for metric := range ch {
+		m := &dto.Metric{}
+		metric.Write(m)
+		var labels []string
+		for _, label := range m.Label {
+			labels = append(labels, label.GetName()+"="+label.GetValue())
+		}
+		if strings.Contains(metric.Desc().String(), "hostname_mismatch") && m.Gauge.GetValue() > 0 {
+			metrics = append(metrics, strings.Join(labels, ","))
+		}
+	}
+	if expected := []string{"certificate=" + d1Cert + ",hostname=d3.local,usage=host"}; !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected mismatch metrics %v but was %v", expected, metrics)
+	}
+}
+
+func TestSyncIncremental(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1("default/echo1", "8080", "172.17.0.11")
+	c.createSvc1("default/echo2", "8080", "172.17.0.21")
+	ing1 := c.createIng1("default/ing1", "d1.local", "/", "echo1:8080")
+	ing2 := c.createIng1("default/ing2", "d2.local", "/", "echo2:8080")
+	tracker := NewTracker()
+	config := map[string]string{}
+	sync := func(ing ...*extensions.Ingress) {
+		c.hconfig = haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{}).Config()
+		conv := NewIncrementalConverter(
+			&ingtypes.ConverterOptions{
+				Cache:          c.cache,
+				Logger:         c.logger,
+				DefaultBackend: "system/default",
+				DefaultSSLFile: ingtypes.File{
+					Filename: "/tls/tls-default.pem",
+					SHA1Hash: "1",
+				},
+				AnnotationPrefix: "ingress.kubernetes.io",
+			},
+			c.hconfig,
+			config,
+			tracker,
+		).(*converter)
+		conv.updater = c.updater
+		conv.globalConfig = mergeConfig(&ingtypes.Config{}, config)
+		conv.Sync(ing)
+	}
+
+	// first sync, full rebuild
+	sync(ing1, ing2)
+	c.compareConfigFront(`
+- hostname: d1.local
+  paths:
+  - path: /
+    backend: default_echo1_8080
+- hostname: d2.local
+  paths:
+  - path: /
+    backend: default_echo2_8080`)
+	c.compareLogging("")
+	d1 := c.hconfig.FindHost("d1.local")
+
+	// nothing changed
+	sync(ing1, ing2)
+	if host := c.hconfig.FindHost("d1.local"); host != d1 {
+		t.Errorf("expected host d1.local reused")
+	}
+	c.compareLogging(`
+INFO-V(2) syncing 0 of 2 ingress, reusing 2 host(s) and 3 backend(s) from the last configuration`)
+
+	// changed endpoints
+	ep := *c.cache.EpList["default/echo2"]
+	ep.Subsets = []api.EndpointSubset{{
+		Addresses: []api.EndpointAddress{{IP: "172.17.0.22", TargetRef: &api.ObjectReference{Namespace: "default", Name: "echo2-xxxxx"}}},
+		Ports:     []api.EndpointPort{{Port: 8080, Protocol: api.ProtocolTCP}},
+	}}
+	c.cache.EpList["default/echo2"] = &ep
+	sync(ing1, ing2)
+	if host := c.hconfig.FindHost("d1.local"); host != d1 {
+		t.Errorf("expected host d1.local reused")
+	}
+	c.compareConfigBack(`
+- id: default_echo1_8080
+  endpoints:
+  - ip: 172.17.0.11
+    port: 8080
+- id: default_echo2_8080
+  endpoints:
+  - ip: 172.17.0.22
+    port: 8080` + defaultBackendConfig)
+	c.compareLogging(`
+INFO-V(2) syncing 1 of 2 ingress, reusing 1 host(s) and 2 backend(s) from the last configuration`)
+
+	// new ingress sharing a host
+	ing3 := c.createIng1("default/ing3", "d1.local", "/app", "echo2:8080")
+	sync(ing1, ing2, ing3)
+	c.compareConfigFront(`
+- hostname: d1.local
+  paths:
+  - path: /app
+    backend: default_echo2_8080
+  - path: /
+    backend: default_echo1_8080
+- hostname: d2.local
+  paths:
+  - path: /
+    backend: default_echo2_8080`)
+	c.compareLogging(`
+INFO-V(2) syncing 3 of 3 ingress, reusing 0 host(s) and 1 backend(s) from the last configuration`)
+
+	// removed ingress
+	sync(ing1, ing3)
+	c.compareConfigFront(`
+- hostname: d1.local
+  paths:
+  - path: /app
+    backend: default_echo2_8080
+  - path: /
+    backend: default_echo1_8080`)
+	c.compareLogging(`
+INFO-V(2) syncing 2 of 2 ingress, reusing 0 host(s) and 1 backend(s) from the last configuration`)
+
+	// changed global config, full rebuild
+	config = map[string]string{"timeout-client": "1m"}
+	sync(ing1, ing3)
+	c.compareLogging("")
+}
+
+func TestSyncConflictPrecedence(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1("default/echo1", "8080", "172.17.0.11")
+	c.createSvc1("default/echo2", "8080", "172.17.0.21")
+	c.createSecretTLS1("default/tls1")
+	c.createSecretTLS1("default/tls2")
+	now := time.Date(2019, 10, 1, 10, 0, 0, 0, time.UTC)
+	ing1 := c.createIngTLS1("default/echo1", "d1.local", "/", "echo1:8080", "tls1")
+	ing1.SetAnnotations(map[string]string{"ingress.kubernetes.io/timeout-client": "1s"})
+	ing1.CreationTimestamp = metav1.NewTime(now)
+	ing2 := c.createIngTLS1("default/echo2", "d1.local", "/", "echo2:8080", "tls2")
+	ing2.SetAnnotations(map[string]string{"ingress.kubernetes.io/timeout-client": "2s"})
+	ing2.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+	ing3 := c.createIng1("default/echo0", "d2.local", "/", "echo1:8080")
+	ing3.CreationTimestamp = metav1.NewTime(now)
+	ing4 := c.createIng1("default/echo3", "d2.local", "/", "echo2:8080")
+	ing4.CreationTimestamp = metav1.NewTime(now)
+
+	recorder := &recorderMock{}
+	conv := NewIngressConverter(
+		&ingtypes.ConverterOptions{
+			Cache:            c.cache,
+			Logger:           c.logger,
+			DefaultSSLFile:   ingtypes.File{Filename: "/tls/tls-default.pem", SHA1Hash: "1"},
+			AnnotationPrefix: "ingress.kubernetes.io",
+			EventRecorder:    recorder,
+		},
+		c.hconfig,
+		map[string]string{},
+	).(*converter)
+	conv.updater = c.updater
+	conv.globalConfig = mergeConfig(&ingtypes.Config{}, map[string]string{})
+	conv.Sync([]*extensions.Ingress{ing1, ing4, ing3, ing2})
+
+	// echo2 is older than echo1, echo0 and echo3 have the same
+	// creation timestamp and echo0 comes first in the name order
+	c.compareConfigFront(`
+- hostname: d1.local
+  paths:
+  - path: /
+    backend: default_echo2_8080
+  timeout:
+    client: 2s
+  tls:
+    tlsfilename: /tls/default/tls2.pem
+- hostname: d2.local
+  paths:
+  - path: /
+    backend: default_echo1_8080`)
+
+	expected := []string{
+		"default/echo1 Warning AnnotationConflict annotation(s) timeout-client of host 'd1.local' were already declared, ingress 'default/echo2' has precedence",
+		"default/echo1 Warning PathConflict path '/' of host 'd1.local' was already declared, ingress 'default/echo2' has precedence",
+		"default/echo1 Warning TLSConflict TLS of host 'd1.local' was already assigned, ingress 'default/echo2' has precedence",
+		"default/echo3 Warning PathConflict path '/' of host 'd2.local' was already declared, ingress 'default/echo0' has precedence",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("expected events:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(recorder.events, "\n"))
+	}
+
+	c.compareLogging(`
+INFO skipping host annotation(s) from ingress 'default/echo1' due to conflict: [timeout-client]
+WARN skipping redeclared path '/' of ingress 'default/echo1'
+WARN skipping TLS secret 'tls1' of ingress 'default/echo1': TLS of host 'd1.local' was already assigned
+WARN skipping redeclared path '/' of ingress 'default/echo3'`)
+}
+
+func TestConflictNotifier(t *testing.T) {
+	conflict := func(ing, winner, reason string) *ingtypes.Conflict {
+		return &ingtypes.Conflict{Ingress: ing, Winner: winner, Reason: reason, Message: reason + " of " + ing}
+	}
+	testCases := []struct {
+		current   []string
+		synced    []string
+		conflicts []*ingtypes.Conflict
+		expected  []string
+	}{
+		// 0
+		{
+			current:   []string{"ing1", "ing2", "ing3"},
+			synced:    []string{"ing1", "ing2", "ing3"},
+			conflicts: []*ingtypes.Conflict{conflict("ing2", "ing1", "PathConflict"), conflict("ing2", "ing1", "PathConflict"), conflict("ing3", "ing1", "TLSConflict")},
+			expected:  []string{"PathConflict of ing2", "TLSConflict of ing3"},
+		},
+		// 1 already notified
+		{
+			current:   []string{"ing1", "ing2", "ing3"},
+			synced:    []string{"ing1", "ing2", "ing3"},
+			conflicts: []*ingtypes.Conflict{conflict("ing2", "ing1", "PathConflict"), conflict("ing3", "ing1", "TLSConflict")},
+		},
+		// 2 ingress not synced keep their conflicts
+		{
+			current: []string{"ing1", "ing2", "ing3"},
+			synced:  []string{"ing1"},
+		},
+		// 3
+		{
+			current:   []string{"ing1", "ing2", "ing3"},
+			synced:    []string{"ing2", "ing3"},
+			conflicts: []*ingtypes.Conflict{conflict("ing2", "ing1", "PathConflict"), conflict("ing3", "ing2", "TLSConflict")},
+			expected:  []string{"TLSConflict of ing3"},
+		},
+		// 4 removed ingress
+		{
+			current: []string{"ing1", "ing3"},
+			synced:  []string{"ing1"},
+		},
+		// 5
+		{
+			current:   []string{"ing1", "ing2", "ing3"},
+			synced:    []string{"ing1", "ing2"},
+			conflicts: []*ingtypes.Conflict{conflict("ing2", "ing1", "PathConflict")},
+			expected:  []string{"PathConflict of ing2"},
+		},
+	}
+	notifier := NewConflictNotifier()
+	for i, test := range testCases {
+		var notified []string
+		for _, conflict := range notifier.Notify(test.current, test.synced, test.conflicts) {
+			notified = append(notified, conflict.Message)
+		}
+		if !reflect.DeepEqual(notified, test.expected) {
+			t.Errorf("%d: expected notified %v but was %v", i, test.expected, notified)
+		}
+	}
+}
+
+func TestValidateChanges(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1Auto()
+	c.createSvc1("default/echo4", "8080", "172.17.0.41")
+	c.createSecretTLS1("default/tls1")
+	options := &ingtypes.ConverterOptions{
+		Cache:            c.cache,
+		Logger:           c.logger,
+		DefaultSSLFile:   ingtypes.File{Filename: "/tls/tls-default.pem", SHA1Hash: "1"},
+		AnnotationPrefix: "ingress.kubernetes.io",
+	}
+	ing1 := c.createIngTLS1("default/echo1", "d1.local", "/", "echo:8080", "tls1")
+	ing2 := c.createIng1Ann("default/echo2", "d2.local", "/", "echo:8080", map[string]string{
+		"ingress.kubernetes.io/affinity": "invalid",
+	})
+	ing3 := c.createIngTLS1("default/echo3", "d1.local", "/", "echo:8080", "tls3")
+	ing4 := c.createIng1Ann("default/echo4", "d4.local", "/", "echo4:8080", map[string]string{
+		"ingress.kubernetes.io/affinity":                "cookie",
+		"ingress.kubernetes.io/session-cookie-strategy": "invalid",
+	})
+	cur := []*extensions.Ingress{ing1, ing2}
+
+	// problems of the current state are ignored
+	if problems := ValidateChanges(options, cur, cur, nil, nil); len(problems) > 0 {
+		t.Errorf("expected no problems but was: %v", problems)
+	}
+
+	problems := ValidateChanges(options, cur, append(cur, ing3, ing4), nil, nil)
+	expected := []string{
+		"skipping redeclared path '/' of ingress 'default/echo3'",
+		"using default certificate due to an error reading secret 'default/tls3': secret not found: 'default/tls3'",
+		"skipping TLS secret 'tls3' of ingress 'default/echo3': TLS of host 'd1.local' was already assigned",
+		"invalid affinity cookie strategy 'invalid' on service 'default/echo4', using 'insert' instead",
+		"path '/' of host 'd1.local' was already declared, ingress 'default/echo1' has precedence",
+		"TLS of host 'd1.local' was already assigned, ingress 'default/echo1' has precedence",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(problems, "\n"))
+	}
+
+	problems = ValidateChanges(options, cur, cur, nil, map[string]string{"nbthread": "0"})
+	expected = []string{"invalid value of nbthread configmap option (0), using 1"}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems %v but was %v", expected, problems)
+	}
+}
+
+func TestSyncIncrementalAnnotationErrors(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	c.createSvc1("default/echo1", "8080", "172.17.0.11")
+	c.createSvc1("default/echo2", "8080", "172.17.0.21")
+	ing1 := c.createIng1Ann("default/ing1", "d1.local", "/", "echo1:8080", map[string]string{
+		"ingress.kubernetes.io/maxconn-server": "ten",
+	})
+	ing2 := c.createIng1("default/ing2", "d2.local", "/", "echo2:8080")
+	tracker := NewTracker()
+	sync := func(ing ...*extensions.Ingress) {
+		c.hconfig = haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{}).Config()
+		conv := NewIncrementalConverter(
+			&ingtypes.ConverterOptions{
+				Cache:            c.cache,
+				Logger:           c.logger,
+				DefaultSSLFile:   ingtypes.File{Filename: "/tls/tls-default.pem", SHA1Hash: "1"},
+				AnnotationPrefix: "ingress.kubernetes.io",
+				Metrics:          c.metrics,
+			},
+			c.hconfig,
+			map[string]string{},
+			tracker,
+		).(*converter)
+		conv.updater = c.updater
+		conv.Sync(ing)
+	}
+	checkErrors := func(expected map[string]int) {
+		if !reflect.DeepEqual(c.metrics.AnnotationErrors, expected) {
+			t.Errorf("expected annotation errors %v but was %v", expected, c.metrics.AnnotationErrors)
+		}
+	}
+
+	sync(ing1, ing2)
+	checkErrors(map[string]int{"maxconn-server": 1})
+	c.compareLogging(`
+ERROR error merging backend annotations from ingress 'default/ing1': error decoding config: 1 error(s) decoding:
+
+* cannot parse 'maxconn-server' as int: strconv.ParseInt: parsing "ten": invalid syntax`)
+
+	// reused ingress keep their errors, and aren't counted again
+	sync(ing1, ing2)
+	checkErrors(map[string]int{"maxconn-server": 1})
+	c.compareLogging(`
+INFO-V(2) syncing 0 of 2 ingress, reusing 2 host(s) and 2 backend(s) from the last configuration`)
+
+	// fixed annotation
+	ing1 = c.createIng1Ann("default/ing1", "d1.local", "/", "echo1:8080", map[string]string{
+		"ingress.kubernetes.io/maxconn-server": "10",
+	})
+	sync(ing1, ing2)
+	checkErrors(map[string]int{})
+	c.compareLogging(`
+INFO-V(2) syncing 1 of 2 ingress, reusing 1 host(s) and 1 backend(s) from the last configuration`)
+}
+
+func TestAffectedIngress(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	ing1 := c.createIng1("default/echo1", "d1.local", "/", "echo1:8080")
+	ing2 := c.createIng1("default/echo2", "d2.local", "/", "echo2:8080")
+	ing3 := c.createIng1("default/echo3", "d3.local", "/", "echo1:8080")
+	ing4 := c.createIng1("default/echo4", "www.d2.local", "/app", "echo4:8080")
+	ing5 := c.createIng1("default/echo5", "d5.local", "/", "echo5:8080")
+	ingress := []*extensions.Ingress{ing1, ing2, ing3, ing4, ing5}
+	names := func(ingress []*extensions.Ingress) string {
+		var list []string
+		for _, ing := range ingress {
+			list = append(list, ingressName(ing))
+		}
+		return strings.Join(list, ",")
+	}
+
+	testCases := []struct {
+		changed  []*extensions.Ingress
+		expected string
+	}{
+		// 0 same service
+		{
+			changed:  []*extensions.Ingress{ing1},
+			expected: "default/echo1,default/echo3",
+		},
+		// 1 www peer
+		{
+			changed:  []*extensions.Ingress{c.createIng1("default/new", "d2.local", "/api", "new:8080")},
+			expected: "default/echo2,default/echo4",
+		},
+		// 2 changed object and its current version
+		{
+			changed:  []*extensions.Ingress{c.createIng1("default/echo5", "d6.local", "/", "echo6:8080"), ing5},
+			expected: "default/echo5",
+		},
+		// 3 not found, nil
+		{
+			changed:  []*extensions.Ingress{c.createIng1("default/new", "d7.local", "/", "echo7:8080"), nil},
+			expected: "",
+		},
+	}
+	for i, test := range testCases {
+		if affected := names(AffectedIngress(ingress, test.changed...)); affected != test.expected {
+			t.Errorf("%d: expected affected '%s' but was '%s'", i, test.expected, affected)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	ing1 := c.createIng1("default/echo1", "d1.local", "/", "echo:8080")
+	ing2 := c.createIng1("default/echo2", "d2.local", "/", "echo:8080")
+	ing3 := c.createIng1("default/echo3", "d3.local", "/", "echo:8080")
+	ing4 := c.createIng1("default/echo4", "d4.local", "/", "echo:8080")
+	invalid := map[string]bool{"default/echo2": true, "default/echo4": true}
+	var checks int
+	check := func(ingress []*extensions.Ingress) error {
+		checks++
+		for _, ing := range ingress {
+			if name := ingressName(ing); invalid[name] {
+				return fmt.Errorf("[NOTICE] checking\n[ALERT] parsing [%s]: invalid\n[ALERT] fatal errors found", name)
+			}
+		}
+		return nil
+	}
+	recorder := &recorderMock{}
+	q := NewQuarantine(c.logger, recorder)
+	ingress := []*extensions.Ingress{ing4, ing3, ing2, ing1}
+
+	if !q.Isolate(ingress, check) {
+		t.Errorf("expected ingress quarantined")
+	}
+	c.compareLogging(`
+WARN ingress 'default/echo2' quarantined, HAProxy failed to validate the configuration: [ALERT] parsing [default/echo2]: invalid; [ALERT] fatal errors found
+WARN ingress 'default/echo4' quarantined, HAProxy failed to validate the configuration: [ALERT] parsing [default/echo4]: invalid; [ALERT] fatal errors found`)
+	expected := []string{
+		"default/echo2 Warning Quarantined ingress excluded from the configuration, HAProxy failed to validate it: [ALERT] parsing [default/echo2]: invalid; [ALERT] fatal errors found",
+		"default/echo4 Warning Quarantined ingress excluded from the configuration, HAProxy failed to validate it: [ALERT] parsing [default/echo4]: invalid; [ALERT] fatal errors found",
+	}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Errorf("expected events:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(recorder.events, "\n"))
+	}
+	if filtered := q.Filter(ingress); !reflect.DeepEqual(filtered, []*extensions.Ingress{ing3, ing1}) {
+		t.Errorf("expected echo3 and echo1 but was %v", filtered)
+	}
+
+	// the problem isn't in the ingress resources
+	checks = 0
+	failAll := func([]*extensions.Ingress) error {
+		checks++
+		return fmt.Errorf("[ALERT] global config")
+	}
+	if q.Isolate([]*extensions.Ingress{ing1}, failAll) {
+		t.Errorf("expected no ingress quarantined")
+	}
+	if checks != 2 {
+		t.Errorf("expected 2 checks but was %d", checks)
+	}
+	c.compareLogging(`
+WARN configuration without ingress is also invalid, ingress were not quarantined`)
+
+	// the configuration built from scratch is valid, e.g. a transient failure
+	checks = 0
+	passAll := func([]*extensions.Ingress) error {
+		checks++
+		return nil
+	}
+	if q.Isolate([]*extensions.Ingress{ing3, ing1}, passAll) {
+		t.Errorf("expected no ingress quarantined")
+	}
+	if checks != 1 {
+		t.Errorf("expected 1 check but was %d", checks)
+	}
+	c.compareLogging(`
+WARN configuration built from scratch is valid, ingress were not quarantined`)
+
+	// changed echo2 and removed echo4 leave the quarantine
+	ing2changed := c.createIng1("default/echo2", "d2.local", "/app", "echo:8080")
+	if filtered := q.Filter([]*extensions.Ingress{ing1, ing2changed, ing3}); len(filtered) != 3 {
+		t.Errorf("expected 3 ingress but was %d", len(filtered))
+	}
+	c.compareLogging(`
+INFO ingress 'default/echo2' changed, leaving quarantine`)
+
+	ch := make(chan prometheus.Metric, 10)
+	q.Collect(ch)
+	close(ch)
+	var metrics []string
+	for metric := range ch {
+		m := &dto.Metric{}
+		metric.Write(m)
+		if m.Counter != nil {
+			metrics = append(metrics, fmt.Sprintf("failures=%v", m.Counter.GetValue()))
+		} else {
+			metrics = append(metrics, "quarantined")
+		}
+	}
+	if expected := []string{"failures=3"}; !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected metrics %v but was %v", expected, metrics)
+	}
+}
+
 /* * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * *
  *
  *  BUILDERS
@@ -918,10 +1818,59 @@ type testConfig struct {
 	decode  func(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error)
 	hconfig haproxy.Config
 	logger  *types_helper.LoggerMock
+	metrics *types_helper.MetricsMock
 	cache   *ing_helper.CacheMock
 	updater *ing_helper.UpdaterMock
 }
 
+func TestQuarantineScratchCheck(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	instance := haproxy.CreateInstance(c.logger, haproxy.InstanceOptions{
+		HAProxyConfigFile: tempdir + "/haproxy.cfg",
+		TemplatesDir:      "../../../rootfs/etc/haproxy",
+		MapsDir:           tempdir + "/maps",
+		ErrorPagesDir:     tempdir + "/errorpages",
+	})
+	if err := instance.ParseTemplates(); err != nil {
+		t.Fatalf("error parsing templates: %v", err)
+	}
+	check := NewScratchCheck(&ingtypes.ConverterOptions{
+		Cache:          c.cache,
+		Logger:         c.logger,
+		DefaultBackend: "system/default",
+		DefaultSSLFile: ingtypes.File{
+			Filename: "/tls/tls-default.pem",
+			SHA1Hash: "1",
+		},
+		AnnotationPrefix: "ingress.kubernetes.io",
+	}, instance, map[string]string{})
+
+	// a configuration without hosts cannot be rendered, but it's valid
+	if err := check(nil); err != nil {
+		t.Errorf("expected configuration without ingress valid but was: %v", err)
+	}
+	c.createSvc1("default/echo", "8080", "172.17.0.11")
+	ing1 := c.createIng1("default/echo1", "d1.local", "/", "echo:8080")
+	if err := check([]*extensions.Ingress{ing1}); err != nil {
+		t.Errorf("expected configuration of echo1 valid but was: %v", err)
+	}
+	q := NewQuarantine(c.logger, nil)
+	if q.Isolate([]*extensions.Ingress{ing1}, check) {
+		t.Errorf("expected no ingress quarantined")
+	}
+	c.compareLogging(`
+INFO (test) check was skipped
+INFO (test) check was skipped
+WARN configuration built from scratch is valid, ingress were not quarantined`)
+}
+
 func setup(t *testing.T) *testConfig {
 	logger := &types_helper.LoggerMock{
 		Logging: []string{},
@@ -930,7 +1879,7 @@ func setup(t *testing.T) *testConfig {
 	c := &testConfig{
 		t:       t,
 		decode:  scheme.Codecs.UniversalDeserializer().Decode,
-		hconfig: haproxy.CreateInstance(logger, &ha_helper.BindUtilsMock{}, haproxy.InstanceOptions{}).Config(),
+		hconfig: haproxy.CreateInstance(logger, haproxy.InstanceOptions{}).Config(),
 		cache: &ing_helper.CacheMock{
 			SvcList: []*api.Service{},
 			EpList:  map[string]*api.Endpoints{},
@@ -938,7 +1887,8 @@ func setup(t *testing.T) *testConfig {
 				"system/ingress-default": "/tls/tls-default.pem",
 			},
 		},
-		logger: logger,
+		logger:  logger,
+		metrics: &types_helper.MetricsMock{},
 	}
 	c.createSvc1("system/default", "8080", "172.17.0.99")
 	return c
@@ -969,6 +1919,7 @@ func (c *testConfig) SyncDef(config map[string]string, ing ...*extensions.Ingres
 				SHA1Hash: "1",
 			},
 			AnnotationPrefix: "ingress.kubernetes.io",
+			Metrics:          c.metrics,
 		},
 		c.hconfig,
 		config,
@@ -1146,6 +2097,8 @@ type (
 	pathMock struct {
 		Path      string
 		BackendID string `yaml:"backend"`
+		Redirect  string `yaml:",omitempty"`
+		KeepURI   bool   `yaml:",omitempty"`
 	}
 	timeoutMock struct {
 		Client string `yaml:",omitempty"`
@@ -1167,7 +2120,12 @@ func convertHost(hafronts ...*hatypes.Host) []hostMock {
 	for _, f := range hafronts {
 		paths := []pathMock{}
 		for _, p := range f.Paths {
-			paths = append(paths, pathMock{Path: p.Path, BackendID: p.BackendID})
+			paths = append(paths, pathMock{
+				Path:      p.Path,
+				BackendID: p.BackendID,
+				Redirect:  p.Redirect.URL,
+				KeepURI:   p.Redirect.KeepURI,
+			})
 		}
 		hosts = append(hosts, hostMock{
 			Hostname:     f.Hostname,
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// CertCollector exports the validity of the certificates
+// served by the last synchronized configuration
+type CertCollector interface {
+	prometheus.Collector
+	ingtypes.CertCollector
+}
+
+// NewCertCollector creates a prometheus collector of the certificates
+// parsed by the converter, labeled by certificate file, usage and hostname.
+func NewCertCollector() CertCollector {
+	labels := []string{"certificate", "usage", "hostname"}
+	return &certCollector{
+		issues: map[string]bool{},
+		parsed: map[string]*parsedCert{},
+		read:   map[string]bool{},
+		notBefore: prometheus.NewDesc(
+			"ingress_controller_cert_not_before_seconds",
+			"Number of seconds since 1970 to the start of the validity of a certificate",
+			labels,
+			nil,
+		),
+		notAfter: prometheus.NewDesc(
+			"ingress_controller_cert_expire_time_seconds",
+			"Number of seconds since 1970 to the expiration of a certificate",
+			labels,
+			nil,
+		),
+		mismatch: prometheus.NewDesc(
+			"ingress_controller_cert_hostname_mismatch",
+			"Whether the certificate doesn't cover the hostname, 1 means mismatch",
+			labels,
+			nil,
+		),
+	}
+}
+
+type certCollector struct {
+	mutex     sync.Mutex
+	certs     []*ingtypes.CertInfo
+	issues    map[string]bool
+	parsed    map[string]*parsedCert
+	read      map[string]bool
+	notBefore *prometheus.Desc
+	notAfter  *prometheus.Desc
+	mismatch  *prometheus.Desc
+}
+
+// parsedCert is a certificate file parsed by ReadCertificate, hash is
+// the hash of the file when it was parsed
+type parsedCert struct {
+	hash string
+	cert *x509.Certificate
+	err  error
+}
+
+func (c *certCollector) ReadCertificate(filename, hash string) (*x509.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.read[filename] = true
+	if parsed, found := c.parsed[filename]; found && hash != "" && parsed.hash == hash {
+		return parsed.cert, parsed.err
+	}
+	cert, err := ssl.ReadCertificate(filename)
+	c.parsed[filename] = &parsedCert{hash: hash, cert: cert, err: err}
+	return cert, err
+}
+
+func (c *certCollector) Notify(certs []*ingtypes.CertInfo) []*ingtypes.CertInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for filename := range c.parsed {
+		if !c.read[filename] {
+			delete(c.parsed, filename)
+		}
+	}
+	c.read = map[string]bool{}
+	var notify []*ingtypes.CertInfo
+	issues := map[string]bool{}
+	for _, cert := range certs {
+		if !cert.Expired && !cert.HostnameMismatch {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%s|%v|%v", cert.Filename, cert.Usage, cert.Hostname, cert.Expired, cert.HostnameMismatch)
+		if !c.issues[key] {
+			notify = append(notify, cert)
+		}
+		issues[key] = true
+	}
+	c.certs = certs
+	c.issues = issues
+	return notify
+}
+
+func (c *certCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.notBefore
+	ch <- c.notAfter
+	ch <- c.mismatch
+}
+
+func (c *certCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	certs := c.certs
+	c.mutex.Unlock()
+	for _, cert := range certs {
+		mismatch := 0.0
+		if cert.HostnameMismatch {
+			mismatch = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.notBefore, prometheus.GaugeValue, float64(cert.NotBefore.Unix()), cert.Filename, cert.Usage, cert.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.notAfter, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), cert.Filename, cert.Usage, cert.Hostname)
+		ch <- prometheus.MustNewConstMetric(c.mismatch, prometheus.GaugeValue, mismatch, cert.Filename, cert.Usage, cert.Hostname)
+	}
+}
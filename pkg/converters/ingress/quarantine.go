@@ -0,0 +1,240 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// Quarantine has the ingress excluded from the configuration because
+// HAProxy doesn't validate the configuration built with them. An ingress
+// leaves the quarantine when it's changed or removed.
+type Quarantine interface {
+	prometheus.Collector
+	// Filter returns ingress without the quarantined ones, releasing
+	// the quarantined ingress which were changed or removed
+	Filter(ingress []*extensions.Ingress) []*extensions.Ingress
+	// Isolate quarantines the ingress which make the configuration
+	// invalid, check builds and checks the configuration of a list of
+	// ingress. Nothing is quarantined if the configuration of ingress
+	// passes the check, e.g. the failure was transient. Returns true
+	// if at least one ingress was quarantined.
+	Isolate(ingress []*extensions.Ingress, check func(ingress []*extensions.Ingress) error) bool
+}
+
+// NewQuarantine creates the quarantine of ingress, exporting the number of
+// invalid configurations and the quarantined ingress as prometheus metrics.
+func NewQuarantine(logger types.Logger, recorder ingtypes.EventRecorder) Quarantine {
+	return &quarantine{
+		logger:   logger,
+		recorder: recorder,
+		ingress:  map[string]*quarantined{},
+		invalidConfigs: prometheus.NewDesc(
+			"ingress_controller_config_check_failures_total",
+			"Number of configurations which HAProxy failed to validate",
+			nil,
+			nil,
+		),
+		quarantinedIngress: prometheus.NewDesc(
+			"ingress_controller_quarantined_ingress",
+			"Ingress resources excluded from the configuration because HAProxy failed to validate it",
+			[]string{"namespace", "ingress"},
+			nil,
+		),
+	}
+}
+
+type quarantine struct {
+	mutex              sync.Mutex
+	logger             types.Logger
+	recorder           ingtypes.EventRecorder
+	ingress            map[string]*quarantined
+	failures           int
+	invalidConfigs     *prometheus.Desc
+	quarantinedIngress *prometheus.Desc
+}
+
+type quarantined struct {
+	ing     *extensions.Ingress
+	version string
+}
+
+func (q *quarantine) Filter(ingress []*extensions.Ingress) []*extensions.Ingress {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.ingress) == 0 {
+		return ingress
+	}
+	filtered := make([]*extensions.Ingress, 0, len(ingress))
+	found := map[string]bool{}
+	for _, ing := range ingress {
+		name := ingressName(ing)
+		if item, isQuarantined := q.ingress[name]; isQuarantined {
+			if item.version == objectVersion(ing, nil) {
+				found[name] = true
+				continue
+			}
+			q.logger.Info("ingress '%s' changed, leaving quarantine", name)
+		}
+		filtered = append(filtered, ing)
+	}
+	for name := range q.ingress {
+		if !found[name] {
+			delete(q.ingress, name)
+		}
+	}
+	return filtered
+}
+
+func (q *quarantine) Isolate(ingress []*extensions.Ingress, check func(ingress []*extensions.Ingress) error) bool {
+	q.mutex.Lock()
+	q.failures++
+	q.mutex.Unlock()
+	ingress = sortIngress(ingress)
+	var isolated bool
+	for len(ingress) > 0 {
+		ing, err := bisectIngress(ingress, check)
+		if ing == nil {
+			if err != nil {
+				q.logger.Warn("configuration without ingress is also invalid, ingress were not quarantined")
+			} else if !isolated {
+				q.logger.Warn("configuration built from scratch is valid, ingress were not quarantined")
+			}
+			break
+		}
+		q.add(ing, err)
+		isolated = true
+		ingress = removeIngress(ingress, ing)
+	}
+	return isolated
+}
+
+func (q *quarantine) add(ing *extensions.Ingress, err error) {
+	name := ingressName(ing)
+	reason := summarizeCheckError(err)
+	q.mutex.Lock()
+	q.ingress[name] = &quarantined{
+		ing:     ing,
+		version: objectVersion(ing, nil),
+	}
+	q.mutex.Unlock()
+	q.logger.Warn("ingress '%s' quarantined, HAProxy failed to validate the configuration: %s", name, reason)
+	if q.recorder != nil {
+		q.recorder.Eventf(ing, api.EventTypeWarning, "Quarantined",
+			"ingress excluded from the configuration, HAProxy failed to validate it: %s", reason)
+	}
+}
+
+func (q *quarantine) Describe(ch chan<- *prometheus.Desc) {
+	ch <- q.invalidConfigs
+	ch <- q.quarantinedIngress
+}
+
+func (q *quarantine) Collect(ch chan<- prometheus.Metric) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(q.invalidConfigs, prometheus.CounterValue, float64(q.failures))
+	for _, item := range q.ingress {
+		ch <- prometheus.MustNewConstMetric(q.quarantinedIngress, prometheus.GaugeValue, 1, item.ing.Namespace, item.ing.Name)
+	}
+}
+
+// bisectIngress returns the first ingress, in the conversion order, whose
+// addition to the ingress before it makes check fail, and the error of
+// the check. Returns nil without error if ingress passes the check, and
+// nil with the error if check fails without ingress, the problem isn't
+// in the ingress resources.
+func bisectIngress(ingress []*extensions.Ingress, check func(ingress []*extensions.Ingress) error) (*extensions.Ingress, error) {
+	err := check(ingress)
+	if err == nil {
+		return nil, nil
+	}
+	if emptyErr := check(nil); emptyErr != nil {
+		return nil, emptyErr
+	}
+	// ingress[:valid] is valid, ingress[:invalid] is invalid
+	valid, invalid := 0, len(ingress)
+	for invalid-valid > 1 {
+		mid := (valid + invalid) / 2
+		if midErr := check(ingress[:mid]); midErr != nil {
+			invalid = mid
+			err = midErr
+		} else {
+			valid = mid
+		}
+	}
+	return ingress[valid], err
+}
+
+func removeIngress(ingress []*extensions.Ingress, ing *extensions.Ingress) []*extensions.Ingress {
+	removed := make([]*extensions.Ingress, 0, len(ingress))
+	for _, item := range ingress {
+		if item != ing {
+			removed = append(removed, item)
+		}
+	}
+	return removed
+}
+
+// summarizeCheckError returns the alerts of the output of a HAProxy
+// check in a single line, or the whole output if there isn't an alert
+func summarizeCheckError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var alerts []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "[ALERT]") {
+			alerts = append(alerts, line)
+		}
+	}
+	if len(alerts) == 0 {
+		return strings.Join(strings.Fields(err.Error()), " ")
+	}
+	return strings.Join(alerts, "; ")
+}
+
+// NewScratchCheck returns a check function of Quarantine.Isolate(), which
+// converts ingress into a scratch configuration of instance and checks it.
+// Logging and events of the conversion are discarded. A configuration
+// without hosts is valid: HAProxy frontends cannot be built without them,
+// the configuration isn't rendered and there is nothing to check.
+func NewScratchCheck(options *ingtypes.ConverterOptions, instance haproxy.Instance, globalConfig map[string]string) func(ingress []*extensions.Ingress) error {
+	checkOptions := *options
+	checkOptions.Logger = &problemCollector{}
+	checkOptions.EventRecorder = nil
+	checkOptions.ConflictNotifier = nil
+	checkOptions.CertCollector = nil
+	checkOptions.Metrics = nil
+	return func(ingress []*extensions.Ingress) error {
+		config := instance.ScratchConfig()
+		NewIngressConverter(&checkOptions, config, globalConfig).Sync(ingress)
+		if len(config.Hosts()) == 0 {
+			return nil
+		}
+		return instance.CheckConfig(config)
+	}
+}
@@ -0,0 +1,509 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+)
+
+// globalKey owns the lookups made by the global config, a change
+// on any of them leads to a full rebuild of the configuration
+const globalKey = "global"
+
+// Tracker keeps the state of the last conversion: the ingress being
+// converted, the hosts and services they declare, and the objects read
+// from the cache on behalf of every host and service. It should be shared
+// between conversions, see NewIncrementalConverter.
+type Tracker struct {
+	config         haproxy.Config
+	globalConfig   map[string]string
+	defaultBackend string
+	defaultSSLFile ingtypes.File
+	ingress        map[string]*trackedIngress
+	lookups        map[string]*trackedLookup
+	userlists      map[*hatypes.Backend][]*hatypes.Userlist
+	hostIngress    map[*hatypes.Host]*extensions.Ingress
+	backendIngress map[*hatypes.Backend]*extensions.Ingress
+	// invalidAnnotations has the annotations which couldn't
+	// be parsed of every ingress and service being converted
+	invalidAnnotations map[ingtypes.Source]map[string]bool
+}
+
+// trackedIngress has the hosts and services declared by an ingress,
+// and the acme domains it registered on the global config
+type trackedIngress struct {
+	ing  *extensions.Ingress
+	keys []string
+	acme map[string][]string
+}
+
+// trackedLookup is an object read from the cache. version identifies
+// its content when it was read, read() reads it again. owners are the
+// hosts and services whose configuration depends on the object.
+type trackedLookup struct {
+	read    func(cache ingtypes.Cache) string
+	version string
+	owners  map[string]bool
+}
+
+// NewTracker ...
+func NewTracker() *Tracker {
+	return &Tracker{}
+}
+
+// affectedKeys returns the hosts and services which should be rebuilt,
+// and false if the whole configuration should be rebuilt instead.
+func (t *Tracker) affectedKeys(options *ingtypes.ConverterOptions, globalConfig map[string]string, ingress []*extensions.Ingress) (map[string]bool, bool) {
+	if t.config == nil ||
+		t.defaultBackend != options.DefaultBackend ||
+		t.defaultSSLFile != options.DefaultSSLFile ||
+		!reflect.DeepEqual(t.globalConfig, globalConfig) {
+		return nil, false
+	}
+	affected := map[string]bool{}
+	for _, lookup := range t.lookups {
+		if lookup.read(options.Cache) != lookup.version {
+			for owner := range lookup.owners {
+				affected[owner] = true
+			}
+		}
+	}
+	if affected[globalKey] {
+		return nil, false
+	}
+	// changed, added and removed ingress
+	current := make(map[string]*extensions.Ingress, len(ingress))
+	for _, ing := range ingress {
+		current[ingressName(ing)] = ing
+	}
+	for name, tracked := range t.ingress {
+		if ing, found := current[name]; !found || ingressChanged(tracked.ing, ing) {
+			addKeys(affected, tracked.keys)
+		}
+	}
+	pending := make([]*extensions.Ingress, 0, len(ingress))
+	for _, ing := range ingress {
+		tracked, found := t.ingress[ingressName(ing)]
+		if !found || ingressChanged(tracked.ing, ing) {
+			addKeys(affected, ingressKeys(ing))
+		} else {
+			pending = append(pending, ing)
+		}
+	}
+	// hosts and services are merged from all the ingress declaring them,
+	// so an ingress sharing an affected host or service is also affected
+	for changed := true; changed; {
+		changed = false
+		unaffected := pending[:0]
+		for _, ing := range pending {
+			keys := t.ingress[ingressName(ing)].keys
+			if hasAnyKey(affected, keys) {
+				addKeys(affected, keys)
+				changed = true
+			} else {
+				unaffected = append(unaffected, ing)
+			}
+		}
+		pending = unaffected
+	}
+	return affected, true
+}
+
+func (t *Tracker) isIngressAffected(ing *extensions.Ingress, affected map[string]bool) bool {
+	tracked, found := t.ingress[ingressName(ing)]
+	return !found || ingressChanged(tracked.ing, ing) || hasAnyKey(affected, tracked.keys)
+}
+
+// commit saves the state of a conversion. affected is nil on full rebuilds,
+// otherwise the state of the unaffected hosts and services are preserved.
+func (t *Tracker) commit(c *converter, ingress []*extensions.Ingress, affected map[string]bool, cache *trackingCache) {
+	ingState := make(map[string]*trackedIngress, len(ingress))
+	for _, ing := range ingress {
+		name := ingressName(ing)
+		if tracked, found := t.ingress[name]; found && affected != nil && !t.isIngressAffected(ing, affected) {
+			tracked.ing = ing
+			ingState[name] = tracked
+		} else {
+			ingState[name] = &trackedIngress{
+				ing:  ing,
+				keys: ingressKeys(ing),
+				acme: c.acmeDomains[name],
+			}
+		}
+	}
+	lookups := cache.lookups
+	if affected != nil {
+		for id, lookup := range t.lookups {
+			owners := map[string]bool{}
+			for owner := range lookup.owners {
+				if !affected[owner] && owner != globalKey {
+					owners[owner] = true
+				}
+			}
+			if len(owners) == 0 {
+				continue
+			}
+			if recorded, found := lookups[id]; found {
+				addKeys(recorded.owners, keysOf(owners))
+			} else {
+				lookup.owners = owners
+				lookups[id] = lookup
+			}
+		}
+	}
+	backends := map[*hatypes.Backend]bool{}
+	for _, backend := range c.haproxy.Backends() {
+		backends[backend] = true
+	}
+	for backend, userlists := range t.userlists {
+		if backends[backend] && c.userlists[backend] == nil {
+			c.userlists[backend] = userlists
+		}
+	}
+	invalid := make(map[ingtypes.Source]map[string]bool, len(c.invalidAnnotations))
+	for source, names := range c.invalidAnnotations {
+		invalid[source] = names
+	}
+	if affected != nil {
+		// sources not read again are the ones reused from the last conversion
+		current := make(map[string]*extensions.Ingress, len(ingress))
+		for _, ing := range ingress {
+			current[ingressName(ing)] = ing
+		}
+		for source, names := range t.invalidAnnotations {
+			if _, found := invalid[source]; found {
+				continue
+			}
+			name := source.Namespace + "/" + source.Name
+			switch source.Type {
+			case "ingress":
+				if ing, found := current[name]; !found || t.isIngressAffected(ing, affected) {
+					continue
+				}
+			case "service":
+				if affected[serviceKey(name)] {
+					continue
+				}
+			}
+			invalid[source] = names
+		}
+	}
+	t.config = c.haproxy
+	t.globalConfig = c.rawGlobalConfig
+	t.invalidAnnotations = invalid
+	t.defaultBackend = c.options.DefaultBackend
+	t.defaultSSLFile = c.options.DefaultSSLFile
+	t.ingress = ingState
+	t.lookups = lookups
+	t.userlists = c.userlists
+	t.hostIngress = c.hostIngress
+	t.backendIngress = c.backendIngress
+}
+
+// reuse adds the unaffected hosts and backends of the last configuration,
+// and returns the ingress which should be converted.
+func (t *Tracker) reuse(c *converter, ingress []*extensions.Ingress, affected map[string]bool) []*extensions.Ingress {
+	var hosts []*hatypes.Host
+	var backends []*hatypes.Backend
+	var userlists []*hatypes.Userlist
+	oldHosts := t.config.Hosts()
+	if host := t.config.DefaultHost(); host != nil {
+		oldHosts = append([]*hatypes.Host{host}, oldHosts...)
+	}
+	for _, host := range oldHosts {
+		if !affected[hostKey(host.Hostname)] {
+			hosts = append(hosts, host)
+			if ing, found := t.hostIngress[host]; found {
+				c.hostIngress[host] = ing
+			}
+		}
+	}
+	for _, backend := range t.config.Backends() {
+		if !affected[serviceKey(backend.Namespace+"/"+backend.Name)] {
+			backends = append(backends, backend)
+			c.reusedBackends[backend] = true
+			if ing, found := t.backendIngress[backend]; found {
+				c.backendIngress[backend] = ing
+			}
+			userlists = append(userlists, t.userlists[backend]...)
+		}
+	}
+	c.haproxy.Reuse(hosts, backends, userlists)
+	sync := make([]*extensions.Ingress, 0, len(ingress))
+	for _, ing := range ingress {
+		if t.isIngressAffected(ing, affected) {
+			sync = append(sync, ing)
+			continue
+		}
+		for secret, domains := range t.ingress[ingressName(ing)].acme {
+			c.haproxy.Global().Acme.AddDomains(secret, domains)
+		}
+	}
+	c.logger.InfoV(2, "syncing %d of %d ingress, reusing %d host(s) and %d backend(s) from the last configuration",
+		len(sync), len(ingress), len(hosts), len(backends))
+	return sync
+}
+
+func ingressName(ing *extensions.Ingress) string {
+	return ing.Namespace + "/" + ing.Name
+}
+
+// ingressChanged compares the tracked and the current version of an ingress.
+// Objects without resourceVersion, eg created by tests, are compared by identity.
+func ingressChanged(tracked, ing *extensions.Ingress) bool {
+	if tracked == ing {
+		return false
+	}
+	return ing.ResourceVersion == "" || tracked.ResourceVersion != ing.ResourceVersion
+}
+
+func hostKey(hostname string) string {
+	return "host:" + hostname
+}
+
+func serviceKey(fullSvcName string) string {
+	return "svc:" + fullSvcName
+}
+
+// ingressKeys lists the hosts and services declared by an ingress. The www
+// and non-www peer of the hosts are also added, see syncFromToWWW().
+func ingressKeys(ing *extensions.Ingress) []string {
+	var keys []string
+	if backend := ing.Spec.Backend; backend != nil {
+		keys = append(keys, hostKey("*"), serviceKey(ing.Namespace+"/"+backend.ServiceName))
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		hostname := rule.Host
+		if hostname == "" {
+			hostname = "*"
+		}
+		keys = append(keys, hostKey(hostname))
+		if hostname != "*" {
+			if strings.HasPrefix(hostname, "www.") {
+				keys = append(keys, hostKey(strings.TrimPrefix(hostname, "www.")))
+			} else {
+				keys = append(keys, hostKey("www."+hostname))
+			}
+		}
+		for _, path := range rule.HTTP.Paths {
+			keys = append(keys, serviceKey(ing.Namespace+"/"+path.Backend.ServiceName))
+		}
+	}
+	return keys
+}
+
+func addKeys(keys map[string]bool, add []string) {
+	for _, key := range add {
+		keys[key] = true
+	}
+}
+
+func hasAnyKey(keys map[string]bool, find []string) bool {
+	for _, key := range find {
+		if keys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+func keysOf(keys map[string]bool) []string {
+	list := make([]string, 0, len(keys))
+	for key := range keys {
+		list = append(list, key)
+	}
+	return list
+}
+
+// trackingCache records the objects read from the cache on behalf of
+// the hosts and services, see Tracker.
+type trackingCache struct {
+	cache   ingtypes.Cache
+	lookups map[string]*trackedLookup
+	owners  []string
+}
+
+func newTrackingCache(cache ingtypes.Cache) *trackingCache {
+	return &trackingCache{
+		cache:   cache,
+		lookups: map[string]*trackedLookup{},
+	}
+}
+
+// setOwners defines the hosts and services which depend on the next lookups.
+func (c *trackingCache) setOwners(owners ...string) {
+	c.owners = owners
+}
+
+func (c *trackingCache) track(id string, version string, read func(cache ingtypes.Cache) string) {
+	lookup, found := c.lookups[id]
+	if !found {
+		lookup = &trackedLookup{
+			read:    read,
+			version: version,
+			owners:  map[string]bool{},
+		}
+		c.lookups[id] = lookup
+	}
+	addKeys(lookup.owners, c.owners)
+}
+
+func (c *trackingCache) GetService(serviceName string) (*api.Service, error) {
+	svc, err := c.cache.GetService(serviceName)
+	c.track("service/"+serviceName, objectVersion(svc, err), func(cache ingtypes.Cache) string {
+		return objectVersion(cache.GetService(serviceName))
+	})
+	return svc, err
+}
+
+func (c *trackingCache) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
+	ep, err := c.cache.GetEndpoints(service)
+	serviceName := service.Namespace + "/" + service.Name
+	c.track("endpoints/"+serviceName, objectVersion(ep, err), func(cache ingtypes.Cache) string {
+		svc, err := cache.GetService(serviceName)
+		if err != nil {
+			return errorVersion(err)
+		}
+		return objectVersion(cache.GetEndpoints(svc))
+	})
+	return ep, err
+}
+
+func (c *trackingCache) GetPod(podName string) (*api.Pod, error) {
+	pod, err := c.cache.GetPod(podName)
+	c.track("pod/"+podName, objectVersion(pod, err), func(cache ingtypes.Cache) string {
+		return objectVersion(cache.GetPod(podName))
+	})
+	return pod, err
+}
+
+func (c *trackingCache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
+	file, err := c.cache.GetTLSSecretPath(secretName)
+	c.track("tls/"+secretName, fileVersion(file, err), func(cache ingtypes.Cache) string {
+		return fileVersion(cache.GetTLSSecretPath(secretName))
+	})
+	return file, err
+}
+
+func (c *trackingCache) GetTLSSecretBundlePath(secretNames []string) (ingtypes.File, error) {
+	file, err := c.cache.GetTLSSecretBundlePath(secretNames)
+	c.track("tlsbundle/"+strings.Join(secretNames, ","), fileVersion(file, err), func(cache ingtypes.Cache) string {
+		return fileVersion(cache.GetTLSSecretBundlePath(secretNames))
+	})
+	return file, err
+}
+
+func (c *trackingCache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	ca, crl, err = c.cache.GetCASecretPath(secretName)
+	c.track("ca/"+secretName, caVersion(ca, crl, err), func(cache ingtypes.Cache) string {
+		return caVersion(cache.GetCASecretPath(secretName))
+	})
+	return ca, crl, err
+}
+
+func (c *trackingCache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
+	file, err := c.cache.GetDHSecretPath(secretName)
+	c.track("dh/"+secretName, fileVersion(file, err), func(cache ingtypes.Cache) string {
+		return fileVersion(cache.GetDHSecretPath(secretName))
+	})
+	return file, err
+}
+
+func (c *trackingCache) GetSecretContent(secretName, keyName string) ([]byte, error) {
+	content, err := c.cache.GetSecretContent(secretName, keyName)
+	c.track("secret/"+secretName+"/"+keyName, contentVersion(content, err), func(cache ingtypes.Cache) string {
+		return contentVersion(cache.GetSecretContent(secretName, keyName))
+	})
+	return content, err
+}
+
+func (c *trackingCache) GetConfigMapContent(configMapName string) (map[string]string, error) {
+	content, err := c.cache.GetConfigMapContent(configMapName)
+	c.track("configmap/"+configMapName, mapVersion(content, err), func(cache ingtypes.Cache) string {
+		return mapVersion(cache.GetConfigMapContent(configMapName))
+	})
+	return content, err
+}
+
+func errorVersion(err error) string {
+	return "error: " + err.Error()
+}
+
+// objectVersion identifies an object by its resourceVersion. Objects
+// without resourceVersion, eg created by tests, are identified by
+// its address, so an updated object should be a new instance.
+func objectVersion(obj metav1.Object, err error) string {
+	if err != nil {
+		return errorVersion(err)
+	}
+	if rv := obj.GetResourceVersion(); rv != "" {
+		return rv
+	}
+	return fmt.Sprintf("%p", obj)
+}
+
+func fileVersion(file ingtypes.File, err error) string {
+	if err != nil {
+		return errorVersion(err)
+	}
+	return file.Filename + "|" + file.SHA1Hash
+}
+
+func caVersion(ca, crl ingtypes.File, err error) string {
+	if err != nil {
+		return errorVersion(err)
+	}
+	return fileVersion(ca, nil) + "|" + fileVersion(crl, nil)
+}
+
+func contentVersion(content []byte, err error) string {
+	if err != nil {
+		return errorVersion(err)
+	}
+	return fmt.Sprintf("%x", sha1.Sum(content))
+}
+
+func mapVersion(content map[string]string, err error) string {
+	if err != nil {
+		return errorVersion(err)
+	}
+	keys := make([]string, 0, len(content))
+	for key := range content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha1.New()
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + content[key] + "\n"))
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
@@ -16,20 +16,40 @@ limitations under the License.
 
 package types
 
+import (
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
 // HostAnnotations ...
 type HostAnnotations struct {
 	Source                 Source `json:"-"`
 	AppRoot                string `json:"app-root"`
 	AuthTLSCertHeader      bool   `json:"auth-tls-cert-header"`
+	AuthTLSCertHeaders     string `json:"auth-tls-cert-headers"`
 	AuthTLSErrorPage       string `json:"auth-tls-error-page"`
 	AuthTLSVerifyClient    string `json:"auth-tls-verify-client"`
 	AuthTLSSecret          string `json:"auth-tls-secret"`
+	CertSigner             string `json:"cert-signer"`
+	ErrorPages             string `json:"error-pages"`
+	FromToWWWRedirect      bool   `json:"from-to-www-redirect"`
+	Maintenance            bool   `json:"maintenance"`
+	MaintenanceBypass      string `json:"maintenance-bypass-source-range"`
+	MaintenancePage        string `json:"maintenance-page"`
+	MaintenanceRetryAfter  int    `json:"maintenance-retry-after"`
+	PermanentRedirect      string `json:"permanent-redirect"`
+	RedirectCode           int    `json:"redirect-code"`
+	RedirectKeepURI        bool   `json:"redirect-keep-uri"`
 	ServerAlias            string `json:"server-alias"`
 	ServerAliasRegex       string `json:"server-alias-regex"`
 	SSLPassthrough         bool   `json:"ssl-passthrough"`
 	SSLPassthroughHTTPPort int    `json:"ssl-passthrough-http-port"`
+	TemporalRedirect       string `json:"temporal-redirect"`
 	TimeoutClient          string `json:"timeout-client"`
 	TimeoutClientFin       string `json:"timeout-client-fin"`
+	TLSCiphers             string `json:"tls-ciphers"`
+	TLSCipherSuites        string `json:"tls-ciphersuites"`
+	TLSMaxVersion          string `json:"tls-max-version"`
+	TLSMinVersion          string `json:"tls-min-version"`
 }
 
 // BackendAnnotations ...
@@ -82,6 +102,9 @@ type BackendAnnotations struct {
 	TimeoutServerFin      string `json:"timeout-server-fin"`
 	TimeoutStop           string `json:"timeout-stop"`
 	TimeoutTunnel         string `json:"timeout-tunnel"`
+	TunnelMaxconnServer   int    `json:"tunnel-maxconn-server"`
+	TunnelTimeout         string `json:"tunnel-timeout"`
+	TunnelUpgrade         bool   `json:"tunnel-upgrade"`
 	UseResolver           string `json:"use-resolver"`
 	WAF                   string `json:"waf"`
 	WhitelistSourceRange  string `json:"whitelist-source-range"`
@@ -97,3 +120,12 @@ type Source struct {
 func (s Source) String() string {
 	return s.Type + " '" + s.Namespace + "/" + s.Name + "'"
 }
+
+// LogFields ...
+func (s Source) LogFields() types.LogFields {
+	return types.LogFields{
+		SourceType:      s.Type,
+		SourceNamespace: s.Namespace,
+		SourceName:      s.Name,
+	}
+}
@@ -18,12 +18,14 @@ package types
 
 // ConfigDefaults ...
 type ConfigDefaults struct {
+	AuthTLSCertHeaders    string `json:"auth-tls-cert-headers"`
 	BalanceAlgorithm      string `json:"balance-algorithm"`
 	CookieKey             string `json:"cookie-key"`
 	HSTS                  bool   `json:"hsts"`
 	HSTSIncludeSubdomains bool   `json:"hsts-include-subdomains"`
 	HSTSMaxAge            string `json:"hsts-max-age"`
 	HSTSPreload           bool   `json:"hsts-preload"`
+	MaintenanceRetryAfter int    `json:"maintenance-retry-after"`
 	ProxyBodySize         string `json:"proxy-body-size"`
 	SSLRedirect           bool   `json:"ssl-redirect"`
 	TimeoutClient         string `json:"timeout-client"`
@@ -39,6 +41,10 @@ type ConfigDefaults struct {
 
 // ConfigGlobals ...
 type ConfigGlobals struct {
+	AcmeEmails                   string `json:"acme-emails"`
+	AcmeEndpoint                 string `json:"acme-endpoint"`
+	AcmeExpiring                 int    `json:"acme-expiring"`
+	AcmeTermsAgreed              bool   `json:"acme-terms-agreed"`
 	BackendCheckInterval         string `json:"backend-check-interval"`
 	BackendServerSlotsIncrement  int    `json:"backend-server-slots-increment"`
 	BindIPAddrHealthz            string `json:"bind-ip-addr-healthz"`
@@ -55,6 +61,7 @@ type ConfigGlobals struct {
 	DNSTimeoutRetry              string `json:"dns-timeout-retry"`
 	DrainSupport                 bool   `json:"drain-support"`
 	DynamicScaling               bool   `json:"dynamic-scaling"`
+	ErrorPages                   string `json:"error-pages"`
 	Forwardfor                   string `json:"forwardfor"`
 	HealthzPort                  int    `json:"healthz-port"`
 	HTTPLogFormat                string `json:"http-log-format"`
@@ -89,6 +96,7 @@ type ConfigGlobals struct {
 	SyslogTag                    string `json:"syslog-tag"`
 	TCPLogFormat                 string `json:"tcp-log-format"`
 	TimeoutStop                  string `json:"timeout-stop"`
+	TLSTicketKeys                string `json:"tls-ticket-keys"`
 	UseProxyProtocol             bool   `json:"use-proxy-protocol"`
 }
 
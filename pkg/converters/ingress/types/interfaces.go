@@ -17,7 +17,10 @@ limitations under the License.
 package types
 
 import (
+	"crypto/x509"
+
 	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // Cache ...
@@ -26,7 +29,46 @@ type Cache interface {
 	GetEndpoints(service *api.Service) (*api.Endpoints, error)
 	GetPod(podName string) (*api.Pod, error)
 	GetTLSSecretPath(secretName string) (File, error)
-	GetCASecretPath(secretName string) (File, error)
+	GetTLSSecretBundlePath(secretNames []string) (File, error)
+	// GetCASecretPath returns a non empty ca along with the error if only the
+	// optional crl could not be read
+	GetCASecretPath(secretName string) (ca, crl File, err error)
 	GetDHSecretPath(secretName string) (File, error)
 	GetSecretContent(secretName, keyName string) ([]byte, error)
+	GetConfigMapContent(configMapName string) (map[string]string, error)
+}
+
+// CertCollector ...
+type CertCollector interface {
+	// ReadCertificate parses the certificate of filename, or returns the
+	// one parsed before if its hash didn't change. Certificates not read
+	// between two calls of Notify are forgotten.
+	ReadCertificate(filename, hash string) (*x509.Certificate, error)
+	// Notify updates the certificates being served, and returns the
+	// expired or hostname mismatch ones which weren't notified before
+	Notify(certs []*CertInfo) []*CertInfo
+}
+
+// ConflictNotifier ...
+type ConflictNotifier interface {
+	// Notify updates the conflicts lost by the synced ingress, forgets the
+	// ones of the ingress not found in current, and returns the conflicts
+	// which weren't notified before
+	Notify(current, synced []string, conflicts []*Conflict) []*Conflict
+}
+
+// Conflict ...
+//
+// A path, TLS or annotation declared by more than one ingress. Ingress
+// is the name of the ingress which lost the conflict to Winner.
+type Conflict struct {
+	Ingress string
+	Winner  string
+	Reason  string
+	Message string
+}
+
+// EventRecorder ...
+type EventRecorder interface {
+	Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{})
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package types
 
 import (
+	"time"
+
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
 )
 
@@ -33,4 +35,29 @@ type ConverterOptions struct {
 	DefaultBackend   string
 	DefaultSSLFile   File
 	AnnotationPrefix string
+	AcmeSocket       string
+	CertCollector    CertCollector
+	EventRecorder    EventRecorder
+	// ConflictNotifier, if assigned, filters the conflict events
+	// already emitted on the last conversions
+	ConflictNotifier ConflictNotifier
+	// Metrics, if assigned, counts the annotations which couldn't be parsed
+	Metrics types.Metrics
+	// HAProxyVersion, if known, skips the features the running HAProxy
+	// doesn't support
+	HAProxyVersion string
+}
+
+// CertInfo ...
+//
+// A certificate being served. Usage is default, host, ca or backend, and
+// Hostname is empty on the default certificate and on backend client certs.
+type CertInfo struct {
+	Filename         string
+	Usage            string
+	Hostname         string
+	NotBefore        time.Time
+	NotAfter         time.Time
+	Expired          bool
+	HostnameMismatch bool
 }
@@ -19,6 +19,8 @@ package utils
 import (
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
@@ -56,12 +58,48 @@ func MergeMap(data map[string]string, resultTo interface{}) error {
 			return fmt.Errorf("error configuring decoder: %v", err)
 		}
 		if err = decoder.Decode(data); err != nil {
-			return fmt.Errorf("error decoding config: %v", err)
+			return &MergeError{
+				Keys: mergeErrorKeys(err),
+				msg:  fmt.Sprintf("error decoding config: %v", err),
+			}
 		}
 	}
 	return nil
 }
 
+// MergeError is returned by MergeMap if some of the values couldn't be decoded
+type MergeError struct {
+	// Keys has the keys of the data map whose values are invalid
+	Keys []string
+	msg  string
+}
+
+func (e *MergeError) Error() string {
+	return e.msg
+}
+
+// the decoder quotes the name of the field in the beginning of the message, e.g.:
+// cannot parse 'name' as int: ...
+// 'name' expected type ...
+var mergeErrorKeyRegex = regexp.MustCompile(`^[^']*'([^']+)'`)
+
+func mergeErrorKeys(err error) []string {
+	var msgs []string
+	if decodeErr, ok := err.(*mapstructure.Error); ok {
+		msgs = decodeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	var keys []string
+	for _, msg := range msgs {
+		if match := mergeErrorKeyRegex.FindStringSubmatch(msg); match != nil {
+			keys = append(keys, match[1])
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // UpdateStruct ...
 //
 // out param need to receive with initialized data from defaults
@@ -68,6 +68,50 @@ func TestLCM(t *testing.T) {
 	}
 }
 
+func TestMergeMap(t *testing.T) {
+	type data struct {
+		Name    string `json:"name"`
+		Age     int    `json:"age"`
+		Enabled bool   `json:"enabled"`
+	}
+	testCases := []struct {
+		data     map[string]string
+		expected data
+		keys     []string
+	}{
+		// 0
+		{
+			data:     map[string]string{"name": "joe", "age": "19", "enabled": "true"},
+			expected: data{Name: "joe", Age: 19, Enabled: true},
+		},
+		// 1
+		{
+			data:     map[string]string{"name": "joe", "age": "old", "enabled": "yes"},
+			expected: data{Name: "joe"},
+			keys:     []string{"age", "enabled"},
+		},
+	}
+	for i, test := range testCases {
+		var d data
+		err := MergeMap(test.data, &d)
+		var keys []string
+		if err != nil {
+			mergeErr, ok := err.(*MergeError)
+			if !ok {
+				t.Errorf("%d: expected MergeError but was %T", i, err)
+				continue
+			}
+			keys = mergeErr.Keys
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%d: expected invalid keys %v but was %v", i, test.keys, keys)
+		}
+		if d != test.expected {
+			t.Errorf("%d: expected %+v but was %+v", i, test.expected, d)
+		}
+	}
+}
+
 func TestUpdateStructSame(t *testing.T) {
 	type data struct {
 		Name string `json:"the-name,option1,option2"`
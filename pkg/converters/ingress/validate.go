@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ingress
+
+import (
+	"fmt"
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+)
+
+// ValidateChanges converts the current ingress and global config, and then
+// the changed ones, and returns the problems found only in the conversion of
+// the changed ones: warnings and errors reported by the converter and the
+// annotations updater, and the conflicts between ingress. Problems already
+// found in the current state don't prevent an unrelated change.
+func ValidateChanges(options *ingtypes.ConverterOptions, curIngress, newIngress []*extensions.Ingress, curConfig, newConfig map[string]string) []string {
+	found := map[string]int{}
+	for _, problem := range convertProblems(options, curIngress, curConfig) {
+		found[problem]++
+	}
+	var problems []string
+	for _, problem := range convertProblems(options, newIngress, newConfig) {
+		if found[problem] > 0 {
+			found[problem]--
+		} else {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// AffectedIngress returns the ingress which share a host or a service
+// with one of the changed ones, see ingressKeys(). These are the ones
+// whose conversion can be changed by the changed ones.
+func AffectedIngress(ingress []*extensions.Ingress, changed ...*extensions.Ingress) []*extensions.Ingress {
+	keys := map[string]bool{}
+	for _, ing := range changed {
+		if ing != nil {
+			addKeys(keys, ingressKeys(ing))
+		}
+	}
+	var affected []*extensions.Ingress
+	for _, ing := range ingress {
+		if hasAnyKey(keys, ingressKeys(ing)) {
+			affected = append(affected, ing)
+		}
+	}
+	return affected
+}
+
+// convertProblems converts ingress into a scratch configuration,
+// and returns the warnings, errors and warning events found.
+func convertProblems(options *ingtypes.ConverterOptions, ingress []*extensions.Ingress, globalConfig map[string]string) []string {
+	collector := &problemCollector{}
+	validateOptions := *options
+	validateOptions.Logger = collector
+	validateOptions.EventRecorder = collector
+	validateOptions.CertCollector = nil
+	validateOptions.ConflictNotifier = nil
+	validateOptions.Metrics = nil
+	config := haproxy.CreateInstance(collector, haproxy.InstanceOptions{}).Config()
+	NewIngressConverter(&validateOptions, config, globalConfig).Sync(ingress)
+	return collector.problems
+}
+
+// problemCollector implements types.Logger and ingtypes.EventRecorder,
+// collecting warnings and errors of a conversion
+type problemCollector struct {
+	mutex    sync.Mutex
+	problems []string
+}
+
+func (c *problemCollector) add(msg string, args []interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	c.mutex.Lock()
+	c.problems = append(c.problems, msg)
+	c.mutex.Unlock()
+}
+
+func (c *problemCollector) InfoV(v int, msg string, args ...interface{}) {}
+
+func (c *problemCollector) Info(msg string, args ...interface{}) {}
+
+func (c *problemCollector) Warn(msg string, args ...interface{}) {
+	c.add(msg, args)
+}
+
+func (c *problemCollector) Error(msg string, args ...interface{}) {
+	c.add(msg, args)
+}
+
+func (c *problemCollector) Fatal(msg string, args ...interface{}) {
+	c.add(msg, args)
+}
+
+func (c *problemCollector) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if eventtype == api.EventTypeWarning {
+		c.add(messageFmt, args)
+	}
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package haproxy
 
 import (
+	"crypto/sha1"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/template"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
@@ -35,6 +38,8 @@ type Config interface {
 	ConfigDefaultX509Cert(filename string)
 	AddUserlist(name string, users []hatypes.User) *hatypes.Userlist
 	FindUserlist(name string) *hatypes.Userlist
+	AcquireErrorPage(code int, headers []string, content string) *hatypes.ErrorPage
+	Reuse(hosts []*hatypes.Host, backends []*hatypes.Backend, userlists []*hatypes.Userlist)
 	BuildFrontendGroup() (*hatypes.FrontendGroup, error)
 	DefaultHost() *hatypes.Host
 	DefaultBackend() *hatypes.Backend
@@ -42,37 +47,41 @@ type Config interface {
 	Hosts() []*hatypes.Host
 	Backends() []*hatypes.Backend
 	Userlists() []*hatypes.Userlist
+	ErrorPages() []*hatypes.ErrorPage
 	Equals(other Config) bool
 }
 
 type config struct {
-	bindUtils       hatypes.BindUtils
 	mapsTemplate    *template.Config
 	mapsDir         string
+	errorPagesDir   string
 	global          *hatypes.Global
 	hosts           []*hatypes.Host
 	backends        []*hatypes.Backend
 	userlists       []*hatypes.Userlist
+	errorPages      []*hatypes.ErrorPage
 	defaultHost     *hatypes.Host
 	defaultBackend  *hatypes.Backend
 	defaultX509Cert string
 }
 
 type options struct {
-	mapsTemplate *template.Config
-	mapsDir      string
+	mapsTemplate  *template.Config
+	mapsDir       string
+	errorPagesDir string
+	masterWorker  bool
 }
 
-func createConfig(bindUtils hatypes.BindUtils, options options) *config {
+func createConfig(options options) *config {
 	mapsTemplate := options.mapsTemplate
 	if mapsTemplate == nil {
 		mapsTemplate = template.CreateConfig()
 	}
 	return &config{
-		bindUtils:    bindUtils,
-		global:       &hatypes.Global{},
-		mapsTemplate: mapsTemplate,
-		mapsDir:      options.mapsDir,
+		global:        &hatypes.Global{MasterWorker: options.masterWorker},
+		mapsTemplate:  mapsTemplate,
+		mapsDir:       options.mapsDir,
+		errorPagesDir: options.errorPagesDir,
 	}
 }
 
@@ -187,38 +196,180 @@ func (c *config) FindUserlist(name string) *hatypes.Userlist {
 	return nil
 }
 
+func (c *config) AcquireErrorPage(code int, headers []string, content string) *hatypes.ErrorPage {
+	// the filename depends on the content, so a changed page
+	// leads to a distinct configuration and a reload
+	hash := sha1.New()
+	for _, header := range headers {
+		hash.Write([]byte(header + "\r\n"))
+	}
+	hash.Write([]byte(content))
+	filename := fmt.Sprintf("%s/%d_%x.http", c.errorPagesDir, code, hash.Sum(nil))
+	for _, page := range c.errorPages {
+		if page.Filename == filename {
+			return page
+		}
+	}
+	page := &hatypes.ErrorPage{
+		Code:     code,
+		Headers:  headers,
+		Content:  content,
+		Filename: filename,
+	}
+	c.errorPages = append(c.errorPages, page)
+	sort.Slice(c.errorPages, func(i, j int) bool {
+		return c.errorPages[i].Filename < c.errorPages[j].Filename
+	})
+	return page
+}
+
+// Reuse adds hosts, backends and userlists of a former configuration, which
+// should be added before the ones being acquired. The error pages of the hosts
+// are also added. Reused objects are shared with the former configuration and
+// should not be changed.
+func (c *config) Reuse(hosts []*hatypes.Host, backends []*hatypes.Backend, userlists []*hatypes.Userlist) {
+	for _, host := range hosts {
+		if host.Hostname == "*" {
+			c.defaultHost = host
+		} else {
+			c.hosts = append(c.hosts, host)
+		}
+		pages := host.ErrorPages
+		if host.Maintenance.Page != nil {
+			pages = append([]*hatypes.ErrorPage{host.Maintenance.Page}, pages...)
+		}
+		for _, page := range pages {
+			if !c.hasErrorPage(page.Filename) {
+				c.errorPages = append(c.errorPages, page)
+			}
+		}
+	}
+	sort.Slice(c.hosts, func(i, j int) bool {
+		return c.hosts[i].Hostname < c.hosts[j].Hostname
+	})
+	sort.Slice(c.errorPages, func(i, j int) bool {
+		return c.errorPages[i].Filename < c.errorPages[j].Filename
+	})
+	c.backends = append(c.backends, backends...)
+	c.sortBackends()
+	c.userlists = append(c.userlists, userlists...)
+	sort.Slice(c.userlists, func(i, j int) bool {
+		return c.userlists[i].Name < c.userlists[j].Name
+	})
+}
+
+func (c *config) hasErrorPage(filename string) bool {
+	for _, page := range c.errorPages {
+		if page.Filename == filename {
+			return true
+		}
+	}
+	return false
+}
+
+type mapEntry struct {
+	Key   string
+	Value string
+}
+
+// hostsMap splits the entries of exact and wildcard hostnames. Wildcard
+// hostnames are written as regex in a distinct map file, which should be
+// consulted only if the exact match fails.
+type hostsMap struct {
+	exact []mapEntry
+	regex []mapEntry
+}
+
+func (m *hostsMap) add(host *hatypes.Host, path, value string) {
+	if host.IsWildcard() {
+		key := host.HostnameRegex() + regexp.QuoteMeta(path)
+		if path == "" {
+			key += "$"
+		}
+		m.regex = append(m.regex, mapEntry{Key: key, Value: value})
+	} else {
+		m.exact = append(m.exact, mapEntry{Key: host.Hostname + path, Value: value})
+	}
+}
+
+// acquireHTTPErrorPagesFront returns the internal frontend of the plain HTTP
+// requests of host, whose error pages are the same of host. Returns nil if
+// host uses the global error pages, which are configured in the HTTP frontend.
+func (c *config) acquireHTTPErrorPagesFront(fgroup *hatypes.FrontendGroup, host *hatypes.Host) *hatypes.HTTPErrorPagesFront {
+	if sameErrorPages(host.ErrorPages, c.global.ErrorPages) {
+		return nil
+	}
+	for _, front := range fgroup.HTTPErrorPagesFronts {
+		if sameErrorPages(front.ErrorPages, host.ErrorPages) {
+			return front
+		}
+	}
+	name := fmt.Sprintf("_http_errorpages_%03d", len(fgroup.HTTPErrorPagesFronts)+1)
+	front := &hatypes.HTTPErrorPagesFront{
+		Name:        "_front_" + name,
+		BackendName: name,
+		Socket:      fmt.Sprintf("unix@/var/run/front_%s.sock", name),
+		ErrorPages:  host.ErrorPages,
+	}
+	fgroup.HTTPErrorPagesFronts = append(fgroup.HTTPErrorPagesFronts, front)
+	return front
+}
+
+func sameErrorPages(pages1, pages2 []*hatypes.ErrorPage) bool {
+	if len(pages1) != len(pages2) {
+		return false
+	}
+	for i := range pages1 {
+		if pages1[i].Filename != pages2[i].Filename {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *hostsMap) len() int {
+	return len(m.exact) + len(m.regex)
+}
+
+func (c *config) writeHostsMap(m *hostsMap, output, regexOutput string) error {
+	if err := c.mapsTemplate.WriteOutput(m.exact, output); err != nil {
+		return err
+	}
+	return c.mapsTemplate.WriteOutput(m.regex, regexOutput)
+}
+
 func (c *config) BuildFrontendGroup() (*hatypes.FrontendGroup, error) {
 	if len(c.hosts) == 0 {
 		return nil, fmt.Errorf("cannot create frontends without hosts")
 	}
 	frontends, sslpassthrough := hatypes.BuildRawFrontends(c.hosts)
 	fgroup := &hatypes.FrontendGroup{
-		Frontends:         frontends,
-		HasSSLPassthrough: len(sslpassthrough) > 0,
-		HTTPFrontsMap:     c.mapsDir + "/http-front.map",
-		RedirectMap:       c.mapsDir + "/redirect.map",
-		SSLPassthroughMap: c.mapsDir + "/sslpassthrough.map",
+		Frontends:              frontends,
+		HasSSLPassthrough:      len(sslpassthrough) > 0,
+		HTTPErrorPagesMap:      c.mapsDir + "/http-errorpages.map",
+		HTTPErrorPagesRegexMap: c.mapsDir + "/http-errorpages_regex.map",
+		HTTPFrontsMap:          c.mapsDir + "/http-front.map",
+		HTTPFrontsRegexMap:     c.mapsDir + "/http-front_regex.map",
+		RedirectMap:            c.mapsDir + "/redirect.map",
+		RedirectRegexMap:       c.mapsDir + "/redirect_regex.map",
+		RedirectPathsMap:       c.mapsDir + "/redirect-path.map",
+		RedirectPathsRegexMap:  c.mapsDir + "/redirect-path_regex.map",
+		SSLPassthroughMap:      c.mapsDir + "/sslpassthrough.map",
+		SSLPassthroughRegexMap: c.mapsDir + "/sslpassthrough_regex.map",
+		TunnelBackendsList:     c.mapsDir + "/tunnel-backends.list",
 	}
 	if fgroup.HasTCPProxy() {
-		// More than one HAProxy's frontend or bind, or using ssl-passthrough config,
+		// More than one HAProxy's frontend, or using ssl-passthrough config,
 		// so need a `mode tcp` frontend with `inspect-delay` and `req.ssl_sni`
 		var i int
 		for _, frontend := range frontends {
 			for _, bind := range frontend.Binds {
 				var bindName string
 				if len(bind.Hosts) == 1 {
-					bindName = bind.Hosts[0].Hostname
-					bind.TLS.TLSCert = c.defaultX509Cert
-					bind.TLS.TLSCertDir = bind.Hosts[0].TLS.TLSFilename
+					bindName = bind.Hosts[0].ProxyName()
 				} else {
 					i++
 					bindName = fmt.Sprintf("_socket%03d", i)
-					x509dir, err := c.createCertsDir(bindName, bind.Hosts)
-					if err != nil {
-						return nil, err
-					}
-					bind.TLS.TLSCert = c.defaultX509Cert
-					bind.TLS.TLSCertDir = x509dir
 				}
 				bind.Name = bindName
 				bind.Socket = fmt.Sprintf("unix@/var/run/front_%s.sock", bindName)
@@ -230,171 +381,301 @@ func (c *config) BuildFrontendGroup() (*hatypes.FrontendGroup, error) {
 		bind := frontends[0].Binds[0]
 		bind.Name = "_public"
 		bind.Socket = ":443"
-		if len(bind.Hosts) == 1 {
-			bind.TLS.TLSCert = c.defaultX509Cert
-			bind.TLS.TLSCertDir = bind.Hosts[0].TLS.TLSFilename
-		} else {
-			x509dir, err := c.createCertsDir(bind.Name, bind.Hosts)
-			if err != nil {
-				return nil, err
-			}
-			frontends[0].Binds[0].TLS.TLSCert = c.defaultX509Cert
-			frontends[0].Binds[0].TLS.TLSCertDir = x509dir
-		}
 	}
 	for _, frontend := range frontends {
 		mapsPrefix := c.mapsDir + "/" + frontend.Name
 		frontend.HostBackendsMap = mapsPrefix + "_host.map"
+		frontend.HostBackendsRegexMap = mapsPrefix + "_host_regex.map"
+		frontend.RedirectPathsMap = mapsPrefix + "_redirect_path.map"
+		frontend.RedirectPathsRegexMap = mapsPrefix + "_redirect_path_regex.map"
 		frontend.SNIBackendsMap = mapsPrefix + "_sni.map"
+		frontend.SNIBackendsRegexMap = mapsPrefix + "_sni_regex.map"
+		frontend.TLSCrtHeadersMap = mapsPrefix + "_crt_headers.map"
+		frontend.TLSCrtHeadersRegexMap = mapsPrefix + "_crt_headers_regex.map"
 		frontend.TLSInvalidCrtErrorList = mapsPrefix + "_inv_crt.list"
+		frontend.TLSInvalidCrtErrorRegexList = mapsPrefix + "_inv_crt_regex.list"
 		frontend.TLSInvalidCrtErrorPagesMap = mapsPrefix + "_inv_crt_redir.map"
+		frontend.TLSInvalidCrtErrorPagesRegexMap = mapsPrefix + "_inv_crt_redir_regex.map"
 		frontend.TLSNoCrtErrorList = mapsPrefix + "_no_crt.list"
+		frontend.TLSNoCrtErrorRegexList = mapsPrefix + "_no_crt_regex.list"
 		frontend.TLSNoCrtErrorPagesMap = mapsPrefix + "_no_crt_redir.map"
+		frontend.TLSNoCrtErrorPagesRegexMap = mapsPrefix + "_no_crt_redir_regex.map"
 		frontend.VarNamespaceMap = mapsPrefix + "_k8s_ns.map"
+		frontend.VarNamespaceRegexMap = mapsPrefix + "_k8s_ns_regex.map"
 		for _, bind := range frontend.Binds {
 			bind.UseServerList = mapsPrefix + "_bind_" + bind.Name + ".list"
+			bind.UseServerRegexList = mapsPrefix + "_bind_" + bind.Name + "_regex.list"
+			bind.TLS.CrtListFile = mapsPrefix + "_bind_" + bind.Name + "_crt.list"
 		}
 	}
-	type mapEntry struct {
-		Key   string
-		Value string
-	}
-	var sslpassthroughMap []mapEntry
-	var redirectMap []mapEntry
-	var httpFront []mapEntry
+	var sslpassthroughMap hostsMap
+	var redirectMap hostsMap
+	var httpFront hostsMap
+	var httpErrorPagesMap hostsMap
+	var httpRedirectPathsMap hostsMap
 	yesno := map[bool]string{true: "yes", false: "no"}
+	addHTTPFront := func(host *hatypes.Host, path, backendID string) {
+		httpFront.add(host, path, backendID)
+		if front := c.acquireHTTPErrorPagesFront(fgroup, host); front != nil {
+			httpErrorPagesMap.add(host, path, front.BackendName)
+		}
+	}
 	for _, sslpassHost := range sslpassthrough {
 		rootPath := sslpassHost.FindPath("/")
 		if rootPath == nil {
 			return nil, fmt.Errorf("missing root path on host %s", sslpassHost.Hostname)
 		}
-		sslpassthroughMap = append(sslpassthroughMap, mapEntry{
-			Key:   sslpassHost.Hostname,
-			Value: rootPath.BackendID,
-		})
-		redirectMap = append(redirectMap, mapEntry{
-			Key:   sslpassHost.Hostname + "/",
-			Value: yesno[sslpassHost.HTTPPassthroughBackend == nil],
-		})
+		sslpassthroughMap.add(sslpassHost, "", rootPath.BackendID)
+		redirectMap.add(sslpassHost, "/", yesno[sslpassHost.HTTPPassthroughBackend == nil])
 		if sslpassHost.HTTPPassthroughBackend != nil {
-			httpFront = append(httpFront, mapEntry{
-				Key:   sslpassHost.Hostname + "/",
-				Value: sslpassHost.HTTPPassthroughBackend.ID,
-			})
+			addHTTPFront(sslpassHost, "/", sslpassHost.HTTPPassthroughBackend.ID)
 		} else {
 			fgroup.HasRedirectHTTPS = true
 		}
+		if sslpassHost.IsWildcard() {
+			fgroup.HasWildcardHost = true
+		}
 	}
 	for _, f := range frontends {
-		var hostBackendsMap []mapEntry
-		var sniBackendsMap []mapEntry
-		var invalidCrtList []mapEntry
-		var invalidCrtMap []mapEntry
-		var noCrtList []mapEntry
-		var noCrtMap []mapEntry
-		var varNamespaceMap []mapEntry
+		var hostBackendsMap hostsMap
+		var redirectPathsMap hostsMap
+		var sniBackendsMap hostsMap
+		var crtHeadersMap hostsMap
+		crtHeaders := map[string]bool{}
+		var invalidCrtList hostsMap
+		var invalidCrtMap hostsMap
+		var noCrtList hostsMap
+		var noCrtMap hostsMap
+		var varNamespaceMap hostsMap
 		for _, host := range f.Hosts {
+			if host.IsWildcard() {
+				fgroup.HasWildcardHost = true
+			}
 			for _, path := range host.Paths {
 				// TODO use only root path if all uri has the same conf
-				redirectMap = append(redirectMap, mapEntry{
-					Key:   host.Hostname + path.Path,
-					Value: yesno[path.Backend.SSLRedirect],
-				})
-				entry := mapEntry{
-					Key:   host.Hostname + path.Path,
-					Value: path.BackendID,
-				}
+				redirectMap.add(host, path.Path, yesno[path.Backend.SSLRedirect])
 				if host.HasTLSAuth() {
-					sniBackendsMap = append(sniBackendsMap, entry)
+					sniBackendsMap.add(host, path.Path, path.BackendID)
 				} else {
-					hostBackendsMap = append(hostBackendsMap, entry)
+					hostBackendsMap.add(host, path.Path, path.BackendID)
 				}
 				if path.Backend.SSLRedirect {
 					fgroup.HasRedirectHTTPS = true
 				} else {
-					httpFront = append(httpFront, entry)
+					addHTTPFront(host, path.Path, path.BackendID)
 				}
 				if host.VarNamespace {
-					entry.Value = path.Backend.Namespace
+					varNamespaceMap.add(host, path.Path, path.Backend.Namespace)
 				} else {
-					entry.Value = "-"
+					varNamespaceMap.add(host, path.Path, "-")
+				}
+				if host.HasRedirectPath() {
+					// the value identifies the matching path,
+					// paths without redirect should not redirect
+					value := "-"
+					if path.Redirect.URL != "" {
+						value = host.Hostname + path.Path
+					}
+					redirectPathsMap.add(host, path.Path, value)
+					httpRedirectPathsMap.add(host, path.Path, value)
 				}
-				varNamespaceMap = append(varNamespaceMap, entry)
 			}
 			if host.HasTLSAuth() {
-				var entry mapEntry
-				entry.Key = host.Hostname
-				invalidCrtList = append(invalidCrtList, entry)
+				if len(host.TLS.CertHeaders) > 0 {
+					// the value is matched as a substring, delimiters avoid partial matches
+					crtHeadersMap.add(host, "", ","+strings.Join(host.TLS.CertHeaders, ",")+",")
+					for _, header := range host.TLS.CertHeaders {
+						crtHeaders[header] = true
+					}
+				}
+				invalidCrtList.add(host, "", "")
 				if !host.TLS.CAVerifyOptional {
-					noCrtList = append(noCrtList, entry)
+					noCrtList.add(host, "", "")
 				}
 				if host.TLS.CAErrorPage != "" {
-					entry.Value = host.TLS.CAErrorPage
-					invalidCrtMap = append(invalidCrtMap, entry)
+					invalidCrtMap.add(host, "", host.TLS.CAErrorPage)
 					if !host.TLS.CAVerifyOptional {
-						noCrtMap = append(noCrtMap, entry)
+						noCrtMap.add(host, "", host.TLS.CAErrorPage)
 					}
 				}
 			}
 		}
 		for _, bind := range f.Binds {
-			var useServerList []mapEntry
+			var useServerList hostsMap
 			for _, host := range bind.Hosts {
-				useServerList = append(useServerList, mapEntry{Key: host.Hostname})
+				useServerList.add(host, "", "")
 			}
-			if err := c.mapsTemplate.WriteOutput(useServerList, bind.UseServerList); err != nil {
+			if err := c.writeHostsMap(&useServerList, bind.UseServerList, bind.UseServerRegexList); err != nil {
 				return nil, err
 			}
+			if err := c.writeCrtList(bind); err != nil {
+				return nil, err
+			}
+		}
+		if err := c.writeHostsMap(&hostBackendsMap, f.HostBackendsMap, f.HostBackendsRegexMap); err != nil {
+			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(hostBackendsMap, f.HostBackendsMap); err != nil {
+		if err := c.writeHostsMap(&redirectPathsMap, f.RedirectPathsMap, f.RedirectPathsRegexMap); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(sniBackendsMap, f.SNIBackendsMap); err != nil {
+		if err := c.writeHostsMap(&sniBackendsMap, f.SNIBackendsMap, f.SNIBackendsRegexMap); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(invalidCrtList, f.TLSInvalidCrtErrorList); err != nil {
+		f.TLSCrtHeaders = nil
+		for header := range crtHeaders {
+			f.TLSCrtHeaders = append(f.TLSCrtHeaders, header)
+		}
+		sort.Strings(f.TLSCrtHeaders)
+		if err := c.writeHostsMap(&crtHeadersMap, f.TLSCrtHeadersMap, f.TLSCrtHeadersRegexMap); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(invalidCrtMap, f.TLSInvalidCrtErrorPagesMap); err != nil {
+		if err := c.writeHostsMap(&invalidCrtList, f.TLSInvalidCrtErrorList, f.TLSInvalidCrtErrorRegexList); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(noCrtList, f.TLSNoCrtErrorList); err != nil {
+		if err := c.writeHostsMap(&invalidCrtMap, f.TLSInvalidCrtErrorPagesMap, f.TLSInvalidCrtErrorPagesRegexMap); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(noCrtMap, f.TLSNoCrtErrorPagesMap); err != nil {
+		if err := c.writeHostsMap(&noCrtList, f.TLSNoCrtErrorList, f.TLSNoCrtErrorRegexList); err != nil {
 			return nil, err
 		}
-		if err := c.mapsTemplate.WriteOutput(varNamespaceMap, f.VarNamespaceMap); err != nil {
+		if err := c.writeHostsMap(&noCrtMap, f.TLSNoCrtErrorPagesMap, f.TLSNoCrtErrorPagesRegexMap); err != nil {
 			return nil, err
 		}
+		if err := c.writeHostsMap(&varNamespaceMap, f.VarNamespaceMap, f.VarNamespaceRegexMap); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.writeHostsMap(&sslpassthroughMap, fgroup.SSLPassthroughMap, fgroup.SSLPassthroughRegexMap); err != nil {
+		return nil, err
+	}
+	if err := c.writeHostsMap(&redirectMap, fgroup.RedirectMap, fgroup.RedirectRegexMap); err != nil {
+		return nil, err
+	}
+	if err := c.writeHostsMap(&httpFront, fgroup.HTTPFrontsMap, fgroup.HTTPFrontsRegexMap); err != nil {
+		return nil, err
 	}
-	if err := c.mapsTemplate.WriteOutput(sslpassthroughMap, fgroup.SSLPassthroughMap); err != nil {
+	if err := c.writeHostsMap(&httpErrorPagesMap, fgroup.HTTPErrorPagesMap, fgroup.HTTPErrorPagesRegexMap); err != nil {
 		return nil, err
 	}
-	if err := c.mapsTemplate.WriteOutput(redirectMap, fgroup.RedirectMap); err != nil {
+	if err := c.writeHostsMap(&httpRedirectPathsMap, fgroup.RedirectPathsMap, fgroup.RedirectPathsRegexMap); err != nil {
 		return nil, err
 	}
-	if err := c.mapsTemplate.WriteOutput(httpFront, fgroup.HTTPFrontsMap); err != nil {
+	var tunnelBackendsList []mapEntry
+	for _, backend := range c.backends {
+		if backend.Tunnel.Enabled {
+			tunnelBackendsList = append(tunnelBackendsList, mapEntry{Key: backend.ID})
+		}
+	}
+	if err := c.mapsTemplate.WriteOutput(tunnelBackendsList, fgroup.TunnelBackendsList); err != nil {
 		return nil, err
 	}
-	fgroup.HasHTTPHost = len(httpFront) > 0
+	if len(c.global.SSL.TicketKeys) > 0 {
+		fgroup.TLSTicketKeysFile = c.ticketKeysFile()
+		if err := c.writeTicketKeys(); err != nil {
+			return nil, err
+		}
+	}
+	fgroup.HasTunnelBackend = len(tunnelBackendsList) > 0
+	fgroup.HasHTTPHost = httpFront.len() > 0
+	fgroup.HasRedirectPath = httpRedirectPathsMap.len() > 0
 	return fgroup, nil
 }
 
-func (c *config) createCertsDir(bindName string, hosts []*hatypes.Host) (string, error) {
-	certs := make([]string, 0, len(hosts))
-	added := map[string]bool{}
-	for _, host := range hosts {
+func (c *config) ticketKeysFile() string {
+	return c.mapsDir + "/tls-ticket.keys"
+}
+
+// writeTicketKeys writes the TLS session ticket keys, one per line. HAProxy
+// doesn't support comments in this file, so it's written as is, staged
+// along with the maps.
+func (c *config) writeTicketKeys() error {
+	content := strings.Join(c.global.SSL.TicketKeys, "\n") + "\n"
+	return c.mapsTemplate.WriteFile(c.ticketKeysFile(), []byte(content), 0600)
+}
+
+// writeCrtList writes the crt-list file of a bind. The default certificate
+// is the first entry, followed by the entries of the hosts with client cert
+// auth or custom TLS config, which need the SNI filter, and the remaining
+// certificates without filter, so HAProxy selects them from the names
+// declared in the certificate.
+func (c *config) writeCrtList(bind *hatypes.BindConfig) error {
+	crtList := []*hatypes.CrtListEntry{{Filename: c.defaultX509Cert}}
+	var crtListNoFilter []*hatypes.CrtListEntry
+	added := map[string]bool{c.defaultX509Cert: true}
+	for _, host := range bind.Hosts {
 		filename := host.TLS.TLSFilename
-		if filename != "" && !added[filename] && filename != c.defaultX509Cert {
-			certs = append(certs, host.TLS.TLSFilename)
+		if filename == "" {
+			filename = c.defaultX509Cert
+		}
+		if (host.HasTLSAuth() || host.HasTLSConfig()) && host.Hostname != "*" {
+			sniFilter := []string{host.Hostname}
+			if host.Alias.AliasName != "" {
+				sniFilter = append(sniFilter, host.Alias.AliasName)
+			}
+			crt := &hatypes.CrtListEntry{
+				Filename:     filename,
+				Ciphers:      host.TLS.Ciphers,
+				CipherSuites: host.TLS.CipherSuites,
+				MinVersion:   host.TLS.MinVersion,
+				MaxVersion:   host.TLS.MaxVersion,
+				SNIFilter:    sniFilter,
+			}
+			if host.HasTLSAuth() {
+				crt.CAFile = host.TLS.CAFilename
+				crt.CRLFile = host.TLS.CRLFilename
+				crt.Verify = "optional"
+			}
+			crtList = append(crtList, crt)
+		} else if !added[filename] {
+			crtListNoFilter = append(crtListNoFilter, &hatypes.CrtListEntry{
+				Filename: filename,
+			})
 			added[filename] = true
 		}
 	}
-	if len(certs) == 0 {
-		return "", nil
+	crtList = append(crtList, crtListNoFilter...)
+	entries := make([]mapEntry, len(crtList))
+	for i, crt := range crtList {
+		entries[i] = mapEntry{
+			Key:   crt.Filename,
+			Value: crtListEntryOptions(crt),
+		}
 	}
-	return c.bindUtils.CreateX509CertsDir(bindName, certs)
+	return c.mapsTemplate.WriteOutput(entries, bind.TLS.CrtListFile)
+}
+
+func crtListEntryOptions(crt *hatypes.CrtListEntry) string {
+	var options []string
+	if crt.ALPN != "" {
+		options = append(options, "alpn "+crt.ALPN)
+	}
+	if crt.Ciphers != "" {
+		options = append(options, "ciphers "+crt.Ciphers)
+	}
+	if crt.CipherSuites != "" {
+		options = append(options, "ciphersuites "+crt.CipherSuites)
+	}
+	if crt.MinVersion != "" {
+		options = append(options, "ssl-min-ver "+crt.MinVersion)
+	}
+	if crt.MaxVersion != "" {
+		options = append(options, "ssl-max-ver "+crt.MaxVersion)
+	}
+	if crt.Verify != "" {
+		options = append(options, "verify "+crt.Verify)
+	}
+	if crt.CAFile != "" {
+		options = append(options, "ca-file "+crt.CAFile)
+	}
+	if crt.CRLFile != "" {
+		options = append(options, "crl-file "+crt.CRLFile)
+	}
+	var params []string
+	if len(options) > 0 {
+		params = append(params, "["+strings.Join(options, " ")+"]")
+	}
+	params = append(params, crt.SNIFilter...)
+	return strings.Join(params, " ")
 }
 
 func (c *config) DefaultHost() *hatypes.Host {
@@ -421,6 +702,78 @@ func (c *config) Userlists() []*hatypes.Userlist {
 	return c.userlists
 }
 
+func (c *config) ErrorPages() []*hatypes.ErrorPage {
+	return c.errorPages
+}
+
+// runtimeChanges returns the changes between other and c which can be
+// applied in runtime: the certificate files whose content changed, and the
+// TLS ticket keys added by a rotation. ok is true only if both configurations
+// are the same except by these changes.
+func (c *config) runtimeChanges(other Config) (certs, ticketKeys []string, ok bool) {
+	c2, ok := other.(*config)
+	if !ok || len(c.hosts) != len(c2.hosts) || (c.defaultHost == nil) != (c2.defaultHost == nil) {
+		return nil, nil, false
+	}
+	hosts := append([]*hatypes.Host{}, c.hosts...)
+	hosts2 := append([]*hatypes.Host{}, c2.hosts...)
+	if c.defaultHost != nil {
+		hosts = append(hosts, c.defaultHost)
+		hosts2 = append(hosts2, c2.defaultHost)
+	}
+	added := map[string]bool{}
+	var restore []func()
+	defer func() {
+		for _, r := range restore {
+			r()
+		}
+	}()
+	for i, host := range hosts {
+		host2 := hosts2[i]
+		if host.TLS.TLSHash == host2.TLS.TLSHash {
+			continue
+		}
+		if host.Hostname != host2.Hostname || host.TLS.TLSFilename != host2.TLS.TLSFilename {
+			return nil, nil, false
+		}
+		if file := host.TLS.TLSFilename; !added[file] {
+			added[file] = true
+			certs = append(certs, file)
+		}
+		// temporarily copies the hash, so the remaining config can be compared
+		hash2 := host2.TLS.TLSHash
+		host2.TLS.TLSHash = host.TLS.TLSHash
+		restore = append(restore, func() { host2.TLS.TLSHash = hash2 })
+	}
+	if keys, keys2 := c.global.SSL.TicketKeys, c2.global.SSL.TicketKeys; !reflect.DeepEqual(keys, keys2) {
+		ticketKeys = rotatedTicketKeys(keys2, keys)
+		if len(ticketKeys) == 0 {
+			return nil, nil, false
+		}
+		c2.global.SSL.TicketKeys = keys
+		restore = append(restore, func() { c2.global.SSL.TicketKeys = keys2 })
+	}
+	if (len(certs) == 0 && len(ticketKeys) == 0) || !reflect.DeepEqual(c, c2) {
+		return nil, nil, false
+	}
+	return certs, ticketKeys, true
+}
+
+// rotatedTicketKeys returns the keys appended to old in order to build cur,
+// if cur is a rotation of old: the same amount of keys, the oldest ones
+// removed from the start, the new ones added to the end.
+func rotatedTicketKeys(old, cur []string) []string {
+	if len(old) == 0 || len(old) != len(cur) {
+		return nil
+	}
+	for i := 1; i < len(old); i++ {
+		if reflect.DeepEqual(old[i:], cur[:len(cur)-i]) {
+			return cur[len(cur)-i:]
+		}
+	}
+	return nil
+}
+
 func (c *config) Equals(other Config) bool {
 	c2, ok := other.(*config)
 	if !ok {
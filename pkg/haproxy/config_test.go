@@ -17,13 +17,16 @@ limitations under the License.
 package haproxy
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
-	ha_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/helper_test"
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 )
 
 func TestEmptyFrontend(t *testing.T) {
-	c := createConfig(&ha_helper.BindUtilsMock{}, options{})
+	c := createConfig(options{})
 	if _, err := c.BuildFrontendGroup(); err == nil {
 		t.Error("expected error creating empty frontend")
 	}
@@ -33,8 +36,51 @@ func TestEmptyFrontend(t *testing.T) {
 	}
 }
 
+func TestHTTPErrorPagesFronts(t *testing.T) {
+	c := createConfig(options{})
+	page503 := c.AcquireErrorPage(503, nil, "global 503")
+	pageA := c.AcquireErrorPage(503, nil, "A 503")
+	pageB := c.AcquireErrorPage(502, nil, "B 502")
+	pageC := c.AcquireErrorPage(502, nil, "C 502")
+	c.Global().ErrorPages = []*hatypes.ErrorPage{page503}
+	b := c.AcquireBackend("d", "app", 8080)
+	bssl := c.AcquireBackend("d", "app-ssl", 8080)
+	bssl.SSLRedirect = true
+	addHost := func(hostname string, backend *hatypes.Backend, pages ...*hatypes.ErrorPage) {
+		h := c.AcquireHost(hostname)
+		h.AddPath(backend, "/")
+		h.ErrorPages = pages
+	}
+	addHost("d1.local", b, page503)
+	addHost("d2.local", b, pageA)
+	addHost("*.d3.local", b, pageA)
+	addHost("d4.local", b, page503, pageB)
+	// plain HTTP requests are redirected, its error pages aren't used
+	addHost("d5.local", bssl, pageC)
+
+	fgroup, err := c.BuildFrontendGroup()
+	if err != nil {
+		t.Fatalf("error creating frontends: %v", err)
+	}
+	var fronts []string
+	for _, front := range fgroup.HTTPErrorPagesFronts {
+		var pages []string
+		for _, page := range front.ErrorPages {
+			pages = append(pages, page.Content)
+		}
+		fronts = append(fronts, fmt.Sprintf("%s/%s/%s: %s", front.Name, front.BackendName, front.Socket, strings.Join(pages, ",")))
+	}
+	expected := []string{
+		"_front__http_errorpages_001/_http_errorpages_001/unix@/var/run/front__http_errorpages_001.sock: A 503",
+		"_front__http_errorpages_002/_http_errorpages_002/unix@/var/run/front__http_errorpages_002.sock: global 503,B 502",
+	}
+	if !reflect.DeepEqual(fronts, expected) {
+		t.Errorf("expected fronts:\n%s\nbut was:\n%s", strings.Join(expected, "\n"), strings.Join(fronts, "\n"))
+	}
+}
+
 func TestAcquireHostDiff(t *testing.T) {
-	c := createConfig(&ha_helper.BindUtilsMock{}, options{})
+	c := createConfig(options{})
 	f1 := c.AcquireHost("h1")
 	f2 := c.AcquireHost("h2")
 	if f1.Hostname != "h1" {
@@ -46,7 +92,7 @@ func TestAcquireHostDiff(t *testing.T) {
 }
 
 func TestAcquireHostSame(t *testing.T) {
-	c := createConfig(&ha_helper.BindUtilsMock{}, options{})
+	c := createConfig(options{})
 	f1 := c.AcquireHost("h1")
 	f2 := c.AcquireHost("h1")
 	if f1 != f2 {
@@ -72,9 +118,65 @@ func TestBuildID(t *testing.T) {
 	}
 }
 
+func TestCrtListEntryOptions(t *testing.T) {
+	testCases := []struct {
+		entry    hatypes.CrtListEntry
+		expected string
+	}{
+		// 0
+		{
+			entry:    hatypes.CrtListEntry{},
+			expected: "",
+		},
+		// 1
+		{
+			entry:    hatypes.CrtListEntry{SNIFilter: []string{"d1.local", "*.d1.local"}},
+			expected: "d1.local *.d1.local",
+		},
+		// 2
+		{
+			entry:    hatypes.CrtListEntry{ALPN: "h2", Ciphers: "ECDHE-RSA-AES128-GCM-SHA256"},
+			expected: "[alpn h2 ciphers ECDHE-RSA-AES128-GCM-SHA256]",
+		},
+		// 3
+		{
+			entry: hatypes.CrtListEntry{
+				CAFile:    "/var/haproxy/ssl/ca/d1.pem",
+				Verify:    "optional",
+				SNIFilter: []string{"d1.local"},
+			},
+			expected: "[verify optional ca-file /var/haproxy/ssl/ca/d1.pem] d1.local",
+		},
+		// 4
+		{
+			entry: hatypes.CrtListEntry{
+				CAFile:    "/var/haproxy/ssl/ca/d1.pem",
+				CRLFile:   "/var/haproxy/ssl/ca/crl-d1.pem",
+				Verify:    "optional",
+				SNIFilter: []string{"d1.local"},
+			},
+			expected: "[verify optional ca-file /var/haproxy/ssl/ca/d1.pem crl-file /var/haproxy/ssl/ca/crl-d1.pem] d1.local",
+		},
+		// 5
+		{
+			entry: hatypes.CrtListEntry{
+				CipherSuites: "TLS_AES_128_GCM_SHA256",
+				MinVersion:   "TLSv1.3",
+				SNIFilter:    []string{"d1.local", "www.d1.local"},
+			},
+			expected: "[ciphersuites TLS_AES_128_GCM_SHA256 ssl-min-ver TLSv1.3] d1.local www.d1.local",
+		},
+	}
+	for i, test := range testCases {
+		if actual := crtListEntryOptions(&test.entry); actual != test.expected {
+			t.Errorf("%d: expected '%s' but was '%s'", i, test.expected, actual)
+		}
+	}
+}
+
 func TestEqual(t *testing.T) {
-	c1 := createConfig(&ha_helper.BindUtilsMock{}, options{})
-	c2 := createConfig(&ha_helper.BindUtilsMock{}, options{})
+	c1 := createConfig(options{})
+	c2 := createConfig(options{})
 	if !c1.Equals(c2) {
 		t.Error("c1 and c2 should be equals (empty)")
 	}
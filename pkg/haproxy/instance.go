@@ -17,66 +17,142 @@ limitations under the License.
 package haproxy
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/dynconfig"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/template"
 	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
 )
 
 // InstanceOptions ...
 type InstanceOptions struct {
+	// AdminSocket is used to update certificates in runtime,
+	// an empty socket always reloads HAProxy
+	AdminSocket       string
 	MaxOldConfigFiles int
 	HAProxyCmd        string
 	HAProxyConfigFile string
 	ReloadCmd         string
 	ReloadStrategy    string
+	// Supervisor, if assigned, manages HAProxy in master-worker mode
+	// and is used to reload it instead of ReloadCmd
+	Supervisor Supervisor
+	// TemplatesDir, MapsDir and ErrorPagesDir default to the
+	// directories of the controller image, below /etc/haproxy
+	TemplatesDir  string
+	MapsDir       string
+	ErrorPagesDir string
+	// StagingDir is where a new configuration is written and checked before
+	// replacing the current one, defaults to the staging dir below the dir
+	// of HAProxyConfigFile. Should be in the same filesystem of the files.
+	StagingDir string
+	// ReloadInterval is the minimum time between two reloads. A reload
+	// requested before the interval is deferred, and the changes made in
+	// the meantime are applied by the same reload. ReloadDeferred is called
+	// with the remaining time and should schedule a new Update() call.
+	ReloadInterval time.Duration
+	ReloadDeferred func(delay time.Duration)
+	// HAProxyVersion, if known, skips the runtime updates
+	// the running HAProxy doesn't support
+	HAProxyVersion string
+	// Metrics, if assigned, observes the time spent rendering,
+	// checking and reloading the configuration
+	Metrics types.Metrics
 }
 
 // Instance ...
 type Instance interface {
+	prometheus.Collector
 	ParseTemplates() error
 	Config() Config
-	Update()
+	Update() error
+	Write() error
+	ScratchConfig() Config
+	CheckConfig(config Config) error
 }
 
 // CreateInstance ...
-func CreateInstance(logger types.Logger, bindUtils hatypes.BindUtils, options InstanceOptions) Instance {
+func CreateInstance(logger types.Logger, options InstanceOptions) Instance {
 	dynconf := &dynconfig.Config{
 		Logger: logger,
 	}
+	if options.HAProxyConfigFile == "" {
+		options.HAProxyConfigFile = "/etc/haproxy/haproxy.cfg"
+	}
+	if options.TemplatesDir == "" {
+		options.TemplatesDir = "/etc/haproxy"
+	}
+	if options.MapsDir == "" {
+		options.MapsDir = "/etc/haproxy/maps"
+	}
+	if options.ErrorPagesDir == "" {
+		options.ErrorPagesDir = "/etc/haproxy/errorpages"
+	}
+	if options.StagingDir == "" {
+		options.StagingDir = filepath.Dir(options.HAProxyConfigFile) + "/staging"
+	}
 	return &instance{
-		logger:       logger,
-		bindUtils:    bindUtils,
-		options:      &options,
-		templates:    template.CreateConfig(),
-		mapsTemplate: template.CreateConfig(),
-		mapsDir:      "/etc/haproxy/maps",
-		dynconfig:    dynconf,
+		logger:        logger,
+		options:       &options,
+		templates:     template.CreateConfig(),
+		mapsTemplate:  template.CreateConfig(),
+		errorPages:    template.CreateConfig(),
+		mapsDir:       options.MapsDir,
+		errorPagesDir: options.ErrorPagesDir,
+		dynconfig:     dynconf,
+		now:           time.Now,
+		deferredReloadsDesc: prometheus.NewDesc(
+			"ingress_controller_deferred_reloads_total",
+			"Number of HAProxy reloads deferred due to the reload interval",
+			nil,
+			nil,
+		),
 	}
 }
 
 type instance struct {
-	logger       types.Logger
-	bindUtils    hatypes.BindUtils
-	options      *InstanceOptions
-	templates    *template.Config
-	mapsTemplate *template.Config
-	mapsDir      string
-	dynconfig    *dynconfig.Config
-	oldConfig    Config
-	curConfig    Config
+	logger        types.Logger
+	options       *InstanceOptions
+	templates     *template.Config
+	mapsTemplate  *template.Config
+	errorPages    *template.Config
+	mapsDir       string
+	errorPagesDir string
+	dynconfig     *dynconfig.Config
+	oldConfig     Config
+	curConfig     Config
+	failedConfig  Config
+	now           func() time.Time
+	lastReload    time.Time
+	reloadPending bool
+	// deferredReloads is read by the collector, use atomic
+	deferredReloads     int64
+	deferredReloadsDesc *prometheus.Desc
 }
 
 func (i *instance) ParseTemplates() error {
 	i.templates.ClearTemplates()
 	i.mapsTemplate.ClearTemplates()
+	templatesDir := i.options.TemplatesDir
+	configDir := filepath.Dir(i.options.HAProxyConfigFile)
 	if err := i.templates.NewTemplate(
 		"spoe-modsecurity.tmpl",
-		"/etc/haproxy/modsecurity/spoe-modsecurity.tmpl",
-		"/etc/haproxy/spoe-modsecurity.conf",
+		templatesDir+"/modsecurity/spoe-modsecurity.tmpl",
+		configDir+"/spoe-modsecurity.conf",
 		0,
 		1024,
 	); err != nil {
@@ -84,8 +160,8 @@ func (i *instance) ParseTemplates() error {
 	}
 	if err := i.templates.NewTemplate(
 		"haproxy.tmpl",
-		"/etc/haproxy/template/haproxy.tmpl",
-		"/etc/haproxy/haproxy.cfg",
+		templatesDir+"/template/haproxy.tmpl",
+		i.options.HAProxyConfigFile,
 		i.options.MaxOldConfigFiles,
 		16384,
 	); err != nil {
@@ -93,7 +169,7 @@ func (i *instance) ParseTemplates() error {
 	}
 	err := i.mapsTemplate.NewTemplate(
 		"map.tmpl",
-		"/etc/haproxy/maptemplate/map.tmpl",
+		templatesDir+"/maptemplate/map.tmpl",
 		"",
 		0,
 		2048,
@@ -103,68 +179,426 @@ func (i *instance) ParseTemplates() error {
 
 func (i *instance) Config() Config {
 	if i.curConfig == nil {
-		config := createConfig(i.bindUtils, options{
-			mapsTemplate: i.mapsTemplate,
-			mapsDir:      i.mapsDir,
-		})
-		i.curConfig = config
+		i.curConfig = i.ScratchConfig()
 	}
 	return i.curConfig
 }
 
-func (i *instance) Update() {
+// ScratchConfig creates a new configuration which is not
+// the current one, used to check a configuration
+func (i *instance) ScratchConfig() Config {
+	return createConfig(options{
+		mapsTemplate:  i.mapsTemplate,
+		mapsDir:       i.mapsDir,
+		errorPagesDir: i.errorPagesDir,
+		masterWorker:  i.options.Supervisor != nil,
+	})
+}
+
+// Update applies the current configuration. The configuration files are
+// written in the staging dir and checked, and only replace the files of
+// the running configuration if HAProxy validates them. Returns the error
+// of HAProxy if the configuration is invalid, other errors are logged.
+// A new configuration which matches the last invalid one is skipped.
+func (i *instance) Update() error {
 	if i.curConfig == nil {
 		i.logger.InfoV(2, "new configuration is empty")
-		return
+		return nil
 	}
 	if i.curConfig.Equals(i.oldConfig) {
 		i.logger.InfoV(2, "old and new configurations match, skipping reload")
 		i.clearConfig()
-		return
+		i.applyPendingReload()
+		return nil
 	}
-	if err := i.templates.Write(i.curConfig); err != nil {
-		i.logger.Error("error writing configuration: %v", err)
+	if i.failedConfig != nil && i.curConfig.Equals(i.failedConfig) {
+		i.logger.InfoV(2, "new configuration matches the last invalid one, skipping reload")
+		i.curConfig = nil
+		i.applyPendingReload()
+		return nil
+	}
+	if updated, ok := i.updateRuntime(); ok {
 		i.clearConfig()
-		return
+		i.logger.Info("HAProxy %s updated without needing to reload", updated)
+		return nil
 	}
+	start := time.Now()
+	err := i.stageConfig(i.curConfig)
+	i.observe(types.Metrics.ObserveRender, start)
+	if err != nil {
+		i.logger.Error("error writing configuration: %v", err)
+		i.discardConfig()
+		return nil
+	}
+	start = time.Now()
+	err = i.check()
+	i.observe(types.Metrics.ObserveCheck, start)
+	if err != nil {
+		i.logger.Error("error validating config file, keeping the running configuration:\n%v", err)
+		i.failedConfig = i.curConfig
+		i.discardConfig()
+		return err
+	}
+	if err := i.commitConfig(); err != nil {
+		i.logger.Error("error writing configuration: %v", err)
+		i.discardConfig()
+		return nil
+	}
+	i.removeErrorPages(i.curConfig.ErrorPages())
 	updated := i.dynconfig.Update()
 	i.clearConfig()
-	if err := i.check(); err != nil {
-		i.logger.Error("error validating config file:\n%v", err)
-		return
-	}
 	if updated {
 		i.logger.Info("HAProxy updated without needing to reload")
+		return nil
+	}
+	i.reloadHAProxy()
+	return nil
+}
+
+// reloadHAProxy reloads HAProxy, or defers the reload if the last one
+// happened less than the reload interval ago. The configuration files
+// are already updated, a deferred reload applies the files found on disk.
+func (i *instance) reloadHAProxy() {
+	if delay := i.lastReload.Add(i.options.ReloadInterval).Sub(i.now()); delay > 0 {
+		atomic.AddInt64(&i.deferredReloads, 1)
+		if !i.reloadPending {
+			i.reloadPending = true
+			i.scheduleReload(delay)
+		}
+		i.logger.Info("HAProxy reload deferred, the last one was less than %s ago", i.options.ReloadInterval)
 		return
 	}
-	if err := i.reload(); err != nil {
+	i.reloadPending = false
+	i.lastReload = i.now()
+	start := time.Now()
+	err := i.reload()
+	i.observe(types.Metrics.ObserveReload, start)
+	if err != nil {
 		i.logger.Error("error reloading server:\n%v", err)
 		return
 	}
-	i.clearConfig()
 	i.logger.Info("HAProxy successfully reloaded")
 }
 
+// applyPendingReload reloads HAProxy if a deferred reload is pending and
+// the reload interval has elapsed, otherwise schedules it again
+func (i *instance) applyPendingReload() {
+	if !i.reloadPending {
+		return
+	}
+	if delay := i.lastReload.Add(i.options.ReloadInterval).Sub(i.now()); delay > 0 {
+		i.scheduleReload(delay)
+		return
+	}
+	i.reloadHAProxy()
+}
+
+// observe reports the time elapsed since start to the metrics, if assigned
+func (i *instance) observe(observer func(types.Metrics, time.Duration), start time.Time) {
+	if i.options.Metrics != nil {
+		observer(i.options.Metrics, time.Since(start))
+	}
+}
+
+func (i *instance) scheduleReload(delay time.Duration) {
+	if i.options.ReloadDeferred != nil {
+		i.options.ReloadDeferred(delay)
+	}
+}
+
+func (i *instance) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.deferredReloadsDesc
+}
+
+func (i *instance) Collect(ch chan<- prometheus.Metric) {
+	deferredReloads := atomic.LoadInt64(&i.deferredReloads)
+	ch <- prometheus.MustNewConstMetric(i.deferredReloadsDesc, prometheus.CounterValue, float64(deferredReloads))
+}
+
+// CheckConfig writes config in the staging dir and checks it, without
+// changing the current configuration and the running one
+func (i *instance) CheckConfig(config Config) error {
+	err := i.stageConfig(config)
+	if err == nil {
+		err = i.check()
+	}
+	i.discardStaging()
+	return err
+}
+
+// stageConfig writes the error pages, the configuration files and
+// the maps of config in the staging dir, see commitConfig() and check()
+func (i *instance) stageConfig(config Config) error {
+	i.templates.Stage(i.options.StagingDir)
+	i.mapsTemplate.Stage(i.options.StagingDir + "/maps")
+	i.errorPages.Stage(i.options.StagingDir + "/errorpages")
+	if err := i.writeErrorPages(config.ErrorPages()); err != nil {
+		return fmt.Errorf("error writing error pages: %v", err)
+	}
+	return i.templates.Write(config)
+}
+
+// commitConfig moves the staged error pages, maps and configuration
+// files to their places. Configuration files are moved last, they
+// use the other ones. The files already moved are restored if one of
+// them cannot be moved, the running configuration is left unchanged.
+func (i *instance) commitConfig() error {
+	if err := os.MkdirAll(i.errorPagesDir, 0755); err != nil {
+		i.discardStaging()
+		return err
+	}
+	if err := os.MkdirAll(i.mapsDir, 0755); err != nil {
+		i.discardStaging()
+		return err
+	}
+	configs := []*template.Config{i.errorPages, i.mapsTemplate, i.templates}
+	for j, config := range configs {
+		if err := config.Commit(); err != nil {
+			for _, pending := range configs[j+1:] {
+				pending.Discard()
+			}
+			for k := j - 1; k >= 0; k-- {
+				if rollbackErr := configs[k].Rollback(); rollbackErr != nil {
+					i.logger.Error("error restoring the running configuration: %v", rollbackErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// discardStaging finishes the staging without changing the files
+// of the running configuration
+func (i *instance) discardStaging() {
+	i.templates.Discard()
+	i.mapsTemplate.Discard()
+	i.errorPages.Discard()
+}
+
+// Write writes the error pages, the maps and the configuration files of
+// the current configuration, without comparing with the last one and
+// without checking or reloading HAProxy. Used to render a configuration
+// outside of a running controller.
+func (i *instance) Write() error {
+	if i.curConfig == nil {
+		return fmt.Errorf("configuration is empty")
+	}
+	if err := os.MkdirAll(i.mapsDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(i.errorPagesDir, 0755); err != nil {
+		return err
+	}
+	if err := i.writeErrorPages(i.curConfig.ErrorPages()); err != nil {
+		return fmt.Errorf("error writing error pages: %v", err)
+	}
+	if err := i.templates.Write(i.curConfig); err != nil {
+		return fmt.Errorf("error writing configuration: %v", err)
+	}
+	i.removeErrorPages(i.curConfig.ErrorPages())
+	return nil
+}
+
+// updateRuntime sends the changed certificates and the new TLS ticket keys
+// to the running instance if they are the only change in the configuration.
+// Returns what was updated, and false if a reload is needed: the configuration
+// has other changes, the runtime API is not configured, or the update failed.
+func (i *instance) updateRuntime() (string, bool) {
+	if i.options.AdminSocket == "" || i.oldConfig == nil {
+		return "", false
+	}
+	cur, ok := i.curConfig.(*config)
+	if !ok {
+		return "", false
+	}
+	certs, ticketKeys, ok := cur.runtimeChanges(i.oldConfig)
+	if !ok {
+		return "", false
+	}
+	if len(certs) > 0 && !VersionAtLeast(i.options.HAProxyVersion, RuntimeCertVersion) {
+		// a reload is the only way to update certificates, not a failure
+		return "", false
+	}
+	var updated []string
+	if len(certs) > 0 {
+		for _, cert := range certs {
+			if err := i.updateCert(cert); err != nil {
+				i.logger.Warn("error updating certificate '%s' in runtime, reloading: %v", cert, err)
+				return "", false
+			}
+			i.logger.InfoV(2, "certificate '%s' updated in runtime", cert)
+		}
+		updated = append(updated, "certificates")
+	}
+	if len(ticketKeys) > 0 {
+		if err := i.updateTicketKeys(cur, ticketKeys); err != nil {
+			i.logger.Warn("error updating TLS ticket keys in runtime, reloading: %v", err)
+			return "", false
+		}
+		i.logger.InfoV(2, "%d TLS ticket key(s) updated in runtime", len(ticketKeys))
+		updated = append(updated, "TLS ticket keys")
+	}
+	return strings.Join(updated, " and "), true
+}
+
+// updateTicketKeys writes the keys file, used on the next reload, and
+// sends the new keys to the running instance, from the oldest to the newest.
+func (i *instance) updateTicketKeys(cur *config, keys []string) error {
+	if err := cur.writeTicketKeys(); err != nil {
+		return err
+	}
+	file := cur.ticketKeysFile()
+	for _, key := range keys {
+		out, err := utils.HAProxyCommand(i.options.AdminSocket, "set ssl tls-key "+file+" "+key)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(out, "TLS ticket key updated!") {
+			return errors.New(strings.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+func (i *instance) updateCert(cert string) error {
+	content, err := ioutil.ReadFile(cert)
+	if os.IsNotExist(err) {
+		// multi-cert bundles are written to .rsa and .ecdsa suffixed files
+		return fmt.Errorf("certificate bundles are not supported")
+	} else if err != nil {
+		return err
+	}
+	// the payload ends on the first empty line
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	out, err := utils.HAProxyCommand(i.options.AdminSocket, "set ssl cert "+cert+" <<\n"+strings.Join(lines, "\n")+"\n")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(out, "Transaction created") && !strings.Contains(out, "Transaction updated") {
+		return errors.New(strings.TrimSpace(out))
+	}
+	out, err = utils.HAProxyCommand(i.options.AdminSocket, "commit ssl cert "+cert)
+	if err == nil && !strings.Contains(out, "Success!") {
+		err = errors.New(strings.TrimSpace(out))
+	}
+	if err != nil {
+		// the transaction should be aborted, the reload fallback doesn't use it
+		utils.HAProxyCommand(i.options.AdminSocket, "abort ssl cert "+cert)
+		return err
+	}
+	return nil
+}
+
+// writeErrorPages writes the error pages missing in the error pages
+// dir, in the staging dir if staging is in progress
+func (i *instance) writeErrorPages(pages []*hatypes.ErrorPage) error {
+	for _, page := range pages {
+		if _, err := os.Stat(page.Filename); err == nil {
+			// content addressed filename, already up to date
+			continue
+		}
+		if err := i.errorPages.WriteFile(page.Filename, buildErrorFile(page), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeErrorPages removes the error pages not used by the
+// configuration, after it replaced the running one
+func (i *instance) removeErrorPages(pages []*hatypes.ErrorPage) {
+	used := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		used[page.Filename] = true
+	}
+	oldFiles, err := filepath.Glob(i.errorPagesDir + "/*.http")
+	if err != nil {
+		i.logger.Warn("cannot list old error pages: %v", err)
+		return
+	}
+	for _, file := range oldFiles {
+		if !used[file] {
+			if err := os.Remove(file); err != nil {
+				i.logger.Warn("cannot remove old error page '%s': %v", file, err)
+			}
+		}
+	}
+}
+
+func buildErrorFile(page *hatypes.ErrorPage) []byte {
+	header := fmt.Sprintf(""+
+		"HTTP/1.0 %d %s\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"Connection: close\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Content-Length: %d\r\n", page.Code, http.StatusText(page.Code), len(page.Content))
+	for _, h := range page.Headers {
+		header += h + "\r\n"
+	}
+	return []byte(header + "\r\n" + page.Content)
+}
+
+// check validates the staged configuration. HAProxy reads a copy of the
+// staged haproxy.cfg which references the staged maps and error pages
+// instead of the ones of the running configuration.
 func (i *instance) check() error {
 	if i.options.HAProxyCmd == "" {
 		i.logger.Info("(test) check was skipped")
 		return nil
 	}
-	out, err := exec.Command(i.options.HAProxyCmd, "-c", "-f", i.options.HAProxyConfigFile).CombinedOutput()
+	stagedConfig := i.templates.StagedFiles()[i.options.HAProxyConfigFile]
+	if stagedConfig == "" {
+		return fmt.Errorf("configuration file was not staged")
+	}
+	config, err := ioutil.ReadFile(stagedConfig)
 	if err != nil {
-		return fmt.Errorf(string(out))
+		return err
+	}
+	// longer names first, a name shouldn't replace the prefix of another one
+	stagedMaps := i.mapsTemplate.StagedFiles()
+	for output, staged := range i.errorPages.StagedFiles() {
+		stagedMaps[output] = staged
+	}
+	outputs := make([]string, 0, len(stagedMaps))
+	for output := range stagedMaps {
+		outputs = append(outputs, output)
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return len(outputs[i]) > len(outputs[j])
+	})
+	replaces := make([]string, 0, 2*len(outputs))
+	for _, output := range outputs {
+		replaces = append(replaces, output, stagedMaps[output])
+	}
+	checkFile := filepath.Join(i.options.StagingDir, "check-"+filepath.Base(stagedConfig))
+	checkConfig := strings.NewReplacer(replaces...).Replace(string(config))
+	if err := ioutil.WriteFile(checkFile, []byte(checkConfig), 0644); err != nil {
+		return err
+	}
+	out, err := exec.Command(i.options.HAProxyCmd, "-c", "-f", checkFile).CombinedOutput()
+	if err != nil {
+		return errors.New(string(out))
 	}
 	return nil
 }
 
 func (i *instance) reload() error {
+	if i.options.Supervisor != nil {
+		return i.options.Supervisor.Reload()
+	}
 	if i.options.ReloadCmd == "" {
 		i.logger.Info("(test) reload was skipped")
 		return nil
 	}
 	out, err := exec.Command(i.options.ReloadCmd, i.options.ReloadStrategy, i.options.HAProxyConfigFile).CombinedOutput()
 	if len(out) > 0 {
-		return fmt.Errorf(string(out))
+		return errors.New(string(out))
 	} else if err != nil {
 		return err
 	}
@@ -175,4 +609,12 @@ func (i *instance) clearConfig() {
 	// TODO releaseConfig (old support files, ...)
 	i.oldConfig = i.curConfig
 	i.curConfig = nil
+	i.failedConfig = nil
+}
+
+// discardConfig drops the current configuration which couldn't be
+// applied, the running one is preserved as the old configuration
+func (i *instance) discardConfig() {
+	i.discardStaging()
+	i.curConfig = nil
 }
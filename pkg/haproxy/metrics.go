@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// CRLCollector exports the next update of the certificate
+// revocation lists used by the client certificate authentication
+type CRLCollector interface {
+	prometheus.Collector
+	Notify(crls []string)
+}
+
+// NewCRLCollector creates a prometheus collector of the CRL files
+// being used. Files are read and parsed on every scrape, so an updated
+// CRL is reported as soon as it's written.
+func NewCRLCollector(logger types.Logger) CRLCollector {
+	return &crlCollector{
+		logger: logger,
+		nextUpdate: prometheus.NewDesc(
+			"ingress_controller_crl_next_update_seconds",
+			"Number of seconds since 1970 to the next update of a certificate revocation list",
+			[]string{"crl"},
+			nil,
+		),
+	}
+}
+
+type crlCollector struct {
+	logger     types.Logger
+	mutex      sync.Mutex
+	crls       []string
+	nextUpdate *prometheus.Desc
+}
+
+func (c *crlCollector) Notify(crls []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.crls = crls
+}
+
+func (c *crlCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nextUpdate
+}
+
+func (c *crlCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	crls := c.crls
+	c.mutex.Unlock()
+	for _, crl := range crls {
+		data, err := ioutil.ReadFile(crl)
+		if err != nil {
+			c.logger.Warn("error reading CRL file: %v", err)
+			continue
+		}
+		nextUpdate, err := ssl.CRLNextUpdate(data)
+		if err != nil {
+			c.logger.Warn("error reading next update of '%s': %v", crl, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.nextUpdate, prometheus.GaugeValue, float64(nextUpdate.Unix()), crl)
+	}
+}
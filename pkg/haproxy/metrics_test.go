@@ -0,0 +1,64 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestCRLCollector(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	nextUpdate := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	crl := tempdir + "/crl-default_ca.pem"
+	if err := ioutil.WriteFile(crl, ssl_helper.CreateCRL(ssl_helper.CreateCA("ca"), nextUpdate), 0644); err != nil {
+		t.Fatalf("error writing crl: %v", err)
+	}
+	missing := tempdir + "/crl-missing.pem"
+
+	logger := &types_helper.LoggerMock{T: t}
+	collector := NewCRLCollector(logger)
+	collector.Notify([]string{crl, missing})
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+	actual := map[string]float64{}
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		actual[m.Label[0].GetValue()] = m.Gauge.GetValue()
+	}
+	expected := map[string]float64{crl: float64(nextUpdate.Unix())}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected metrics %v but was %v", expected, actual)
+	}
+	logger.CompareLogging("WARN error reading CRL file: open " + missing + ": no such file or directory")
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// OCSPUpdater staples OCSP responses to the certificates served by HAProxy
+type OCSPUpdater interface {
+	prometheus.Collector
+	Notify(certs []string)
+	Start(stopCh <-chan struct{})
+}
+
+// OCSPOptions ...
+type OCSPOptions struct {
+	// Socket is the admin socket used to update the responses in runtime,
+	// an empty socket only writes the .ocsp files
+	Socket string
+	// CheckPeriod is the interval between checks of responses to be refreshed
+	CheckPeriod time.Duration
+	HTTPClient  *http.Client
+}
+
+// NewOCSPUpdater creates the OCSP stapling worker. The response of every certificate
+// is written to a .ocsp file beside the certificate, so it's loaded on HAProxy reloads,
+// and sent to the running instance via `set ssl ocsp-response`. A response is refreshed
+// on the half of its validity, or on every CheckPeriod if the responder does not
+// provide the next update.
+func NewOCSPUpdater(logger types.Logger, options OCSPOptions) OCSPUpdater {
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &ocspUpdater{
+		logger:     logger,
+		options:    options,
+		httpClient: httpClient,
+		trigger:    make(chan struct{}, 1),
+		staples:    map[string]*ocspStaple{},
+		stale: prometheus.NewDesc(
+			"ingress_controller_ocsp_staple_stale",
+			"Whether the certificate doesn't have a valid OCSP response, 1 means stale",
+			[]string{"certificate"},
+			nil,
+		),
+		failures: prometheus.NewDesc(
+			"ingress_controller_ocsp_update_failures_total",
+			"Number of failed OCSP response updates of a certificate",
+			[]string{"certificate"},
+			nil,
+		),
+	}
+}
+
+type ocspUpdater struct {
+	logger     types.Logger
+	options    OCSPOptions
+	httpClient *http.Client
+	trigger    chan struct{}
+	mutex      sync.Mutex
+	staples    map[string]*ocspStaple
+	stale      *prometheus.Desc
+	failures   *prometheus.Desc
+}
+
+type ocspStaple struct {
+	response    *ssl.OCSPResponse
+	refresh     time.Time
+	failures    int
+	noResponder bool
+}
+
+var errNoResponder = errors.New("certificate does not have an OCSP responder")
+
+func (u *ocspUpdater) Notify(certs []string) {
+	u.mutex.Lock()
+	changed := false
+	used := make(map[string]bool, len(certs))
+	for _, cert := range certs {
+		used[cert] = true
+		if _, found := u.staples[cert]; !found {
+			u.staples[cert] = &ocspStaple{}
+			changed = true
+		}
+	}
+	for cert := range u.staples {
+		if !used[cert] {
+			delete(u.staples, cert)
+		}
+	}
+	u.mutex.Unlock()
+	if changed {
+		select {
+		case u.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (u *ocspUpdater) Start(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(u.options.CheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-u.trigger:
+		}
+		u.updateAll()
+	}
+}
+
+func (u *ocspUpdater) updateAll() {
+	u.mutex.Lock()
+	certs := make([]string, 0, len(u.staples))
+	for cert := range u.staples {
+		certs = append(certs, cert)
+	}
+	u.mutex.Unlock()
+	sort.Strings(certs)
+	now := time.Now()
+	for _, cert := range certs {
+		u.mutex.Lock()
+		staple, found := u.staples[cert]
+		refresh := found && !staple.noResponder && !now.Before(staple.refresh)
+		u.mutex.Unlock()
+		if refresh {
+			u.update(cert)
+		}
+	}
+}
+
+func (u *ocspUpdater) update(cert string) {
+	response, err := u.fetch(cert)
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	staple, found := u.staples[cert]
+	if !found {
+		// removed in the meantime
+		return
+	}
+	if err == errNoResponder {
+		u.logger.InfoV(2, "ocsp: skipping '%s': %v", cert, err)
+		staple.noResponder = true
+		return
+	}
+	if err != nil {
+		u.logger.Warn("ocsp: error updating response of '%s': %v", cert, err)
+		staple.failures++
+		staple.refresh = time.Now().Add(u.options.CheckPeriod)
+		return
+	}
+	if response.Status == ssl.OCSPRevoked {
+		u.logger.Warn("ocsp: certificate '%s' was revoked at %s", cert, response.RevokedAt.Format(time.RFC3339))
+	}
+	staple.response = response
+	if response.NextUpdate.IsZero() {
+		staple.refresh = time.Now().Add(u.options.CheckPeriod)
+	} else {
+		staple.refresh = response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+	}
+	u.logger.InfoV(2, "ocsp: response of '%s' updated, next refresh at %s", cert, staple.refresh.Format(time.RFC3339))
+}
+
+// fetch requests, validates and stores the OCSP response of the certificate
+func (u *ocspUpdater) fetch(certFile string) (*ssl.OCSPResponse, error) {
+	cert, issuer, err := u.readCertificates(certFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert.OCSPServer) == 0 {
+		return nil, errNoResponder
+	}
+	req, err := ssl.CreateOCSPRequest(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.httpClient.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned HTTP status %d", resp.StatusCode)
+	}
+	der, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	response, err := ssl.ParseOCSPResponse(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(certFile+".ocsp", der, 0644); err != nil {
+		return nil, err
+	}
+	if u.options.Socket != "" {
+		cmd := "set ssl ocsp-response " + base64.StdEncoding.EncodeToString(der)
+		out, err := utils.HAProxyCommand(u.options.Socket, cmd)
+		if err != nil {
+			return nil, fmt.Errorf("error sending response to HAProxy: %v", err)
+		}
+		if !strings.Contains(out, "OCSP Response updated") {
+			return nil, fmt.Errorf("error sending response to HAProxy: %s", strings.TrimSpace(out))
+		}
+	}
+	return response, nil
+}
+
+// readCertificates reads the leaf and the issuer certificates. The issuer should be
+// in the chain of the PEM file, otherwise it's downloaded from the issuer URL.
+func (u *ocspUpdater) readCertificates(certFile string) (cert, issuer *x509.Certificate, err error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("certificate not found")
+	}
+	cert = certs[0]
+	for _, c := range certs[1:] {
+		if bytes.Equal(c.RawSubject, cert.RawIssuer) {
+			return cert, c, nil
+		}
+	}
+	if len(cert.OCSPServer) == 0 {
+		return cert, nil, nil
+	}
+	if len(cert.IssuingCertificateURL) == 0 {
+		return nil, nil, fmt.Errorf("issuer certificate not found")
+	}
+	resp, err := u.httpClient.Get(cert.IssuingCertificateURL[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading issuer certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	der, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading issuer certificate: %v", err)
+	}
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+	issuer, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing issuer certificate: %v", err)
+	}
+	return cert, issuer, nil
+}
+
+func (u *ocspUpdater) Describe(ch chan<- *prometheus.Desc) {
+	ch <- u.stale
+	ch <- u.failures
+}
+
+func (u *ocspUpdater) Collect(ch chan<- prometheus.Metric) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	now := time.Now()
+	for cert, staple := range u.staples {
+		if staple.noResponder {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(cert), ".pem")
+		stale := 0.0
+		if staple.response == nil || (!staple.response.NextUpdate.IsZero() && staple.response.NextUpdate.Before(now)) {
+			stale = 1
+		}
+		ch <- prometheus.MustNewConstMetric(u.stale, prometheus.GaugeValue, stale, name)
+		ch <- prometheus.MustNewConstMetric(u.failures, prometheus.CounterValue, float64(staple.failures), name)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestOCSPUpdate(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	var status int
+	var nextUpdate time.Duration
+	var ca, cert1 *ssl_helper.CertMock
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		w.Write(ssl_helper.CreateOCSPResponse(cert1, ca, ssl_helper.OCSPResponseMock{
+			Status:     status,
+			ThisUpdate: now.Add(-time.Minute),
+			NextUpdate: now.Add(nextUpdate),
+		}))
+	}))
+	defer responder.Close()
+
+	ca = ssl_helper.CreateCA("ca")
+	cert1 = ssl_helper.CreateCert("d1.local", responder.URL, ca)
+	cert2 := ssl_helper.CreateCert("d2.local", "", ca)
+	file1 := tempdir + "/default_d1.pem"
+	file2 := tempdir + "/default_d2.pem"
+	ioutil.WriteFile(file1, bytes.Join([][]byte{cert1.PEM(), cert1.KeyPEM(), ca.PEM()}, nil), 0644)
+	ioutil.WriteFile(file2, bytes.Join([][]byte{cert2.PEM(), cert2.KeyPEM()}, nil), 0644)
+
+	socket := tempdir + "/admin.sock"
+	commands := fakeAdminSocket(t, socket, "OCSP Response updated!\n")
+
+	logger := &types_helper.LoggerMock{T: t}
+	u := NewOCSPUpdater(logger, OCSPOptions{
+		Socket:      socket,
+		CheckPeriod: time.Hour,
+	}).(*ocspUpdater)
+
+	// first update, d2 doesn't have a responder
+	status = 0
+	nextUpdate = 4 * time.Hour
+	u.Notify([]string{file1, file2})
+	u.updateAll()
+	der, err := ioutil.ReadFile(file1 + ".ocsp")
+	if err != nil {
+		t.Errorf("error reading ocsp file: %v", err)
+	}
+	if cmd := <-commands; !strings.HasPrefix(cmd, "set ssl ocsp-response ") {
+		t.Errorf("unexpected command: %s", cmd)
+	}
+	if _, err := os.Stat(file2 + ".ocsp"); err == nil {
+		t.Errorf("unexpected ocsp file of d2")
+	}
+	staple := u.staples[file1]
+	if refresh := time.Until(staple.refresh); refresh < time.Hour || refresh > 2*time.Hour {
+		t.Errorf("unexpected refresh time: %v", staple.refresh)
+	}
+	logger.CompareLogging(`
+INFO-V(2) ocsp: response of '` + file1 + `' updated, next refresh at ` + staple.refresh.Format(time.RFC3339) + `
+INFO-V(2) ocsp: skipping '` + file2 + `': certificate does not have an OCSP responder`)
+	checkOCSPMetrics(t, u, `
+default_d1 stale=0 failures=0`)
+
+	// nothing to refresh
+	u.updateAll()
+	logger.CompareLogging(``)
+
+	// refresh fails and keeps the current response
+	staple.refresh = time.Now()
+	status = -1
+	u.updateAll()
+	logger.CompareLogging(`
+WARN ocsp: error updating response of '` + file1 + `': OCSP responder returned HTTP status 500`)
+	checkOCSPMetrics(t, u, `
+default_d1 stale=0 failures=1`)
+	if current, _ := ioutil.ReadFile(file1 + ".ocsp"); !bytes.Equal(current, der) {
+		t.Errorf("ocsp file should not be changed")
+	}
+
+	// revoked
+	staple.refresh = time.Now()
+	status = 1
+	u.updateAll()
+	<-commands
+	if len(logger.Logging) != 2 || !strings.HasPrefix(logger.Logging[0], "WARN ocsp: certificate '"+file1+"' was revoked at ") {
+		t.Errorf("unexpected logging: %v", logger.Logging)
+	}
+	logger.CompareLogging(logger.Logging[0] + "\n" + logger.Logging[1])
+
+	// stale response and removed certificate
+	staple.response.NextUpdate = time.Now().Add(-time.Minute)
+	checkOCSPMetrics(t, u, `
+default_d1 stale=1 failures=1`)
+	u.Notify([]string{file2})
+	checkOCSPMetrics(t, u, ``)
+}
+
+func fakeAdminSocket(t *testing.T, socket, response string) <-chan string {
+	return fakeAdminSocketFunc(t, socket, func(string) string { return response })
+}
+
+func fakeAdminSocketFunc(t *testing.T, socket string, response func(cmd string) string) <-chan string {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("error listening admin socket: %v", err)
+	}
+	commands := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			cmd, _ := bufio.NewReader(conn).ReadString('\n')
+			cmd = strings.TrimSpace(cmd)
+			commands <- cmd
+			conn.Write([]byte(response(cmd)))
+			conn.Close()
+		}
+	}()
+	return commands
+}
+
+func checkOCSPMetrics(t *testing.T, collector prometheus.Collector, expected string) {
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+	values := map[string][]string{}
+	var certs []string
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		cert := m.Label[0].GetValue()
+		if _, found := values[cert]; !found {
+			certs = append(certs, cert)
+		}
+		if m.Gauge != nil {
+			values[cert] = append(values[cert], "stale="+strconv.FormatFloat(m.Gauge.GetValue(), 'f', -1, 64))
+		} else {
+			values[cert] = append(values[cert], "failures="+strconv.FormatFloat(m.Counter.GetValue(), 'f', -1, 64))
+		}
+	}
+	var actual []string
+	for _, cert := range certs {
+		actual = append(actual, cert+" "+strings.Join(values[cert], " "))
+	}
+	if strings.Join(actual, "\n") != strings.Trim(expected, "\n") {
+		t.Errorf("expected metrics:\n%s\nbut was:\n%s", strings.Trim(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
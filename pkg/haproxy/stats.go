@@ -0,0 +1,369 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// StatsCollector exports the statistics of the running HAProxy, read from
+// `show info` and `show stat` of the stats socket. Backends and servers
+// are labeled with the ingress, namespace and service of the configuration.
+type StatsCollector interface {
+	prometheus.Collector
+	Notify(config Config)
+	Start(stopCh <-chan struct{})
+}
+
+// StatsOptions ...
+type StatsOptions struct {
+	// CollectPeriod is the interval between reads of the stats socket
+	CollectPeriod time.Duration
+}
+
+// NewStatsCollector creates the collector of the HAProxy statistics. The
+// stats are read on every CollectPeriod, and the metrics of the last read
+// are reported on scrapes.
+func NewStatsCollector(logger types.Logger, options StatsOptions) StatsCollector {
+	c := &statsCollector{
+		logger:  logger,
+		options: options,
+		up: prometheus.NewDesc(
+			"ingress_controller_haproxy_up",
+			"Whether the last read of the HAProxy stats succeeded",
+			nil,
+			nil,
+		),
+		openTunnels: prometheus.NewDesc(
+			"ingress_controller_backend_open_tunnels",
+			"Number of open tunnels, e.g. WebSockets, of a backend",
+			[]string{"backend"},
+			nil,
+		),
+		info: map[string]*prometheus.Desc{},
+		stat: map[string]map[string]*prometheus.Desc{},
+	}
+	for _, m := range infoMetrics {
+		c.info[m.field] = prometheus.NewDesc("ingress_controller_haproxy_"+m.name, m.help, nil, nil)
+	}
+	for kind, labels := range statLabels {
+		c.stat[kind] = map[string]*prometheus.Desc{}
+		for _, m := range statMetrics {
+			if m.kinds[kind] {
+				c.stat[kind][m.field] = prometheus.NewDesc("ingress_controller_haproxy_"+kind+"_"+m.name, m.help, labels, nil)
+			}
+		}
+		c.stat[kind][statResponses] = prometheus.NewDesc(
+			"ingress_controller_haproxy_"+kind+"_http_responses_total",
+			"Number of HTTP responses by status code class",
+			append(labels, "code"),
+			nil,
+		)
+	}
+	c.stat["server"][statCheckStatus] = prometheus.NewDesc(
+		"ingress_controller_haproxy_server_check_status",
+		"Status of the last health check of the server, 1 on the current status",
+		append(statLabels["server"], "check"),
+		nil,
+	)
+	return c
+}
+
+type statsCollector struct {
+	logger      types.Logger
+	options     StatsOptions
+	mutex       sync.Mutex
+	socket      string
+	backends    map[string]*statsBackend
+	metrics     []prometheus.Metric
+	up          *prometheus.Desc
+	openTunnels *prometheus.Desc
+	info        map[string]*prometheus.Desc
+	stat        map[string]map[string]*prometheus.Desc
+}
+
+// statsBackend has the labels of a backend of the configuration,
+// tunnelOf is the ID of the regular backend of a tunnel backend
+type statsBackend struct {
+	ingress   string
+	namespace string
+	service   string
+	tunnelOf  string
+}
+
+type statsMetric struct {
+	field     string
+	name      string
+	help      string
+	valueType prometheus.ValueType
+	kinds     map[string]bool
+}
+
+const (
+	statResponses   = "hrsp_"
+	statCheckStatus = "check_status"
+)
+
+var statLabels = map[string][]string{
+	"frontend": {"frontend"},
+	"backend":  {"backend", "ingress", "namespace", "service"},
+	"server":   {"backend", "server", "ingress", "namespace", "service"},
+}
+
+var (
+	allKinds     = map[string]bool{"frontend": true, "backend": true, "server": true}
+	backendKinds = map[string]bool{"backend": true, "server": true}
+)
+
+// infoMetrics are the fields of `show info`, see the management guide
+var infoMetrics = []statsMetric{
+	{field: "CurrConns", name: "current_connections", help: "Number of active connections", valueType: prometheus.GaugeValue},
+	{field: "CumConns", name: "connections_total", help: "Number of accepted connections", valueType: prometheus.CounterValue},
+	{field: "ConnRate", name: "connection_rate", help: "Number of connections per second over the last second", valueType: prometheus.GaugeValue},
+	{field: "CumReq", name: "requests_total", help: "Number of processed requests", valueType: prometheus.CounterValue},
+	{field: "Uptime_sec", name: "uptime_seconds", help: "Uptime of the current workers", valueType: prometheus.GaugeValue},
+}
+
+// statMetrics are the fields of `show stat`, see the management guide
+var statMetrics = []statsMetric{
+	{field: "scur", name: "current_sessions", help: "Number of current sessions", valueType: prometheus.GaugeValue, kinds: allKinds},
+	{field: "stot", name: "sessions_total", help: "Number of sessions", valueType: prometheus.CounterValue, kinds: allKinds},
+	{field: "rate", name: "session_rate", help: "Number of sessions per second over the last second", valueType: prometheus.GaugeValue, kinds: allKinds},
+	{field: "qcur", name: "current_queue", help: "Number of requests waiting in the queue", valueType: prometheus.GaugeValue, kinds: backendKinds},
+	{field: "req_rate", name: "http_request_rate", help: "Number of HTTP requests per second over the last second", valueType: prometheus.GaugeValue, kinds: map[string]bool{"frontend": true}},
+	{field: "req_tot", name: "http_requests_total", help: "Number of HTTP requests", valueType: prometheus.CounterValue, kinds: allKinds},
+	{field: "bin", name: "bytes_in_total", help: "Number of bytes received", valueType: prometheus.CounterValue, kinds: allKinds},
+	{field: "bout", name: "bytes_out_total", help: "Number of bytes sent", valueType: prometheus.CounterValue, kinds: allKinds},
+	{field: "status", name: "up", help: "Whether the proxy or the server is up", valueType: prometheus.GaugeValue, kinds: allKinds},
+}
+
+var responseCodes = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "other"}
+
+func (c *statsCollector) Notify(config Config) {
+	backends := map[string]*statsBackend{}
+	for _, backend := range config.Backends() {
+		labels := &statsBackend{
+			ingress:   backend.Ingress,
+			namespace: backend.Namespace,
+			service:   backend.Name,
+		}
+		backends[backend.ID] = labels
+		if backend.Tunnel.Enabled {
+			tunnel := *labels
+			tunnel.tunnelOf = backend.ID
+			backends[backend.TunnelID()] = &tunnel
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.socket = config.Global().StatsSocket
+	c.backends = backends
+}
+
+func (c *statsCollector) Start(stopCh <-chan struct{}) {
+	wait.Until(c.update, c.options.CollectPeriod, stopCh)
+}
+
+// update reads the stats socket and builds the metrics
+// reported until the next update
+func (c *statsCollector) update() {
+	c.mutex.Lock()
+	socket := c.socket
+	backends := c.backends
+	c.mutex.Unlock()
+	if socket == "" {
+		// configuration wasn't notified yet
+		return
+	}
+	metrics, err := c.read(socket, backends)
+	up := 1.0
+	if err != nil {
+		c.logger.Warn("error reading HAProxy stats: %v", err)
+		metrics = nil
+		up = 0
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up))
+	c.mutex.Lock()
+	c.metrics = metrics
+	c.mutex.Unlock()
+}
+
+func (c *statsCollector) read(socket string, backends map[string]*statsBackend) ([]prometheus.Metric, error) {
+	out, err := utils.HAProxyCommand(socket, "show info")
+	if err != nil {
+		return nil, err
+	}
+	info := parseInfo(out)
+	var metrics []prometheus.Metric
+	for _, m := range infoMetrics {
+		if value, err := strconv.ParseFloat(info[m.field], 64); err == nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.info[m.field], m.valueType, value))
+		}
+	}
+	out, err = utils.HAProxyCommand(socket, "show stat")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parseStat(out)
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		metrics = append(metrics, c.statMetrics(stat, backends)...)
+	}
+	return metrics, nil
+}
+
+func (c *statsCollector) statMetrics(stat map[string]string, backends map[string]*statsBackend) []prometheus.Metric {
+	pxname, svname := stat["pxname"], stat["svname"]
+	var kind string
+	var labels []string
+	switch svname {
+	case "FRONTEND":
+		kind = "frontend"
+		labels = []string{pxname}
+	case "BACKEND":
+		kind = "backend"
+		labels = append([]string{pxname}, backendLabels(backends[pxname])...)
+	default:
+		kind = "server"
+		labels = append([]string{pxname, svname}, backendLabels(backends[pxname])...)
+	}
+	descs := c.stat[kind]
+	var metrics []prometheus.Metric
+	for _, m := range statMetrics {
+		desc, found := descs[m.field]
+		if !found || stat[m.field] == "" {
+			continue
+		}
+		var value float64
+		if m.field == "status" {
+			value = statusUp(stat[m.field])
+		} else {
+			var err error
+			if value, err = strconv.ParseFloat(stat[m.field], 64); err != nil {
+				continue
+			}
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(desc, m.valueType, value, labels...))
+	}
+	for _, code := range responseCodes {
+		if value, err := strconv.ParseFloat(stat[statResponses+code], 64); err == nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				descs[statResponses], prometheus.CounterValue, value, append(labels, code)...))
+		}
+	}
+	if check := strings.TrimPrefix(stat[statCheckStatus], "* "); check != "" && kind == "server" {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			descs[statCheckStatus], prometheus.GaugeValue, 1, append(labels, check)...))
+	}
+	if backend := backends[pxname]; backend != nil && backend.tunnelOf != "" && kind == "backend" {
+		// the current sessions of a tunnel backend are its open tunnels
+		if value, err := strconv.ParseFloat(stat["scur"], 64); err == nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				c.openTunnels, prometheus.GaugeValue, value, backend.tunnelOf))
+		}
+	}
+	return metrics
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up
+	ch <- c.openTunnels
+	for _, desc := range c.info {
+		ch <- desc
+	}
+	for _, descs := range c.stat {
+		for _, desc := range descs {
+			ch <- desc
+		}
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	metrics := c.metrics
+	c.mutex.Unlock()
+	for _, metric := range metrics {
+		ch <- metric
+	}
+}
+
+func backendLabels(backend *statsBackend) []string {
+	if backend == nil {
+		// proxies created by the template, e.g. error pages
+		return []string{"", "", ""}
+	}
+	return []string{backend.ingress, backend.namespace, backend.service}
+}
+
+// statusUp converts the status of `show stat` to 1 if the proxy or server
+// is accepting traffic, e.g. `UP`, `UP 1/3`, `OPEN` or `no check`
+func statusUp(status string) float64 {
+	if strings.HasPrefix(status, "UP") || status == "OPEN" || status == "no check" {
+		return 1
+	}
+	return 0
+}
+
+// parseInfo reads the `name: value` lines of `show info`
+func parseInfo(out string) map[string]string {
+	info := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if sep := strings.Index(line, ":"); sep > 0 {
+			info[line[:sep]] = strings.TrimSpace(line[sep+1:])
+		}
+	}
+	return info
+}
+
+// parseStat reads the csv output of `show stat`, every record is
+// a map from the field name, found in the header, to its value
+func parseStat(out string) ([]map[string]string, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(out, "# ")))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing stats header")
+	}
+	header := records[0]
+	stats := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		stat := make(map[string]string, len(header))
+		for i, field := range header {
+			if i < len(record) {
+				stat[field] = record[i]
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	hatypes "github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy/types"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+const showInfo = `Name: HAProxy
+Version: 2.0.1
+Uptime_sec: 120
+CurrConns: 5
+CumConns: 300
+ConnRate: 2
+CumReq: 900
+`
+
+const showStat = `# pxname,svname,qcur,scur,stot,bin,bout,status,check_status,rate,req_rate,req_tot,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,
+_front_http,FRONTEND,,3,100,1000,2000,OPEN,,1,2,150,0,140,5,4,1,0,
+default_echo_8080,172.17.0.11:8080,0,2,40,500,900,UP,* L7OK,1,,,0,38,1,1,0,0,
+default_echo_8080,172.17.0.12:8080,1,0,20,200,400,DOWN,L4CON,0,,,,,,,,,
+default_echo_8080,BACKEND,1,2,60,700,1300,UP,,1,,60,0,38,1,1,0,0,
+default_echo_8080_tunnel,BACKEND,0,4,4,10,20,UP,,0,,,,,,,,,
+_error404,BACKEND,0,0,0,0,0,UP,,0,,5,,,,5,,,
+`
+
+func TestStatsCollector(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	socket := tempdir + "/admin.sock"
+	commands := fakeAdminSocketFunc(t, socket, func(cmd string) string {
+		switch cmd {
+		case "show info":
+			return showInfo
+		case "show stat":
+			return showStat
+		}
+		return ""
+	})
+	config := createConfig(options{})
+	config.Global().StatsSocket = socket
+	b := config.AcquireBackend("default", "echo", 8080)
+	b.Ingress = "echo-ing"
+	b.Tunnel.Enabled = true
+	b.Endpoints = []*hatypes.Endpoint{endpointS1}
+
+	logger := &types_helper.LoggerMock{T: t}
+	collector := NewStatsCollector(logger, StatsOptions{}).(*statsCollector)
+	// not notified yet
+	collector.update()
+	if metrics := collectStats(collector); len(metrics) > 0 {
+		t.Errorf("expected no metrics but was %v", metrics)
+	}
+
+	collector.Notify(config)
+	collector.update()
+	if cmds := []string{<-commands, <-commands}; strings.Join(cmds, ",") != "show info,show stat" {
+		t.Errorf("unexpected commands: %v", cmds)
+	}
+	metrics := collectStats(collector)
+	for _, expected := range []string{
+		`ingress_controller_haproxy_up{} 1`,
+		`ingress_controller_haproxy_current_connections{} 5`,
+		`ingress_controller_haproxy_requests_total{} 900`,
+		`ingress_controller_haproxy_frontend_current_sessions{frontend="_front_http"} 3`,
+		`ingress_controller_haproxy_frontend_http_request_rate{frontend="_front_http"} 2`,
+		`ingress_controller_haproxy_frontend_http_responses_total{code="2xx",frontend="_front_http"} 140`,
+		`ingress_controller_haproxy_frontend_up{frontend="_front_http"} 1`,
+		`ingress_controller_haproxy_backend_current_queue{backend="default_echo_8080",ingress="echo-ing",namespace="default",service="echo"} 1`,
+		`ingress_controller_haproxy_backend_http_requests_total{backend="default_echo_8080",ingress="echo-ing",namespace="default",service="echo"} 60`,
+		`ingress_controller_haproxy_backend_current_sessions{backend="default_echo_8080_tunnel",ingress="echo-ing",namespace="default",service="echo"} 4`,
+		`ingress_controller_backend_open_tunnels{backend="default_echo_8080"} 4`,
+		`ingress_controller_haproxy_backend_http_responses_total{backend="_error404",code="4xx",ingress="",namespace="",service=""} 5`,
+		`ingress_controller_haproxy_server_up{backend="default_echo_8080",ingress="echo-ing",namespace="default",server="172.17.0.11:8080",service="echo"} 1`,
+		`ingress_controller_haproxy_server_up{backend="default_echo_8080",ingress="echo-ing",namespace="default",server="172.17.0.12:8080",service="echo"} 0`,
+		`ingress_controller_haproxy_server_check_status{backend="default_echo_8080",check="L7OK",ingress="echo-ing",namespace="default",server="172.17.0.11:8080",service="echo"} 1`,
+		`ingress_controller_haproxy_server_check_status{backend="default_echo_8080",check="L4CON",ingress="echo-ing",namespace="default",server="172.17.0.12:8080",service="echo"} 1`,
+		`ingress_controller_haproxy_server_http_responses_total{backend="default_echo_8080",code="5xx",ingress="echo-ing",namespace="default",server="172.17.0.11:8080",service="echo"} 0`,
+	} {
+		if !metrics[expected] {
+			t.Errorf("expected metric not found: %s", expected)
+		}
+	}
+	for metric := range metrics {
+		if strings.HasPrefix(metric, "ingress_controller_haproxy_frontend_current_queue") ||
+			strings.HasPrefix(metric, "ingress_controller_backend_open_tunnels") && !strings.Contains(metric, `backend="default_echo_8080"`) ||
+			strings.Contains(metric, `server="172.17.0.12:8080"`) && strings.Contains(metric, "http_responses_total") {
+			t.Errorf("unexpected metric: %s", metric)
+		}
+	}
+
+	// stats socket is down
+	config.Global().StatsSocket = tempdir + "/missing.sock"
+	collector.Notify(config)
+	collector.update()
+	metrics = collectStats(collector)
+	if expected := `ingress_controller_haproxy_up{} 0`; len(metrics) != 1 || !metrics[expected] {
+		t.Errorf("expected only '%s' but was %v", expected, metrics)
+	}
+	logger.CompareLogging("WARN error reading HAProxy stats: dial unix " + tempdir + "/missing.sock: connect: no such file or directory")
+}
+
+var fqNameRegex = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// collectStats returns the metrics in the `name{labels} value` format
+func collectStats(collector prometheus.Collector) map[string]bool {
+	ch := make(chan prometheus.Metric, 100)
+	collector.Collect(ch)
+	close(ch)
+	metrics := map[string]bool{}
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		labels := make([]string, len(m.Label))
+		for i, label := range m.Label {
+			labels[i] = fmt.Sprintf("%s=%q", label.GetName(), label.GetValue())
+		}
+		sort.Strings(labels)
+		value := m.GetGauge().GetValue()
+		if m.Counter != nil {
+			value = m.Counter.GetValue()
+		}
+		name := fqNameRegex.FindStringSubmatch(metric.Desc().String())[1]
+		metrics[fmt.Sprintf("%s{%s} %v", name, strings.Join(labels, ","), value)] = true
+	}
+	return metrics
+}
@@ -0,0 +1,375 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/utils"
+)
+
+// Supervisor manages HAProxy running in master-worker mode
+type Supervisor interface {
+	prometheus.Collector
+	// Reload starts HAProxy if it's not running, otherwise reloads it.
+	// Returns the output of HAProxy if the new workers didn't start.
+	Reload() error
+	// Stop soft stops the workers and the master process
+	Stop()
+}
+
+// SupervisorOptions ...
+type SupervisorOptions struct {
+	HAProxyCmd string
+	ConfigFile string
+	// MasterSocket is the master CLI, used to reload HAProxy and list the workers
+	MasterSocket string
+	// AdminSocket is used to save the state of the servers to StateFile before
+	// reloading, used by load-server-state. An empty StateFile skips the state.
+	AdminSocket string
+	StateFile   string
+	// ReloadTimeout is the time to wait the new workers, defaults to 30s
+	ReloadTimeout time.Duration
+}
+
+// NewSupervisor creates the supervisor of the HAProxy master process. HAProxy
+// is started on the first reload, its output is logged and the workers are
+// tracked via `show proc` of the master CLI.
+func NewSupervisor(logger types.Logger, options SupervisorOptions) Supervisor {
+	if options.ReloadTimeout == 0 {
+		options.ReloadTimeout = 30 * time.Second
+	}
+	return &supervisor{
+		logger:  logger,
+		options: options,
+		output:  &processOutput{logger: logger, maxLines: 100},
+		oldWorkersDesc: prometheus.NewDesc(
+			"ingress_controller_haproxy_old_workers",
+			"Number of old HAProxy workers still draining connections after a reload",
+			nil,
+			nil,
+		),
+		reloadFailuresDesc: prometheus.NewDesc(
+			"ingress_controller_haproxy_reload_failures_total",
+			"Number of HAProxy reloads whose new workers didn't start",
+			nil,
+			nil,
+		),
+	}
+}
+
+type supervisor struct {
+	logger             types.Logger
+	options            SupervisorOptions
+	output             *processOutput
+	mutex              sync.Mutex
+	cmd                *exec.Cmd
+	exited             chan struct{}
+	workers            []int
+	reloadFailures     int
+	oldWorkersDesc     *prometheus.Desc
+	reloadFailuresDesc *prometheus.Desc
+}
+
+// procs is the parsed output of `show proc` of the master CLI
+type procs struct {
+	reloads    int
+	workers    []int
+	oldWorkers []int
+}
+
+func (s *supervisor) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.running() {
+		if s.cmd != nil {
+			s.logger.Warn("HAProxy master process is not running, starting a new one")
+		}
+		return s.start()
+	}
+	return s.reload()
+}
+
+func (s *supervisor) running() bool {
+	if s.exited == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *supervisor) start() error {
+	version, err := HAProxyVersion(s.options.HAProxyCmd)
+	if err != nil {
+		return err
+	}
+	if !VersionAtLeast(version, MasterCLIVersion) {
+		return fmt.Errorf("master-worker mode needs HAProxy %s or newer, found %s", MasterCLIVersion, version)
+	}
+	cmd := exec.Command(s.options.HAProxyCmd, "-W", "-S", s.options.MasterSocket, "-f", s.options.ConfigFile)
+	cmd.Stdout = s.output
+	cmd.Stderr = s.output
+	mark := s.output.mark()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		s.logger.Warn("HAProxy master process %d exited: %v", cmd.Process.Pid, err)
+		close(exited)
+	}()
+	s.cmd = cmd
+	s.exited = exited
+	started, err := s.waitProcs(func(p *procs) bool { return len(p.workers) > 0 })
+	if err != nil {
+		s.reloadFailures++
+		return fmt.Errorf("error starting HAProxy: %v\n%s", err, s.output.since(mark))
+	}
+	s.workers = started.workers
+	s.logger.InfoV(2, "HAProxy master process %d started, workers: %v", cmd.Process.Pid, started.workers)
+	return nil
+}
+
+func (s *supervisor) reload() error {
+	before, err := s.showProc()
+	if err != nil {
+		return err
+	}
+	s.saveServerState()
+	mark := s.output.mark()
+	// the master closes the connection while re-executing itself,
+	// the outcome is read from the workers listed by `show proc`
+	utils.HAProxyCommand(s.options.MasterSocket, "reload")
+	after, err := s.waitProcs(func(p *procs) bool { return p.reloads > before.reloads })
+	if err == nil && !hasNewWorker(before, after) {
+		err = fmt.Errorf("new workers were not started")
+	}
+	if err != nil {
+		s.reloadFailures++
+		return fmt.Errorf("error reloading HAProxy: %v\n%s", err, s.output.since(mark))
+	}
+	s.workers = after.workers
+	s.logger.InfoV(2, "HAProxy workers: %v, old workers: %v", after.workers, after.oldWorkers)
+	return nil
+}
+
+// waitProcs reads `show proc` until done returns true, the master
+// process exits or the reload timeout expires
+func (s *supervisor) waitProcs(done func(p *procs) bool) (*procs, error) {
+	timeout := time.After(s.options.ReloadTimeout)
+	for {
+		p, err := s.showProc()
+		if err == nil && done(p) {
+			return p, nil
+		}
+		select {
+		case <-s.exited:
+			return nil, fmt.Errorf("master process exited")
+		case <-timeout:
+			if err == nil {
+				err = fmt.Errorf("timeout waiting workers")
+			}
+			return nil, err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (s *supervisor) showProc() (*procs, error) {
+	out, err := utils.HAProxyCommand(s.options.MasterSocket, "show proc")
+	if err != nil {
+		return nil, err
+	}
+	return parseShowProc(out)
+}
+
+// saveServerState writes the state of the servers of the running
+// workers, read by the new ones if load-server-state is enabled
+func (s *supervisor) saveServerState() {
+	if s.options.StateFile == "" {
+		return
+	}
+	state := "#\n"
+	if out, err := utils.HAProxyCommand(s.options.AdminSocket, "show servers state"); err == nil {
+		state = out
+	}
+	if err := os.MkdirAll(filepath.Dir(s.options.StateFile), 0755); err != nil {
+		s.logger.Warn("error creating server state dir: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(s.options.StateFile, []byte(state), 0644); err != nil {
+		s.logger.Warn("error writing server state: %v", err)
+	}
+}
+
+func (s *supervisor) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.running() {
+		return
+	}
+	// SIGUSR1 soft stops the master and the workers
+	if err := s.cmd.Process.Signal(syscall.SIGUSR1); err != nil {
+		s.logger.Warn("error stopping HAProxy: %v", err)
+		return
+	}
+	select {
+	case <-s.exited:
+	case <-time.After(s.options.ReloadTimeout):
+		s.logger.Warn("timeout waiting HAProxy to stop")
+	}
+}
+
+func (s *supervisor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.oldWorkersDesc
+	ch <- s.reloadFailuresDesc
+}
+
+func (s *supervisor) Collect(ch chan<- prometheus.Metric) {
+	s.mutex.Lock()
+	reloadFailures := s.reloadFailures
+	s.mutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(s.reloadFailuresDesc, prometheus.CounterValue, float64(reloadFailures))
+	p, err := s.showProc()
+	if err != nil {
+		// HAProxy wasn't started yet or is reloading
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(s.oldWorkersDesc, prometheus.GaugeValue, float64(len(p.oldWorkers)))
+}
+
+// parseShowProc reads the output of `show proc` of the master CLI:
+//
+//	#<PID>          <type>          <relative PID>  <reloads>       <uptime>        <version>
+//	1162            master          0               5               0d00h02m07s     2.0.1
+//	# workers
+//	1271            worker          1               0               0d00h00m00s     2.0.1
+//	# old workers
+//	1233            worker          [was: 1]        3               0d00h00m28s     2.0.1
+//	# programs
+func parseShowProc(out string) (*procs, error) {
+	p := &procs{reloads: -1}
+	var section string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			section = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid of process '%s'", line)
+		}
+		switch {
+		case fields[1] == "master" && len(fields) >= 4:
+			if p.reloads, err = strconv.Atoi(fields[3]); err != nil {
+				return nil, fmt.Errorf("invalid reloads of master process '%s'", line)
+			}
+		case section == "workers":
+			p.workers = append(p.workers, pid)
+		case section == "old workers":
+			p.oldWorkers = append(p.oldWorkers, pid)
+		}
+	}
+	if p.reloads < 0 {
+		return nil, fmt.Errorf("master process not found")
+	}
+	return p, nil
+}
+
+// hasNewWorker returns true if after has a worker which isn't a worker of before
+func hasNewWorker(before, after *procs) bool {
+	old := make(map[int]bool, len(before.workers))
+	for _, pid := range before.workers {
+		old[pid] = true
+	}
+	for _, pid := range after.workers {
+		if !old[pid] {
+			return true
+		}
+	}
+	return false
+}
+
+// processOutput logs the output of HAProxy and keeps its last lines,
+// used to report the output of a failed reload
+type processOutput struct {
+	logger   types.Logger
+	maxLines int
+	mutex    sync.Mutex
+	lines    []string
+	count    int
+	partial  string
+}
+
+func (o *processOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	lines := strings.Split(o.partial+string(p), "\n")
+	o.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		o.logger.Warn("haproxy: %s", line)
+		o.lines = append(o.lines, line)
+		o.count++
+	}
+	if len(o.lines) > o.maxLines {
+		o.lines = o.lines[len(o.lines)-o.maxLines:]
+	}
+	return len(p), nil
+}
+
+// mark returns the number of lines written so far, see since()
+func (o *processOutput) mark() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.count
+}
+
+// since returns the lines written after mark which are still kept
+func (o *processOutput) since(mark int) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	first := len(o.lines) - (o.count - mark)
+	if first < 0 {
+		first = 0
+	}
+	return strings.Join(o.lines[first:], "\n")
+}
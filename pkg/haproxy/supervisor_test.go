@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestParseShowProc(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected *procs
+		err      string
+	}{
+		// 0
+		{
+			out: `
+#<PID>          <type>          <relative PID>  <reloads>       <uptime>        <version>
+1162            master          0               5               0d00h02m07s     2.0.1
+# workers
+1271            worker          1               0               0d00h00m00s     2.0.1
+1272            worker          2               0               0d00h00m00s     2.0.1
+# old workers
+1233            worker          [was: 1]        3               0d00h00m28s     2.0.1
+# programs
+`,
+			expected: &procs{reloads: 5, workers: []int{1271, 1272}, oldWorkers: []int{1233}},
+		},
+		// 1
+		{
+			out: `
+#<PID>          <type>          <relative PID>  <reloads>       <uptime>        <version>
+1162            master          0               0               0d00h00m01s     2.0.1
+# workers
+# old workers
+`,
+			expected: &procs{reloads: 0},
+		},
+		// 2
+		{
+			out: "",
+			err: "master process not found",
+		},
+		// 3
+		{
+			out: "Unknown command.\n",
+			err: "invalid pid of process 'Unknown command.'",
+		},
+	}
+	for i, test := range testCases {
+		p, err := parseShowProc(test.out)
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		if errMsg != test.err {
+			t.Errorf("%d: expected error '%s' but was '%s'", i, test.err, errMsg)
+		}
+		if !reflect.DeepEqual(p, test.expected) {
+			t.Errorf("%d: expected %+v but was %+v", i, test.expected, p)
+		}
+	}
+}
+
+func TestProcessOutput(t *testing.T) {
+	logger := &types_helper.LoggerMock{T: t}
+	output := &processOutput{logger: logger, maxLines: 2}
+	output.Write([]byte("[WARNING] line 1\n[ALERT] li"))
+	mark := output.mark()
+	output.Write([]byte("ne 2\n"))
+	if since := output.since(mark); since != "[ALERT] line 2" {
+		t.Errorf("expected '[ALERT] line 2' but was '%s'", since)
+	}
+	output.Write([]byte("[ALERT] line 3\n[ALERT] line 4\n"))
+	if since := output.since(mark); since != "[ALERT] line 3\n[ALERT] line 4" {
+		t.Errorf("expected the kept lines but was '%s'", since)
+	}
+	logger.CompareLogging(`
+WARN haproxy: [WARNING] line 1
+WARN haproxy: [ALERT] line 2
+WARN haproxy: [ALERT] line 3
+WARN haproxy: [ALERT] line 4`)
+}
+
+func TestSupervisor(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	// the fake master process only waits, the master CLI is faked below
+	haproxyCmd := tempdir + "/haproxy"
+	script := "#!/bin/sh\n[ \"$1\" = -v ] && echo 'HA-Proxy version 2.0.10 2019/11/25' && exit\nexec sleep 30\n"
+	if err := ioutil.WriteFile(haproxyCmd, []byte(script), 0755); err != nil {
+		t.Fatalf("error writing haproxy command: %v", err)
+	}
+	logger := &types_helper.LoggerMock{T: t}
+	socket := tempdir + "/master.sock"
+	sup := NewSupervisor(logger, SupervisorOptions{
+		HAProxyCmd:    haproxyCmd,
+		ConfigFile:    tempdir + "/haproxy.cfg",
+		MasterSocket:  socket,
+		ReloadTimeout: 2 * time.Second,
+	}).(*supervisor)
+
+	var mutex sync.Mutex
+	var reloads int
+	var failReload bool
+	workers, oldWorkers, nextPid := []int{100}, []int{}, 101
+	commands := fakeAdminSocketFunc(t, socket, func(cmd string) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch cmd {
+		case "reload":
+			reloads++
+			if failReload {
+				sup.output.Write([]byte("[ALERT] config invalid\n"))
+			} else {
+				oldWorkers = append(oldWorkers, workers...)
+				workers = []int{nextPid}
+				nextPid++
+			}
+		case "show proc":
+			out := fmt.Sprintf("#<PID> <type> <relative PID> <reloads> <uptime> <version>\n1 master 0 %d 0d00h00m01s 2.0.1\n# workers\n", reloads)
+			for _, pid := range workers {
+				out += fmt.Sprintf("%d worker 1 0 0d00h00m01s 2.0.1\n", pid)
+			}
+			out += "# old workers\n"
+			for _, pid := range oldWorkers {
+				out += fmt.Sprintf("%d worker [was: 1] 0 0d00h00m01s 2.0.1\n", pid)
+			}
+			return out
+		}
+		return ""
+	})
+	go func() {
+		for range commands {
+		}
+	}()
+
+	// first reload starts the master process
+	if err := sup.Reload(); err != nil {
+		t.Fatalf("error starting: %v", err)
+	}
+	pid := sup.cmd.Process.Pid
+	logger.CompareLogging(fmt.Sprintf("INFO-V(2) HAProxy master process %d started, workers: [100]", pid))
+
+	if err := sup.Reload(); err != nil {
+		t.Errorf("error reloading: %v", err)
+	}
+	logger.CompareLogging("INFO-V(2) HAProxy workers: [101], old workers: [100]")
+
+	// new workers not started, report the output of HAProxy
+	mutex.Lock()
+	failReload = true
+	mutex.Unlock()
+	err = sup.Reload()
+	if expected := "error reloading HAProxy: new workers were not started\n[ALERT] config invalid"; err == nil || err.Error() != expected {
+		t.Errorf("expected error '%s' but was '%v'", expected, err)
+	}
+	logger.CompareLogging("WARN haproxy: [ALERT] config invalid")
+
+	ch := make(chan prometheus.Metric, 2)
+	sup.Collect(ch)
+	close(ch)
+	var metrics []string
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		if m.Counter != nil {
+			metrics = append(metrics, fmt.Sprintf("failures=%v", m.Counter.GetValue()))
+		} else {
+			metrics = append(metrics, fmt.Sprintf("old=%v", m.Gauge.GetValue()))
+		}
+	}
+	if expected := "failures=1,old=1"; strings.Join(metrics, ",") != expected {
+		t.Errorf("expected metrics '%s' but was '%s'", expected, strings.Join(metrics, ","))
+	}
+
+	sup.Stop()
+	if sup.running() {
+		t.Errorf("expected master process stopped")
+	}
+	logger.CompareLogging(fmt.Sprintf("WARN HAProxy master process %d exited: signal: user defined signal 1", pid))
+}
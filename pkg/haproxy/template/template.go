@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	gotemplate "text/template"
 )
 
@@ -31,7 +33,23 @@ func CreateConfig() *Config {
 
 // Config ...
 type Config struct {
-	templates []*template
+	templates  []*template
+	stagingDir string
+	staged     []*stagedFile
+	committed  []*committedFile
+}
+
+type stagedFile struct {
+	tmpl   *template
+	output string
+	staged string
+}
+
+// committedFile is an output replaced by Commit(), backup is a hard
+// link to the former content, or empty if the output didn't exist
+type committedFile struct {
+	output string
+	backup string
 }
 
 // ClearTemplates ...
@@ -68,13 +86,184 @@ func (c *Config) WriteOutput(data interface{}, output string) error {
 		}
 	}
 	for _, t := range c.templates {
-		if err := t.writeToDisk(output); err != nil {
+		var err error
+		if c.stagingDir != "" {
+			err = c.stage(t, output)
+		} else {
+			err = t.writeToDisk(output)
+		}
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// WriteFile writes content as is in output, or in the staging dir if Stage()
+// was called. Used for the files which aren't built from a template.
+func (c *Config) WriteFile(output string, content []byte, perm os.FileMode) error {
+	if c.stagingDir == "" {
+		if err := ioutil.WriteFile(output, content, perm); err != nil {
+			return fmt.Errorf("cannot write %s: %v", output, err)
+		}
+		return nil
+	}
+	staged, err := c.stageFile(output, content, perm)
+	if err != nil {
+		return err
+	}
+	c.addStaged(nil, output, staged)
+	return nil
+}
+
+// Stage makes the next writes save the files in dir, keeping their names,
+// instead of in their outputs. Commit() moves the staged files to their
+// outputs, Discard() forgets them. The backups of the last Commit() are
+// removed, it cannot be rolled back anymore.
+func (c *Config) Stage(dir string) {
+	for _, f := range c.committed {
+		if f.backup != "" {
+			os.Remove(f.backup)
+		}
+	}
+	c.committed = nil
+	c.stagingDir = dir
+	c.staged = nil
+}
+
+// StagedFiles returns the outputs written since Stage(), and their staged files
+func (c *Config) StagedFiles() map[string]string {
+	files := make(map[string]string, len(c.staged))
+	for _, f := range c.staged {
+		files[f.output] = f.staged
+	}
+	return files
+}
+
+// Commit moves the staged files to their outputs, in the order they were
+// written, rotating the outputs of the templates configured to do so.
+// Every file is replaced atomically. If a file cannot be moved, the outputs
+// already replaced are restored before returning the error, so either all
+// or none of the staged files are committed. The staging is finished in
+// both cases.
+func (c *Config) Commit() error {
+	staged := c.staged
+	c.Discard()
+	c.committed = nil
+	for _, f := range staged {
+		if err := c.commitFile(f); err != nil {
+			if rollbackErr := c.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("%v; %v", err, rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback restores the outputs replaced by the last Commit(), used if
+// the files of another Config could not be committed. The outputs are
+// restored in the reverse order they were committed.
+func (c *Config) Rollback() error {
+	committed := c.committed
+	c.committed = nil
+	var errs []string
+	for i := len(committed) - 1; i >= 0; i-- {
+		f := committed[i]
+		var err error
+		if f.backup != "" {
+			err = os.Rename(f.backup, f.output)
+		} else {
+			err = os.Remove(f.output)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot restore the outputs: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// commitFile moves a staged file to its output, keeping a hard link
+// to the former content in the staging dir, see Rollback()
+func (c *Config) commitFile(f *stagedFile) error {
+	backup := f.staged + ".orig"
+	os.Remove(backup)
+	if err := os.Link(f.output, backup); os.IsNotExist(err) {
+		backup = ""
+	} else if err != nil {
+		return fmt.Errorf("cannot backup %s: %v", f.output, err)
+	}
+	if f.tmpl != nil {
+		if err := f.tmpl.rotateOutput(f.output, true); err != nil {
+			if backup != "" {
+				os.Remove(backup)
+			}
+			return err
+		}
+	}
+	if err := os.Rename(f.staged, f.output); err != nil {
+		if backup != "" {
+			os.Remove(backup)
+		}
+		return fmt.Errorf("cannot move %s to %s: %v", f.staged, f.output, err)
+	}
+	c.committed = append(c.committed, &committedFile{
+		output: f.output,
+		backup: backup,
+	})
+	return nil
+}
+
+// Discard finishes the staging without changing the outputs. The staged
+// files are kept for troubleshooting, they are overwritten by the next
+// staging in the same dir.
+func (c *Config) Discard() {
+	c.stagingDir = ""
+	c.staged = nil
+}
+
+func (c *Config) stage(t *template, output string) error {
+	if output == "" {
+		output = t.output
+	}
+	if output == "" {
+		return fmt.Errorf("output file is empty, configure on NewTemplate() or use WriteOutput()")
+	}
+	staged, err := c.stageFile(output, t.rawConfig.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	c.addStaged(t, output, staged)
+	return nil
+}
+
+func (c *Config) stageFile(output string, content []byte, perm os.FileMode) (string, error) {
+	if err := os.MkdirAll(c.stagingDir, 0755); err != nil {
+		return "", err
+	}
+	staged := filepath.Join(c.stagingDir, filepath.Base(output))
+	if err := ioutil.WriteFile(staged, content, perm); err != nil {
+		return "", fmt.Errorf("cannot write %s: %v", staged, err)
+	}
+	return staged, nil
+}
+
+func (c *Config) addStaged(t *template, output, staged string) {
+	for _, f := range c.staged {
+		if f.output == output {
+			return
+		}
+	}
+	c.staged = append(c.staged, &stagedFile{
+		tmpl:   t,
+		output: output,
+		staged: staged,
+	})
+}
+
 type template struct {
 	tmpl        *gotemplate.Template
 	output      string
@@ -90,6 +279,18 @@ func (t *template) writeToDisk(output string) error {
 	if output == "" {
 		return fmt.Errorf("output file is empty, configure on NewTemplate() or use WriteOutput()")
 	}
+	if err := t.rotateOutput(output, false); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(output, t.rawConfig.Bytes(), 0644); err != nil {
+		return fmt.Errorf("cannot write %s: %v", output, err)
+	}
+	return nil
+}
+
+// rotateOutput renames the current output, or hard links it if the
+// output is going to be replaced instead of written
+func (t *template) rotateOutput(output string, link bool) error {
 	if t.rotate > 0 {
 		// Include timestamp in rotated config file names to aid troubleshooting.
 		// When using a single, ever-changing config file it was difficult
@@ -98,7 +299,11 @@ func (t *template) writeToDisk(output string) error {
 		// rename current config file, if exists
 		if f, err := os.Stat(output); f != nil {
 			rotateTo := output + "." + f.ModTime().Format("20060102-150405.000")
-			if err := os.Rename(output, rotateTo); err != nil {
+			rotate := os.Rename
+			if link {
+				rotate = os.Link
+			}
+			if err := rotate(output, rotateTo); err != nil {
 				return fmt.Errorf("cannot rotate %s: %v", output, err)
 			}
 			t.configFiles = append(t.configFiles, rotateTo)
@@ -114,8 +319,5 @@ func (t *template) writeToDisk(output string) error {
 			t.configFiles = t.configFiles[1:]
 		}
 	}
-	if err := ioutil.WriteFile(output, t.rawConfig.Bytes(), 0644); err != nil {
-		return fmt.Errorf("cannot write %s: %v", output, err)
-	}
 	return nil
 }
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -260,6 +261,176 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestStaging(t *testing.T) {
+	type data struct {
+		Name string
+	}
+	c := setup(t)
+	defer c.teardown()
+	c.newTemplate("{{ .Name }}", 1)
+	staging := c.tempdir + "/staging"
+	output := c.tempdir + "/h1.cfg"
+
+	if err := c.templateConfig.Write(data{Name: "joe1"}); err != nil {
+		t.Errorf("error writing: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// discarded, output is preserved
+	c.templateConfig.Stage(staging)
+	if err := c.templateConfig.Write(data{Name: "joe2"}); err != nil {
+		t.Errorf("error staging: %v", err)
+	}
+	if staged := c.templateConfig.StagedFiles()[output]; staged != staging+"/h1.cfg" {
+		t.Errorf("expected staged file '%s/h1.cfg' but was '%s'", staging, staged)
+	}
+	c.templateConfig.Discard()
+	if outputs := c.outputs(0); strings.Join(outputs, ",") != "joe1" {
+		t.Errorf("expected outputs 'joe1' but was '%s'", strings.Join(outputs, ","))
+	}
+
+	// committed, output is rotated
+	c.templateConfig.Stage(staging)
+	if err := c.templateConfig.Write(data{Name: "joe3"}); err != nil {
+		t.Errorf("error staging: %v", err)
+	}
+	if err := c.templateConfig.Commit(); err != nil {
+		t.Errorf("error committing: %v", err)
+	}
+	if outputs := c.outputs(0); strings.Join(outputs, ",") != "joe1,joe3" {
+		t.Errorf("expected outputs 'joe1,joe3' but was '%s'", strings.Join(outputs, ","))
+	}
+	if len(c.templateConfig.StagedFiles()) > 0 {
+		t.Errorf("expected staging finished after commit")
+	}
+
+	// not staging anymore
+	if err := c.templateConfig.Write(data{Name: "joe4"}); err != nil {
+		t.Errorf("error writing: %v", err)
+	}
+	if outputs := c.outputs(0); strings.Join(outputs, ",") != "joe3,joe4" {
+		t.Errorf("expected outputs 'joe3,joe4' but was '%s'", strings.Join(outputs, ","))
+	}
+	if _, err := os.Stat(staging + "/h1.cfg"); !os.IsNotExist(err) {
+		t.Errorf("expected committed file moved from the staging dir")
+	}
+}
+
+func TestStagingWriteFile(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+	staging := c.tempdir + "/staging"
+	output := c.tempdirOutput + "/keys"
+	readOutput := func() string {
+		content, _ := ioutil.ReadFile(output)
+		return string(content)
+	}
+
+	// not staging, written in the output
+	if err := c.templateConfig.WriteFile(output, []byte("k1"), 0600); err != nil {
+		t.Errorf("error writing: %v", err)
+	}
+	if content := readOutput(); content != "k1" {
+		t.Errorf("expected output 'k1' but was '%s'", content)
+	}
+
+	// discarded, output is preserved
+	c.templateConfig.Stage(staging)
+	if err := c.templateConfig.WriteFile(output, []byte("k2"), 0600); err != nil {
+		t.Errorf("error staging: %v", err)
+	}
+	if staged := c.templateConfig.StagedFiles()[output]; staged != staging+"/keys" {
+		t.Errorf("expected staged file '%s/keys' but was '%s'", staging, staged)
+	}
+	c.templateConfig.Discard()
+	if content := readOutput(); content != "k1" {
+		t.Errorf("expected output 'k1' but was '%s'", content)
+	}
+
+	// committed
+	c.templateConfig.Stage(staging)
+	if err := c.templateConfig.WriteFile(output, []byte("k3"), 0600); err != nil {
+		t.Errorf("error staging: %v", err)
+	}
+	if err := c.templateConfig.Commit(); err != nil {
+		t.Errorf("error committing: %v", err)
+	}
+	if content := readOutput(); content != "k3" {
+		t.Errorf("expected output 'k3' but was '%s'", content)
+	}
+	if info, err := os.Stat(output); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected output with mode 0600: %v %v", info, err)
+	}
+}
+
+func TestStagingRollback(t *testing.T) {
+	c := setup(t)
+	defer c.teardown()
+	staging := c.tempdir + "/staging"
+	output1 := c.tempdirOutput + "/keys"
+	output2 := c.tempdirOutput + "/certs"
+	missing := c.tempdirOutput + "/missing/list"
+	readOutput := func(output string) string {
+		content, err := ioutil.ReadFile(output)
+		if os.IsNotExist(err) {
+			return "<missing>"
+		}
+		return string(content)
+	}
+	stage := func(content string, outputs ...string) {
+		c.templateConfig.Stage(staging)
+		for _, output := range outputs {
+			if err := c.templateConfig.WriteFile(output, []byte(content), 0600); err != nil {
+				t.Errorf("error staging: %v", err)
+			}
+		}
+	}
+	if err := c.templateConfig.WriteFile(output1, []byte("k1"), 0600); err != nil {
+		t.Errorf("error writing: %v", err)
+	}
+
+	// the last file cannot be moved, the former ones are restored
+	stage("k2", output1, output2, missing)
+	if err := c.templateConfig.Commit(); err == nil {
+		t.Errorf("expected error committing")
+	}
+	if content := readOutput(output1); content != "k1" {
+		t.Errorf("expected output1 'k1' but was '%s'", content)
+	}
+	if content := readOutput(output2); content != "<missing>" {
+		t.Errorf("expected output2 missing but was '%s'", content)
+	}
+
+	// committed, and rolled back by the caller
+	stage("k3", output1, output2)
+	if err := c.templateConfig.Commit(); err != nil {
+		t.Errorf("error committing: %v", err)
+	}
+	if content := readOutput(output1) + "," + readOutput(output2); content != "k3,k3" {
+		t.Errorf("expected outputs 'k3,k3' but was '%s'", content)
+	}
+	if err := c.templateConfig.Rollback(); err != nil {
+		t.Errorf("error rolling back: %v", err)
+	}
+	if content := readOutput(output1) + "," + readOutput(output2); content != "k1,<missing>" {
+		t.Errorf("expected outputs 'k1,<missing>' but was '%s'", content)
+	}
+
+	// backups are removed by the next staging
+	stage("k4", output1)
+	if err := c.templateConfig.Commit(); err != nil {
+		t.Errorf("error committing: %v", err)
+	}
+	stage("k5", output1)
+	if _, err := os.Stat(staging + "/keys.orig"); !os.IsNotExist(err) {
+		t.Errorf("expected backup removed by the next staging")
+	}
+	c.templateConfig.Discard()
+	if content := readOutput(output1); content != "k4" {
+		t.Errorf("expected output1 'k4' but was '%s'", content)
+	}
+}
+
 func (c *testConfig) newTemplate(content string, rotate int) {
 	cnt := len(c.templateConfig.templates) + 1
 	templateFileName := fmt.Sprintf("h%d.tmpl", cnt)
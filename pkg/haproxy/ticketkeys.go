@@ -0,0 +1,160 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// TicketKeysCache ...
+type TicketKeysCache interface {
+	// UpdateSecret reads the secret from the API server and calls update
+	// with its current data, an empty map if the secret doesn't exist.
+	// update changes data in place and returns true if the secret should
+	// be written. The secret is read and update is called again on conflicts.
+	UpdateSecret(secretName, secretType string, update func(data map[string][]byte) bool) error
+}
+
+// TicketKeysRotator ...
+type TicketKeysRotator interface {
+	Notify(secretName string)
+	Start(stopCh <-chan struct{})
+}
+
+// TicketKeysOptions ...
+type TicketKeysOptions struct {
+	Cache TicketKeysCache
+	// RotationPeriod is the time between rotations of the keys
+	RotationPeriod time.Duration
+	// CheckPeriod is the interval between checks of keys which should be rotated
+	CheckPeriod time.Duration
+}
+
+// ticketKeysRotatedKey is the secret key with the time of the last rotation,
+// so controller restarts and other replicas share the same schedule
+const ticketKeysRotatedKey = "tls-ticket-keys-rotated"
+
+// NewTicketKeysRotator creates the TLS session ticket keys worker. A new key
+// is added to the secret on every RotationPeriod and the oldest one is
+// removed. The secret is created if it doesn't exist or doesn't have valid
+// keys. The rotation is computed from the secret read from the API server,
+// so replicas sharing the secret don't rotate the same keys twice. The new
+// secret content is read by the converter, and the new keys are sent to the
+// running instance via `set ssl tls-key`.
+func NewTicketKeysRotator(logger types.Logger, options TicketKeysOptions) TicketKeysRotator {
+	return &ticketKeysRotator{
+		logger:  logger,
+		options: options,
+		trigger: make(chan struct{}, 1),
+		now:     time.Now,
+	}
+}
+
+type ticketKeysRotator struct {
+	logger  types.Logger
+	options TicketKeysOptions
+	trigger chan struct{}
+	mutex   sync.Mutex
+	secret  string
+	now     func() time.Time
+}
+
+func (r *ticketKeysRotator) Notify(secretName string) {
+	r.mutex.Lock()
+	changed := r.secret != secretName
+	r.secret = secretName
+	r.mutex.Unlock()
+	if changed && secretName != "" {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *ticketKeysRotator) Start(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.options.CheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-r.trigger:
+		}
+		r.check()
+	}
+}
+
+func (r *ticketKeysRotator) check() {
+	r.mutex.Lock()
+	secretName := r.secret
+	r.mutex.Unlock()
+	if secretName == "" {
+		return
+	}
+	now := r.now()
+	var keys []string
+	var rotated bool
+	var invalidErr, rotateErr error
+	err := r.options.Cache.UpdateSecret(secretName, "Opaque", func(data map[string][]byte) bool {
+		// called again on conflicts, so starting from scratch
+		keys, rotated, invalidErr, rotateErr = nil, false, nil, nil
+		if content, found := data[ssl.TicketKeysSecretKey]; found {
+			keys, invalidErr = ssl.ParseTicketKeys(content)
+		}
+		if len(keys) > 0 {
+			last, err := time.Parse(time.RFC3339, string(data[ticketKeysRotatedKey]))
+			if err == nil && now.Sub(last) < r.options.RotationPeriod {
+				return false
+			}
+		}
+		var newKeys []string
+		newKeys, rotateErr = ssl.RotateTicketKeys(keys)
+		if rotateErr != nil {
+			return false
+		}
+		rotated = true
+		data[ssl.TicketKeysSecretKey] = []byte(strings.Join(newKeys, "\n") + "\n")
+		data[ticketKeysRotatedKey] = []byte(now.UTC().Format(time.RFC3339))
+		return true
+	})
+	if invalidErr != nil {
+		r.logger.Warn("replacing invalid TLS ticket keys of secret '%s': %v", secretName, invalidErr)
+	}
+	if rotateErr != nil {
+		r.logger.Error("error creating TLS ticket key: %v", rotateErr)
+		return
+	}
+	if err != nil {
+		r.logger.Error("error updating TLS ticket keys of secret '%s': %v", secretName, err)
+		return
+	}
+	if !rotated {
+		return
+	}
+	if len(keys) == 0 {
+		r.logger.Info("TLS ticket keys of secret '%s' created", secretName)
+	} else {
+		r.logger.Info("TLS ticket keys of secret '%s' rotated", secretName)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+type ticketKeysCacheMock struct {
+	secrets map[string]map[string][]byte
+	// conflict, if assigned, changes the secret after it was read, so the
+	// update fails once and the secret is read again
+	conflict func()
+}
+
+func (c *ticketKeysCacheMock) UpdateSecret(secretName, secretType string, update func(data map[string][]byte) bool) error {
+	for {
+		data := map[string][]byte{}
+		for key, value := range c.secrets[secretName] {
+			data[key] = value
+		}
+		changed := update(data)
+		if c.conflict != nil {
+			c.conflict()
+			c.conflict = nil
+			continue
+		}
+		if changed {
+			c.secrets[secretName] = data
+		}
+		return nil
+	}
+}
+
+func TestTicketKeysRotate(t *testing.T) {
+	logger := &types_helper.LoggerMock{T: t}
+	cache := &ticketKeysCacheMock{secrets: map[string]map[string][]byte{}}
+	r := NewTicketKeysRotator(logger, TicketKeysOptions{
+		Cache:          cache,
+		RotationPeriod: time.Hour,
+		CheckPeriod:    time.Minute,
+	}).(*ticketKeysRotator)
+	now := time.Date(2019, 10, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	readKeys := func() []string {
+		keys, err := ssl.ParseTicketKeys(cache.secrets["ingress/tickets"][ssl.TicketKeysSecretKey])
+		if err != nil {
+			t.Errorf("error parsing ticket keys: %v", err)
+		}
+		return keys
+	}
+
+	// not configured
+	r.check()
+	if len(cache.secrets) > 0 {
+		t.Errorf("expected no secret created")
+	}
+
+	// missing secret
+	r.Notify("ingress/tickets")
+	r.check()
+	keys := readKeys()
+	if len(keys) != 3 {
+		t.Errorf("expected 3 keys but found %d", len(keys))
+	}
+	if rotated := string(cache.secrets["ingress/tickets"]["tls-ticket-keys-rotated"]); rotated != "2019-10-01T10:00:00Z" {
+		t.Errorf("expected rotation time '2019-10-01T10:00:00Z' but was '%s'", rotated)
+	}
+	logger.CompareLogging("INFO TLS ticket keys of secret 'ingress/tickets' created")
+
+	// not expired
+	now = now.Add(59 * time.Minute)
+	r.check()
+	if newKeys := readKeys(); !reflect.DeepEqual(newKeys, keys) {
+		t.Errorf("expected keys %v not changed but was %v", keys, newKeys)
+	}
+	logger.CompareLogging("")
+
+	// rotated
+	now = now.Add(time.Minute)
+	r.check()
+	newKeys := readKeys()
+	if !reflect.DeepEqual(newKeys[:2], keys[1:]) || newKeys[2] == keys[2] {
+		t.Errorf("expected keys %v rotated but was %v", keys, newKeys)
+	}
+	logger.CompareLogging("INFO TLS ticket keys of secret 'ingress/tickets' rotated")
+
+	// rotated by another replica after the secret was read
+	now = now.Add(time.Hour)
+	keys = newKeys
+	var replicaKeys []string
+	cache.conflict = func() {
+		replicaKeys, _ = ssl.RotateTicketKeys(keys)
+		cache.secrets["ingress/tickets"][ssl.TicketKeysSecretKey] = []byte(strings.Join(replicaKeys, "\n"))
+		cache.secrets["ingress/tickets"]["tls-ticket-keys-rotated"] = []byte(now.Format(time.RFC3339))
+	}
+	r.check()
+	if newKeys := readKeys(); !reflect.DeepEqual(newKeys, replicaKeys) {
+		t.Errorf("expected keys %v of the other replica but was %v", replicaKeys, newKeys)
+	}
+	logger.CompareLogging("")
+	newKeys = replicaKeys
+
+	// invalid keys are replaced
+	cache.secrets["ingress/tickets"][ssl.TicketKeysSecretKey] = []byte(strings.Join(newKeys[:2], "\n"))
+	r.check()
+	if keys := readKeys(); len(keys) != 3 {
+		t.Errorf("expected 3 keys but found %d", len(keys))
+	}
+	logger.CompareLogging(`
+WARN replacing invalid TLS ticket keys of secret 'ingress/tickets': at least 3 ticket keys are needed, found 2
+INFO TLS ticket keys of secret 'ingress/tickets' created`)
+}
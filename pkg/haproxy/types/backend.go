@@ -37,6 +37,13 @@ func (b *Backend) NewEndpoint(ip string, port int, targetRef string) *Endpoint {
 	return endpoint
 }
 
+// TunnelID ...
+func (b *Backend) TunnelID() string {
+	// backend IDs finish with the port number, so this suffix
+	// doesn't conflict with the ID of another backend
+	return b.ID + "_tunnel"
+}
+
 // HreqValidateUserlist ...
 func (b *Backend) HreqValidateUserlist(userlist *Userlist) {
 	// TODO implement
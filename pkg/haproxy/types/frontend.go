@@ -22,11 +22,14 @@ import (
 	"sort"
 )
 
-// HasTCPProxy ...
+// HasTCPProxy returns true if TLS connections need the `mode tcp` frontend,
+// which chooses the backend of ssl-passthrough hosts or the frontend of the
+// other ones. Every frontend has a single bind, whose crt-list has the
+// certificates of all of its hosts, so one frontend doesn't need it.
 func (fg *FrontendGroup) HasTCPProxy() bool {
 	// short-circuit saves:
 	// len(fg.Frontend) may be zero only if fg.HasSSLPassthrough is true
-	return fg.HasSSLPassthrough || len(fg.Frontends) > 1 || len(fg.Frontends[0].Binds) > 1
+	return fg.HasSSLPassthrough || len(fg.Frontends) > 1
 }
 
 // String ...
@@ -44,6 +47,16 @@ func (f *Frontend) HasTLSAuth() bool {
 	return false
 }
 
+// HasCrtHeader ...
+func (f *Frontend) HasCrtHeader(header string) bool {
+	for _, h := range f.TLSCrtHeaders {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
 // HasInvalidErrorPage ...
 func (f *Frontend) HasInvalidErrorPage() bool {
 	for _, host := range f.Hosts {
@@ -70,6 +83,35 @@ func (f *Frontend) HasTLSMandatory() bool {
 	return false
 }
 
+// HasRedirectPath ...
+func (f *Frontend) HasRedirectPath() bool {
+	for _, host := range f.Hosts {
+		if host.HasRedirectPath() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasWildcardHost ...
+func (f *Frontend) HasWildcardHost() bool {
+	return hasWildcardHost(f.Hosts)
+}
+
+// HasWildcardHost ...
+func (b *BindConfig) HasWildcardHost() bool {
+	return hasWildcardHost(b.Hosts)
+}
+
+func hasWildcardHost(hosts []*Host) bool {
+	for _, host := range hosts {
+		if host.IsWildcard() {
+			return true
+		}
+	}
+	return false
+}
+
 // HasVarNamespace ...
 func (f *Frontend) HasVarNamespace() bool {
 	for _, host := range f.Hosts {
@@ -99,24 +141,20 @@ func BuildRawFrontends(hosts []*Host) (frontends []*Frontend, sslpassthrough []*
 		}
 		frontend.Hosts = append(frontend.Hosts, host)
 	}
-	// creating binds
+	// creating binds, per host TLS options are configured in the crt-list
+	// so all the hosts of a frontend share the same bind
 	for _, frontend := range frontends {
-		var binds []*BindConfig
-		for _, host := range frontend.Hosts {
-			bind := findMatchingBind(binds, host)
-			if bind == nil {
-				bind = newFrontendBind(host)
-				binds = append(binds, bind)
-			}
-			bind.Hosts = append(bind.Hosts, host)
+		frontend.Binds = []*BindConfig{
+			&BindConfig{
+				Hosts: frontend.Hosts,
+			},
 		}
-		frontend.Binds = binds
 	}
 	// naming frontends
 	var i int
 	for _, frontend := range frontends {
 		if len(frontend.Hosts) == 1 {
-			frontend.Name = "https-front_" + frontend.Hosts[0].Hostname
+			frontend.Name = "https-front_" + frontend.Hosts[0].ProxyName()
 		} else {
 			i++
 			frontend.Name = fmt.Sprintf("_front_%03d", i)
@@ -138,29 +176,11 @@ func findMatchingFrontend(frontends []*Frontend, host *Host) *Frontend {
 	return nil
 }
 
-func findMatchingBind(binds []*BindConfig, host *Host) *BindConfig {
-	for _, bind := range binds {
-		if bind.match(host) {
-			return bind
-		}
-	}
-	return nil
-}
-
 // newFrontend and Frontend.Match should always sinchronize its attributes
 func newFrontend(host *Host) *Frontend {
 	return &Frontend{
-		Timeout: host.Timeout,
-	}
-}
-
-// newFrontendBind and BindConfig.Match should always sinchronize its attributes
-func newFrontendBind(host *Host) *BindConfig {
-	return &BindConfig{
-		TLS: BindTLSConfig{
-			CAFilename: host.TLS.CAFilename,
-			CAHash:     host.TLS.CAHash,
-		},
+		ErrorPages: host.ErrorPages,
+		Timeout:    host.Timeout,
 	}
 }
 
@@ -168,9 +188,6 @@ func (f *Frontend) match(host *Host) bool {
 	if len(f.Hosts) == 0 {
 		return true
 	}
-	return reflect.DeepEqual(f.Timeout, host.Timeout)
-}
-
-func (b *BindConfig) match(host *Host) bool {
-	return b.TLS.CAHash == host.TLS.CAHash
+	return reflect.DeepEqual(f.Timeout, host.Timeout) &&
+		reflect.DeepEqual(f.ErrorPages, host.ErrorPages)
 }
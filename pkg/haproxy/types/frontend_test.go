@@ -35,12 +35,14 @@ func TestBuildFrontend(t *testing.T) {
 	timeout20 := HostTimeoutConfig{Client: "20s"}
 	ca1 := HostTLSConfig{CAHash: "1"}
 	ca2 := HostTLSConfig{CAHash: "2"}
+	pages1 := []*ErrorPage{{Code: 503, Filename: "/etc/haproxy/errorpages/503_1.http"}}
 	h10_1 := &Host{Hostname: "h1.local", Timeout: timeout10}
 	h10_2 := &Host{Hostname: "h2.local", Timeout: timeout10}
 	h20_1 := &Host{Hostname: "h3.local", Timeout: timeout20}
 	h10CA1_1 := &Host{Hostname: "h4.local", Timeout: timeout10, TLS: ca1}
 	h10CA2_1 := &Host{Hostname: "h5.local", Timeout: timeout10, TLS: ca2}
 	h10CA2_2 := &Host{Hostname: "h6.local", Timeout: timeout10, TLS: ca2}
+	h10EP1_1 := &Host{Hostname: "h7.local", Timeout: timeout10, ErrorPages: pages1}
 	testCases := []struct {
 		hosts    []*Host
 		expected []*Frontend
@@ -97,12 +99,7 @@ func TestBuildFrontend(t *testing.T) {
 					Hosts:   []*Host{h10CA1_1, h10CA2_1, h10CA2_2},
 					Binds: []*BindConfig{
 						&BindConfig{
-							Hosts: []*Host{h10CA1_1},
-							TLS:   BindTLSConfig{CAHash: "1"},
-						},
-						&BindConfig{
-							Hosts: []*Host{h10CA2_1, h10CA2_2},
-							TLS:   BindTLSConfig{CAHash: "2"},
+							Hosts: []*Host{h10CA1_1, h10CA2_1, h10CA2_2},
 						},
 					},
 				},
@@ -116,13 +113,36 @@ func TestBuildFrontend(t *testing.T) {
 					Name:    "_front_001",
 					Timeout: timeout10,
 					Hosts:   []*Host{h10_1, h10_2, h10CA2_1, h10CA2_2},
+					Binds: []*BindConfig{
+						&BindConfig{
+							Hosts: []*Host{h10_1, h10_2, h10CA2_1, h10CA2_2},
+						},
+					},
+				},
+			},
+		},
+		// 4
+		{
+			hosts: []*Host{h10_1, h10EP1_1, h10_2},
+			expected: []*Frontend{
+				{
+					Name:    "_front_001",
+					Timeout: timeout10,
+					Hosts:   []*Host{h10_1, h10_2},
 					Binds: []*BindConfig{
 						&BindConfig{
 							Hosts: []*Host{h10_1, h10_2},
 						},
+					},
+				},
+				{
+					Name:       "https-front_h7.local",
+					ErrorPages: pages1,
+					Timeout:    timeout10,
+					Hosts:      []*Host{h10EP1_1},
+					Binds: []*BindConfig{
 						&BindConfig{
-							Hosts: []*Host{h10CA2_1, h10CA2_2},
-							TLS:   BindTLSConfig{CAHash: "2"},
+							Hosts: []*Host{h10EP1_1},
 						},
 					},
 				},
@@ -0,0 +1,47 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"sort"
+)
+
+// AddDomains ...
+func (acme *AcmeConfig) AddDomains(secretName string, domains []string) {
+	if acme.Certs == nil {
+		acme.Certs = map[string]map[string]struct{}{}
+	}
+	certs, found := acme.Certs[secretName]
+	if !found {
+		certs = map[string]struct{}{}
+		acme.Certs[secretName] = certs
+	}
+	for _, domain := range domains {
+		certs[domain] = struct{}{}
+	}
+}
+
+// Domains returns the sorted list of domains of a secret
+func (acme *AcmeConfig) Domains(secretName string) []string {
+	certs := acme.Certs[secretName]
+	domains := make([]string, 0, len(certs))
+	for domain := range certs {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
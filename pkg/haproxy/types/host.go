@@ -18,7 +18,9 @@ package types
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 )
 
 // FindPath ...
@@ -43,11 +45,59 @@ func (h *Host) AddPath(backend *Backend, path string) {
 	})
 }
 
+// HasRedirectPath ...
+func (h *Host) HasRedirectPath() bool {
+	for _, path := range h.Paths {
+		if path.Redirect.URL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWildcard ...
+func (h *Host) IsWildcard() bool {
+	return strings.HasPrefix(h.Hostname, "*.")
+}
+
+// HostnameRegex returns the hostname as a regular expression. The wildcard
+// matches only one subdomain, so `*.domain.com` matches `app.domain.com`
+// but does not match `sub.app.domain.com`. The end of the hostname isn't
+// anchored, so the path can be concatenated.
+func (h *Host) HostnameRegex() string {
+	if !h.IsWildcard() {
+		return "^" + regexp.QuoteMeta(h.Hostname)
+	}
+	return "^[^.]+" + regexp.QuoteMeta(strings.TrimPrefix(h.Hostname, "*"))
+}
+
+// AliasNameRegex returns the server alias as a regular expression, using
+// the same wildcard rule and anchor of HostnameRegex.
+func (h *Host) AliasNameRegex() string {
+	alias := h.Alias.AliasName
+	if !strings.HasPrefix(alias, "*.") {
+		return "^" + regexp.QuoteMeta(alias)
+	}
+	return "^[^.]+" + regexp.QuoteMeta(strings.TrimPrefix(alias, "*"))
+}
+
+// ProxyName returns the hostname in a format that can be used
+// as part of the name of a proxy or server
+func (h *Host) ProxyName() string {
+	return strings.Replace(h.Hostname, "*", "_wildcard", 1)
+}
+
 // HasTLSAuth ...
 func (h *Host) HasTLSAuth() bool {
 	return h.TLS.CAHash != ""
 }
 
+// HasTLSConfig ...
+func (h *Host) HasTLSConfig() bool {
+	tls := h.TLS
+	return tls.Ciphers != "" || tls.CipherSuites != "" || tls.MinVersion != "" || tls.MaxVersion != ""
+}
+
 // String ...
 func (h *Host) String() string {
 	return fmt.Sprintf("%+v", *h)
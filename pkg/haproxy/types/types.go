@@ -16,6 +16,10 @@ limitations under the License.
 
 package types
 
+import (
+	"time"
+)
+
 // Global ...
 type Global struct {
 	Procs           ProcsConfig
@@ -27,7 +31,25 @@ type Global struct {
 	DrainSupport    bool
 	LoadServerState bool
 	StatsSocket     string
+	MasterWorker    bool
 	CustomConfig    []string
+	ErrorPages      []*ErrorPage
+	Acme            AcmeConfig
+}
+
+// AcmeConfig ...
+//
+// Certs has the domains of every secret that should be signed by the ACME
+// server, the key is the secret name in the namespace/name format.
+type AcmeConfig struct {
+	Enabled     bool
+	Emails      string
+	Endpoint    string
+	Expiring    time.Duration
+	Prefix      string
+	Socket      string
+	TermsAgreed bool
+	Certs       map[string]map[string]struct{}
 }
 
 // ProcsConfig ...
@@ -57,11 +79,13 @@ type TimeoutConfig struct {
 
 // SSLConfig ...
 type SSLConfig struct {
-	DHParam   DHParamConfig
-	Ciphers   string
-	Options   string
-	Engine    string
-	ModeAsync bool
+	DHParam       DHParamConfig
+	Ciphers       string
+	Options       string
+	Engine        string
+	HeadersPrefix string
+	ModeAsync     bool
+	TicketKeys    []string
 }
 
 // DHParamConfig ...
@@ -85,13 +109,39 @@ type ModSecurityTimeoutConfig struct {
 
 // FrontendGroup ...
 type FrontendGroup struct {
-	Frontends         []*Frontend
-	HasHTTPHost       bool
-	HasRedirectHTTPS  bool
-	HasSSLPassthrough bool
-	HTTPFrontsMap     string
-	RedirectMap       string
-	SSLPassthroughMap string
+	Frontends              []*Frontend
+	HasHTTPHost            bool
+	HasRedirectHTTPS       bool
+	HasRedirectPath        bool
+	HasSSLPassthrough      bool
+	HasTunnelBackend       bool
+	HasWildcardHost        bool
+	HTTPErrorPagesFronts   []*HTTPErrorPagesFront
+	HTTPErrorPagesMap      string
+	HTTPErrorPagesRegexMap string
+	HTTPFrontsMap          string
+	HTTPFrontsRegexMap     string
+	RedirectMap            string
+	RedirectRegexMap       string
+	RedirectPathsMap       string
+	RedirectPathsRegexMap  string
+	SSLPassthroughMap      string
+	SSLPassthroughRegexMap string
+	TLSTicketKeysFile      string
+	TunnelBackendsList     string
+}
+
+// HTTPErrorPagesFront ...
+//
+// An internal frontend of the plain HTTP requests of the hosts whose
+// error pages differ from the global ones. The HTTP frontend has only
+// the global error pages, so it sends the requests of these hosts to
+// BackendName, whose server is the Socket of this frontend.
+type HTTPErrorPagesFront struct {
+	Name        string
+	BackendName string
+	Socket      string
+	ErrorPages  []*ErrorPage
 }
 
 // Frontend ...
@@ -100,15 +150,28 @@ type Frontend struct {
 	Binds []*BindConfig
 	Hosts []*Host
 	//
-	ConvertLowercase           bool
-	HostBackendsMap            string
-	SNIBackendsMap             string
-	Timeout                    HostTimeoutConfig
-	TLSInvalidCrtErrorList     string
-	TLSNoCrtErrorList          string
-	TLSInvalidCrtErrorPagesMap string
-	TLSNoCrtErrorPagesMap      string
-	VarNamespaceMap            string
+	ConvertLowercase                bool
+	ErrorPages                      []*ErrorPage
+	HostBackendsMap                 string
+	HostBackendsRegexMap            string
+	RedirectPathsMap                string
+	RedirectPathsRegexMap           string
+	SNIBackendsMap                  string
+	SNIBackendsRegexMap             string
+	Timeout                         HostTimeoutConfig
+	TLSCrtHeaders                   []string
+	TLSCrtHeadersMap                string
+	TLSCrtHeadersRegexMap           string
+	TLSInvalidCrtErrorList          string
+	TLSInvalidCrtErrorRegexList     string
+	TLSNoCrtErrorList               string
+	TLSNoCrtErrorRegexList          string
+	TLSInvalidCrtErrorPagesMap      string
+	TLSInvalidCrtErrorPagesRegexMap string
+	TLSNoCrtErrorPagesMap           string
+	TLSNoCrtErrorPagesRegexMap      string
+	VarNamespaceMap                 string
+	VarNamespaceRegexMap            string
 }
 
 // BindConfig ...
@@ -117,17 +180,34 @@ type BindConfig struct {
 	Socket string
 	Hosts  []*Host
 	//
-	AcceptProxy   bool
-	TLS           BindTLSConfig
-	UseServerList string
+	AcceptProxy        bool
+	TLS                BindTLSConfig
+	UseServerList      string
+	UseServerRegexList string
 }
 
 // BindTLSConfig ...
 type BindTLSConfig struct {
-	CAFilename string
-	CAHash     string
-	TLSCert    string
-	TLSCertDir string
+	CrtListFile string
+}
+
+// CrtListEntry ...
+//
+// An entry of the crt-list file of a bind. Options override the ones
+// declared in the bind line, only to the connections whose SNI extension
+// matches one of the SNIFilter names. An entry without SNI filter use
+// the names found in the certificate.
+type CrtListEntry struct {
+	Filename     string
+	ALPN         string
+	CAFile       string
+	Ciphers      string
+	CipherSuites string
+	CRLFile      string
+	MaxVersion   string
+	MinVersion   string
+	Verify       string
+	SNIFilter    []string
 }
 
 // Host ...
@@ -136,12 +216,17 @@ type BindTLSConfig struct {
 // alias or regex matches the request. If wildcard hostname is not declared,
 // the default backend will be used. If the default backend is empty,
 // a default 404 page generated by HAProxy will be used.
+//
+// Hostnames starting with `*.` match one subdomain level and are only used
+// if no exact hostname matches the request.
 type Host struct {
 	Hostname string
 	Paths    []*HostPath
 	//
 	Alias                  HostAliasConfig
+	ErrorPages             []*ErrorPage
 	HTTPPassthroughBackend *Backend
+	Maintenance            HostMaintenanceConfig
 	RootRedirect           string
 	SSLPassthrough         bool
 	Timeout                HostTimeoutConfig
@@ -159,6 +244,8 @@ type HostPath struct {
 	Path      string
 	Backend   *Backend
 	BackendID string
+	//
+	Redirect HostRedirectConfig
 }
 
 // HostAliasConfig ...
@@ -167,6 +254,20 @@ type HostAliasConfig struct {
 	AliasRegex string
 }
 
+// HostMaintenanceConfig ...
+type HostMaintenanceConfig struct {
+	Enabled     bool
+	BypassCIDRs []string
+	Page        *ErrorPage
+}
+
+// HostRedirectConfig ...
+type HostRedirectConfig struct {
+	Code    int
+	KeepURI bool
+	URL     string
+}
+
 // HostTimeoutConfig ...
 type HostTimeoutConfig struct {
 	Client    string
@@ -176,14 +277,29 @@ type HostTimeoutConfig struct {
 // HostTLSConfig ...
 type HostTLSConfig struct {
 	AddCertHeader    bool
+	CertHeaders      []string
 	CAErrorPage      string
 	CAFilename       string
 	CAHash           string
 	CAVerifyOptional bool
+	Ciphers          string
+	CipherSuites     string
+	CRLFilename      string
+	CRLHash          string
+	MaxVersion       string
+	MinVersion       string
 	TLSFilename      string
 	TLSHash          string
 }
 
+// ErrorPage ...
+type ErrorPage struct {
+	Code     int
+	Headers  []string
+	Content  string
+	Filename string
+}
+
 // Backend ...
 type Backend struct {
 	ID        string
@@ -191,6 +307,9 @@ type Backend struct {
 	Name      string
 	Port      int
 	Endpoints []*Endpoint
+	// Ingress is the name of the first ingress, in the conversion order,
+	// which uses the backend, used to label its metrics
+	Ingress string
 	//
 	AgentCheck        AgentCheck
 	BalanceAlgorithm  string
@@ -206,6 +325,7 @@ type Backend struct {
 	SSL               SSLBackendConfig
 	SSLRedirect       bool
 	Timeout           BackendTimeoutConfig
+	Tunnel            BackendTunnelConfig
 }
 
 // Endpoint ...
@@ -255,6 +375,17 @@ type BackendTimeoutConfig struct {
 	Tunnel      string
 }
 
+// BackendTunnelConfig ...
+//
+// Upgrade requests, e.g. WebSockets, are sent to a distinct backend with the
+// same endpoints, so the tunnel timeout and the max number of connections of
+// long-lived connections don't interfere with the regular requests.
+type BackendTunnelConfig struct {
+	Enabled       bool
+	MaxConnServer int
+	Timeout       string
+}
+
 // Cookie ...
 type Cookie struct {
 	Name     string
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MasterCLIVersion is the first version of HAProxy with the master CLI,
+// needed by the supervisor to reload HAProxy and to list its workers
+const MasterCLIVersion = "1.9"
+
+// RuntimeCertVersion is the first version of HAProxy which updates
+// certificates via the runtime API, with `set ssl cert`
+const RuntimeCertVersion = "2.1"
+
+// `HA-Proxy version 1.9.4 2019/02/06` or `HAProxy version 2.1.0 2019/11/25`
+var versionRegex = regexp.MustCompile(`^HA-?Proxy version ([0-9]+\.[0-9]+(\.[0-9]+)?)`)
+
+// HAProxyVersion returns the version of the HAProxy binary, e.g. `2.0.10`
+func HAProxyVersion(haproxyCmd string) (string, error) {
+	out, err := exec.Command(haproxyCmd, "-v").Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading HAProxy version: %v", err)
+	}
+	return parseVersion(string(out))
+}
+
+func parseVersion(out string) (string, error) {
+	match := versionRegex.FindStringSubmatch(strings.TrimSpace(out))
+	if match == nil {
+		return "", fmt.Errorf("unexpected output of haproxy -v: %s", strings.TrimSpace(out))
+	}
+	return match[1], nil
+}
+
+// VersionAtLeast returns true if version, e.g. `2.0.10`, is
+// equal to or greater than min, e.g. `1.9`. An unknown or
+// malformed version is assumed to be recent enough.
+func VersionAtLeast(version, min string) bool {
+	if version == "" {
+		return true
+	}
+	v := strings.Split(version, ".")
+	m := strings.Split(min, ".")
+	for i := range m {
+		if i >= len(v) {
+			return false
+		}
+		vi, err1 := strconv.Atoi(v[i])
+		mi, err2 := strconv.Atoi(m[i])
+		if err1 != nil || err2 != nil {
+			return true
+		}
+		if vi != mi {
+			return vi > mi
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package haproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := []struct {
+		out      string
+		expected string
+	}{
+		// 0
+		{
+			out:      "HA-Proxy version 1.8.19 2019/02/11\nCopyright 2000-2019 Willy Tarreau <willy@haproxy.org>\n",
+			expected: "1.8.19",
+		},
+		// 1
+		{
+			out:      "HA-Proxy version 2.0.10 2019/11/25 - https://haproxy.org/\n",
+			expected: "2.0.10",
+		},
+		// 2
+		{
+			out:      "HAProxy version 2.1.0 2019/11/25 - https://haproxy.org/\n",
+			expected: "2.1.0",
+		},
+		// 3
+		{
+			out:      "sh: haproxy: not found\n",
+			expected: "",
+		},
+	}
+	for i, test := range testCases {
+		version, _ := parseVersion(test.out)
+		if version != test.expected {
+			t.Errorf("%d: expected '%s' but was '%s'", i, test.expected, version)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	testCases := []struct {
+		version  string
+		min      string
+		expected bool
+	}{
+		{"1.8.19", "1.9", false},
+		{"1.9.0", "1.9", true},
+		{"2.0.10", "1.9", true},
+		{"2.0.10", "2.1", false},
+		{"2.1", "2.1", true},
+		{"10.0", "2.1", true},
+		{"2", "2.1", false},
+		{"", "2.1", true},
+	}
+	for i, test := range testCases {
+		if atLeast := VersionAtLeast(test.version, test.min); atLeast != test.expected {
+			t.Errorf("%d: expected %v on '%s' >= '%s' but was %v", i, test.expected, test.version, test.min, atLeast)
+		}
+	}
+}
+
+var dockerfileRegex = regexp.MustCompile(`(?m)^FROM haproxy:([0-9.]+)`)
+
+// bundledVersion returns the version of HAProxy of the controller image
+func bundledVersion(t *testing.T) string {
+	dockerfile, err := ioutil.ReadFile("../../rootfs/Dockerfile")
+	if err != nil {
+		t.Fatalf("error reading Dockerfile: %v", err)
+	}
+	match := dockerfileRegex.FindStringSubmatch(string(dockerfile))
+	if match == nil {
+		t.Fatalf("HAProxy image not found in the Dockerfile")
+	}
+	return match[1]
+}
+
+func TestBundledVersion(t *testing.T) {
+	if version := bundledVersion(t); !VersionAtLeast(version, MasterCLIVersion) {
+		t.Errorf("the supervisor needs HAProxy %s or newer, the image has %s", MasterCLIVersion, version)
+	}
+}
+
+// bundledHAProxy returns the HAProxy binary of the PATH and its version,
+// e.g. running in the controller image. Skips the test if the binary isn't
+// found or its version isn't the one of the controller image.
+func bundledHAProxy(t *testing.T) (string, string) {
+	haproxyCmd, err := exec.LookPath("haproxy")
+	if err != nil {
+		t.Skip("haproxy binary not found")
+	}
+	version, err := HAProxyVersion(haproxyCmd)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if bundled := bundledVersion(t); version != bundled {
+		t.Skipf("haproxy binary has version %s, the controller image has %s", version, bundled)
+	}
+	return haproxyCmd, version
+}
+
+// TestSupervisorHAProxy starts and reloads the bundled HAProxy binary
+func TestSupervisorHAProxy(t *testing.T) {
+	haproxyCmd, version := bundledHAProxy(t)
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	configFile := tempdir + "/haproxy.cfg"
+	config := `global
+    stats socket ` + tempdir + `/admin.sock level admin expose-fd listeners
+defaults
+    mode http
+    timeout client 1s
+    timeout connect 1s
+    timeout server 1s
+frontend _front_http
+    bind unix@` + tempdir + `/http.sock
+    http-request deny
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	sup := NewSupervisor(&types_helper.LoggerMock{T: t}, SupervisorOptions{
+		HAProxyCmd:    haproxyCmd,
+		ConfigFile:    configFile,
+		MasterSocket:  tempdir + "/master.sock",
+		AdminSocket:   tempdir + "/admin.sock",
+		StateFile:     tempdir + "/state-global",
+		ReloadTimeout: 10 * time.Second,
+	}).(*supervisor)
+	defer sup.Stop()
+	if err := sup.Reload(); err != nil {
+		t.Fatalf("error starting HAProxy %s: %v", version, err)
+	}
+	started := sup.workers
+	if err := sup.Reload(); err != nil {
+		t.Fatalf("error reloading HAProxy %s: %v", version, err)
+	}
+	if !hasNewWorker(&procs{workers: started}, &procs{workers: sup.workers}) {
+		t.Errorf("expected new workers but was %v, started with %v", sup.workers, started)
+	}
+}
@@ -19,12 +19,16 @@ package main
 import (
 	"github.com/golang/glog"
 	"github.com/jcmoraisjr/haproxy-ingress/pkg/controller"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/render"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(render.Command(os.Args[2:]))
+	}
 	hc := controller.NewHAProxyController()
 	errCh := make(chan error)
 	go handleSignal(hc, errCh)
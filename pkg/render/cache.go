@@ -0,0 +1,228 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/file"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+)
+
+// cache implements ingtypes.Cache, reading the objects of the manifests.
+// Secrets are written as pem files in sslDir, named after the secret.
+type cache struct {
+	manifests *manifests
+	sslDir    string
+}
+
+func newCache(manifests *manifests, sslDir string) *cache {
+	return &cache{
+		manifests: manifests,
+		sslDir:    sslDir,
+	}
+}
+
+func (c *cache) GetService(serviceName string) (*api.Service, error) {
+	if svc, found := c.manifests.services[serviceName]; found {
+		return svc, nil
+	}
+	return nil, fmt.Errorf("service not found: '%s'", serviceName)
+}
+
+func (c *cache) GetEndpoints(service *api.Service) (*api.Endpoints, error) {
+	serviceName := service.Namespace + "/" + service.Name
+	if ep, found := c.manifests.endpoints[serviceName]; found {
+		return ep, nil
+	}
+	return nil, fmt.Errorf("could not find endpoints for service '%s'", serviceName)
+}
+
+func (c *cache) GetPod(podName string) (*api.Pod, error) {
+	if pod, found := c.manifests.pods[podName]; found {
+		return pod, nil
+	}
+	return nil, fmt.Errorf("pod not found: '%s'", podName)
+}
+
+func (c *cache) getSecret(secretName string) (*api.Secret, error) {
+	if secret, found := c.manifests.secrets[secretName]; found {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("secret not found: '%s'", secretName)
+}
+
+func (c *cache) GetTLSSecretPath(secretName string) (ingtypes.File, error) {
+	secret, err := c.getSecret(secretName)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	if hasBundleKeys(secret) {
+		return c.GetTLSSecretBundlePath([]string{secretName})
+	}
+	crt, foundCrt := secret.Data[api.TLSCertKey]
+	key, foundKey := secret.Data[api.TLSPrivateKeyKey]
+	if !foundCrt || !foundKey {
+		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have keys 'tls.crt' and 'tls.key'", secretName)
+	}
+	if _, err := tls.X509KeyPair(crt, key); err != nil {
+		return ingtypes.File{}, fmt.Errorf("error reading certificate of secret '%s': %v", secretName, err)
+	}
+	return c.writeFile(fileName(secretName)+".pem", crt, []byte("\n"), key)
+}
+
+// bundleKeys are the additional key pairs of a TLS secret, see the
+// same declaration of the controller's cache
+var bundleKeys = []string{"tls-rsa", "tls-ecdsa"}
+
+func hasBundleKeys(secret *api.Secret) bool {
+	for _, key := range bundleKeys {
+		if _, found := secret.Data[key+".crt"]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *cache) GetTLSSecretBundlePath(secretNames []string) (ingtypes.File, error) {
+	var pairs []ssl.CertKeyPair
+	names := make([]string, len(secretNames))
+	for i, secretName := range secretNames {
+		secret, err := c.getSecret(secretName)
+		if err != nil {
+			return ingtypes.File{}, err
+		}
+		for _, key := range append([]string{"tls"}, bundleKeys...) {
+			crt, foundCrt := secret.Data[key+".crt"]
+			pkey, foundKey := secret.Data[key+".key"]
+			if foundCrt != foundKey {
+				return ingtypes.File{}, fmt.Errorf("secret '%s' should have both keys '%s.crt' and '%s.key'", secretName, key, key)
+			}
+			if foundCrt {
+				pairs = append(pairs, ssl.CertKeyPair{Cert: crt, Key: pkey})
+			}
+		}
+		names[i] = fileName(secretName)
+	}
+	if len(pairs) == 0 {
+		return ingtypes.File{}, fmt.Errorf("secret(s) '%s' does not have keys 'tls.crt' and 'tls.key'", strings.Join(secretNames, ","))
+	}
+	if err := c.mkdirSSL(); err != nil {
+		return ingtypes.File{}, err
+	}
+	// same name of the controller's bundle, see its cache
+	sslCert, err := ssl.AddOrUpdateCertBundle(c.sslDir, strings.Join(names, "+")+"_bundle", pairs)
+	if err != nil {
+		return ingtypes.File{}, fmt.Errorf("error creating certificate bundle of '%s': %v", strings.Join(secretNames, ","), err)
+	}
+	return ingtypes.File{
+		Filename: sslCert.PemFileName,
+		SHA1Hash: sslCert.PemSHA,
+	}, nil
+}
+
+func (c *cache) GetCASecretPath(secretName string) (ca, crl ingtypes.File, err error) {
+	secret, err := c.getSecret(secretName)
+	if err != nil {
+		return ca, crl, err
+	}
+	caData, found := secret.Data["ca.crt"]
+	if !found {
+		return ca, crl, fmt.Errorf("secret '%s' does not have key 'ca.crt'", secretName)
+	}
+	if ca, err = c.writeFile("ca-"+fileName(secretName)+".pem", caData); err != nil {
+		return ca, crl, err
+	}
+	crlData, found := secret.Data["ca.crl"]
+	if !found {
+		return ca, crl, nil
+	}
+	crlFileName, err := ssl.AddOrUpdateCRL(c.sslDir, fileName(secretName), crlData)
+	if err != nil {
+		return ca, crl, fmt.Errorf("error creating crl file of secret '%s': %v", secretName, err)
+	}
+	crl = ingtypes.File{
+		Filename: crlFileName,
+		SHA1Hash: file.SHA1(crlFileName),
+	}
+	return ca, crl, nil
+}
+
+func (c *cache) GetDHSecretPath(secretName string) (ingtypes.File, error) {
+	secret, err := c.getSecret(secretName)
+	if err != nil {
+		return ingtypes.File{}, err
+	}
+	dh, found := secret.Data["dhparam.pem"]
+	if !found {
+		return ingtypes.File{}, fmt.Errorf("secret '%s' does not have key 'dhparam.pem'", secretName)
+	}
+	return c.writeFile("dh-"+fileName(secretName)+".pem", dh)
+}
+
+func (c *cache) GetSecretContent(secretName, keyName string) ([]byte, error) {
+	secret, err := c.getSecret(secretName)
+	if err != nil {
+		return nil, err
+	}
+	data, found := secret.Data[keyName]
+	if !found {
+		return nil, fmt.Errorf("secret '%s' does not have key '%s'", secretName, keyName)
+	}
+	return data, nil
+}
+
+func (c *cache) GetConfigMapContent(configMapName string) (map[string]string, error) {
+	if configMap, found := c.manifests.configMaps[configMapName]; found {
+		return configMap.Data, nil
+	}
+	return nil, fmt.Errorf("configmap not found: '%s'", configMapName)
+}
+
+func (c *cache) mkdirSSL() error {
+	return os.MkdirAll(c.sslDir, 0700)
+}
+
+// writeFile concatenates contents into the name file of the ssl dir
+func (c *cache) writeFile(name string, contents ...[]byte) (ingtypes.File, error) {
+	if err := c.mkdirSSL(); err != nil {
+		return ingtypes.File{}, err
+	}
+	var data []byte
+	for _, content := range contents {
+		data = append(data, content...)
+	}
+	filename := c.sslDir + "/" + name
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return ingtypes.File{}, err
+	}
+	return ingtypes.File{
+		Filename: filename,
+		SHA1Hash: file.SHA1(filename),
+	}, nil
+}
+
+func fileName(secretName string) string {
+	return strings.Replace(secretName, "/", "_", -1)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+)
+
+// Command runs the render subcommand, args are the
+// command line arguments following `render`
+func Command(args []string) int {
+	flags := pflag.NewFlagSet("render", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: haproxy-ingress-controller render [options] <file-or-dir> [<file-or-dir>...]\n\n")
+		flags.PrintDefaults()
+	}
+	options := &Options{}
+	flags.StringVar(&options.OutputDir, "output", "",
+		`Directory where haproxy.cfg, the maps, the error pages and the certificates are written`)
+	flags.StringVar(&options.TemplatesDir, "templates-dir", "/etc/haproxy",
+		`Directory with the template, maptemplate and modsecurity templates`)
+	flags.StringVar(&options.ConfigMapName, "configmap", "",
+		`Name of the ConfigMap, found in the manifests, with the global config, in the form namespace/name`)
+	flags.StringVar(&options.DefaultBackend, "default-backend-service", "",
+		`Service used as the default backend, in the form namespace/name`)
+	flags.StringVar(&options.DefaultSSLCertificate, "default-ssl-certificate", "",
+		`Secret with the default certificate, in the form namespace/name. A fake certificate is used if not provided`)
+	flags.StringVar(&options.IngressClass, "ingress-class", "haproxy",
+		`Name of the ingress class to be rendered`)
+	verbosity := flags.IntP("v", "v", 0, `Log level of the info messages`)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	options.Manifests = flags.Args()
+	if options.OutputDir == "" || len(options.Manifests) == 0 {
+		flags.Usage()
+		return 2
+	}
+	logger := &logger{out: os.Stderr, verbosity: *verbosity}
+	if err := Render(logger, options); err != nil {
+		logger.Error("error rendering configuration: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// logger implements types.Logger, writing to out
+type logger struct {
+	out       io.Writer
+	verbosity int
+}
+
+func (l *logger) log(level, msg string, args []interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	fmt.Fprintf(l.out, "%s %s\n", level, msg)
+}
+
+func (l *logger) InfoV(v int, msg string, args ...interface{}) {
+	if v <= l.verbosity {
+		l.log("INFO", msg, args)
+	}
+}
+
+func (l *logger) Info(msg string, args ...interface{}) {
+	l.log("INFO", msg, args)
+}
+
+func (l *logger) Warn(msg string, args ...interface{}) {
+	l.log("WARN", msg, args)
+}
+
+func (l *logger) Error(msg string, args ...interface{}) {
+	l.log("ERROR", msg, args)
+}
+
+func (l *logger) Fatal(msg string, args ...interface{}) {
+	l.log("FATAL", msg, args)
+	os.Exit(1)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// manifests are the objects read from the manifest files, indexed by
+// namespace/name. Objects of other kinds are ignored. The defaults of the
+// apiserver which are used by the converter are applied when reading.
+type manifests struct {
+	ingress    []*extensions.Ingress
+	services   map[string]*api.Service
+	endpoints  map[string]*api.Endpoints
+	pods       map[string]*api.Pod
+	secrets    map[string]*api.Secret
+	configMaps map[string]*api.ConfigMap
+}
+
+func newManifests() *manifests {
+	return &manifests{
+		services:   map[string]*api.Service{},
+		endpoints:  map[string]*api.Endpoints{},
+		pods:       map[string]*api.Pod{},
+		secrets:    map[string]*api.Secret{},
+		configMaps: map[string]*api.ConfigMap{},
+	}
+}
+
+// readManifests reads the YAML or JSON files of paths. A directory has
+// its .yaml, .yml and .json files read, in lexical order, not recursively.
+// Files can have more than one document, and documents can be v1 lists.
+func readManifests(logger types.Logger, paths []string) (*manifests, error) {
+	m := newManifests()
+	for _, path := range paths {
+		files, err := manifestFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := m.readFile(logger, file); err != nil {
+				return nil, fmt.Errorf("error reading '%s': %v", file, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+func manifestFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (m *manifests) readFile(logger types.Logger, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		data, err := yaml.ToJSON(doc)
+		if err != nil {
+			return err
+		}
+		if err := m.add(logger, data); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *manifests) add(logger types.Logger, data []byte) error {
+	if string(data) == "null" {
+		// a document with comments only
+		return nil
+	}
+	typeMeta := metav1.TypeMeta{}
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return err
+	}
+	if typeMeta.Kind == "List" {
+		list := metav1.List{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		for _, item := range list.Items {
+			if err := m.add(logger, item.Raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		if runtime.IsNotRegisteredError(err) {
+			logger.InfoV(2, "ignoring object of unsupported kind '%s'", typeMeta.Kind)
+			return nil
+		}
+		return err
+	}
+	switch obj := obj.(type) {
+	case *extensions.Ingress:
+		defaultNamespace(&obj.ObjectMeta)
+		m.ingress = append(m.ingress, obj)
+	case *api.Service:
+		for i := range obj.Spec.Ports {
+			port := &obj.Spec.Ports[i]
+			if port.Protocol == "" {
+				port.Protocol = api.ProtocolTCP
+			}
+			if port.TargetPort.IntValue() == 0 && port.TargetPort.StrVal == "" {
+				port.TargetPort = intstr.FromInt(int(port.Port))
+			}
+		}
+		m.services[defaultNamespace(&obj.ObjectMeta)] = obj
+	case *api.Endpoints:
+		for i := range obj.Subsets {
+			for j := range obj.Subsets[i].Ports {
+				if port := &obj.Subsets[i].Ports[j]; port.Protocol == "" {
+					port.Protocol = api.ProtocolTCP
+				}
+			}
+		}
+		m.endpoints[defaultNamespace(&obj.ObjectMeta)] = obj
+	case *api.Pod:
+		m.pods[defaultNamespace(&obj.ObjectMeta)] = obj
+	case *api.Secret:
+		// stringData is merged by the apiserver, which is not here
+		for key, value := range obj.StringData {
+			if obj.Data == nil {
+				obj.Data = map[string][]byte{}
+			}
+			obj.Data[key] = []byte(value)
+		}
+		m.secrets[defaultNamespace(&obj.ObjectMeta)] = obj
+	case *api.ConfigMap:
+		m.configMaps[defaultNamespace(&obj.ObjectMeta)] = obj
+	default:
+		logger.InfoV(2, "ignoring object of unsupported kind '%s'", typeMeta.Kind)
+	}
+	return nil
+}
+
+// defaultNamespace uses the default namespace if the object doesn't
+// declare one, as kubectl does, and returns its namespace/name
+func defaultNamespace(meta *metav1.ObjectMeta) string {
+	if meta.Namespace == "" {
+		meta.Namespace = api.NamespaceDefault
+	}
+	return meta.Namespace + "/" + meta.Name
+}
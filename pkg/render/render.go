@@ -0,0 +1,128 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"os"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/ingress/annotations/class"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl"
+	ingressconverter "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress"
+	ingtypes "github.com/jcmoraisjr/haproxy-ingress/pkg/converters/ingress/types"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/haproxy"
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// Options ...
+type Options struct {
+	// Manifests are the files or directories with the objects to be rendered
+	Manifests             []string
+	OutputDir             string
+	TemplatesDir          string
+	ConfigMapName         string
+	DefaultBackend        string
+	DefaultSSLCertificate string
+	IngressClass          string
+}
+
+// Render converts the ingress resources of the manifests, using the other
+// objects of the manifests as the cluster state, and writes haproxy.cfg,
+// the maps and the error pages in the output dir. Files referenced by the
+// configuration, like certificates, are written in the ssl dir of the
+// output. HAProxy is neither checked nor started.
+func Render(logger types.Logger, options *Options) error {
+	m, err := readManifests(logger, options.Manifests)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return err
+	}
+	cache := newCache(m, options.OutputDir+"/ssl")
+	var globalConfig map[string]string
+	if options.ConfigMapName != "" {
+		globalConfig, err = cache.GetConfigMapContent(options.ConfigMapName)
+		if err != nil {
+			return err
+		}
+	}
+	defaultSSLFile, err := defaultSSLFile(logger, cache, options.DefaultSSLCertificate)
+	if err != nil {
+		return err
+	}
+	instance := haproxy.CreateInstance(logger, haproxy.InstanceOptions{
+		HAProxyConfigFile: options.OutputDir + "/haproxy.cfg",
+		TemplatesDir:      options.TemplatesDir,
+		MapsDir:           options.OutputDir + "/maps",
+		ErrorPagesDir:     options.OutputDir + "/errorpages",
+	})
+	if err := instance.ParseTemplates(); err != nil {
+		return err
+	}
+	var ingress []*extensions.Ingress
+	for _, ing := range m.ingress {
+		if class.IsValid(ing, options.IngressClass, "haproxy") {
+			ingress = append(ingress, ing)
+		}
+	}
+	converter := ingressconverter.NewIngressConverter(&ingtypes.ConverterOptions{
+		Logger:           logger,
+		Cache:            cache,
+		AnnotationPrefix: "ingress.kubernetes.io",
+		DefaultBackend:   options.DefaultBackend,
+		DefaultSSLFile:   defaultSSLFile,
+		EventRecorder:    &eventLogger{logger: logger},
+	}, instance.Config(), globalConfig)
+	converter.Sync(ingress)
+	return instance.Write()
+}
+
+// defaultSSLFile reads the default certificate from the manifests,
+// or creates a fake one if a secret name is not provided
+func defaultSSLFile(logger types.Logger, cache *cache, secretName string) (ingtypes.File, error) {
+	if secretName != "" {
+		return cache.GetTLSSecretPath(secretName)
+	}
+	logger.InfoV(2, "using auto generated fake certificate")
+	crt, key := ssl.GetFakeSSLCert()
+	return cache.writeFile("default-fake-certificate.pem", crt, []byte("\n"), key)
+}
+
+// eventLogger implements ingtypes.EventRecorder, logging the
+// events which would be emitted on the ingress resources
+type eventLogger struct {
+	logger types.Logger
+}
+
+func (e *eventLogger) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	var source string
+	if obj, err := meta.Accessor(object); err == nil {
+		source = fmt.Sprintf("ingress '%s/%s': ", obj.GetNamespace(), obj.GetName())
+	}
+	msg := fmt.Sprintf(messageFmt, args...)
+	if eventtype == api.EventTypeWarning {
+		e.logger.Warn("%s%s: %s", source, reason, msg)
+	} else {
+		e.logger.Info("%s%s: %s", source, reason, msg)
+	}
+}
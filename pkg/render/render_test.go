@@ -0,0 +1,253 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	api "k8s.io/api/core/v1"
+
+	ssl_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/common/net/ssl/helper_test"
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+const manifestApp = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: echo
+spec:
+  ports:
+  - port: 8080
+---
+apiVersion: v1
+kind: Endpoints
+metadata:
+  name: echo
+subsets:
+- addresses:
+  - ip: 172.17.0.11
+  ports:
+  - port: 8080
+---
+# comments only
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: echo
+`
+
+const manifestIngress = `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: extensions/v1beta1
+  kind: Ingress
+  metadata:
+    name: echo
+    annotations:
+      ingress.kubernetes.io/balance-algorithm: leastconn
+  spec:
+    rules:
+    - host: echo.local
+      http:
+        paths:
+        - path: /
+          backend:
+            serviceName: echo
+            servicePort: 8080
+- apiVersion: extensions/v1beta1
+  kind: Ingress
+  metadata:
+    name: other
+    annotations:
+      kubernetes.io/ingress.class: other
+  spec:
+    backend:
+      serviceName: other
+      servicePort: 8080
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: haproxy
+    namespace: ingress
+  data:
+    timeout-client: 10s
+`
+
+func TestRender(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	manifestsDir := tempdir + "/manifests"
+	outputDir := tempdir + "/output"
+	if err := os.Mkdir(manifestsDir, 0755); err != nil {
+		t.Fatalf("error creating manifests dir: %v", err)
+	}
+	for name, content := range map[string]string{
+		"app.yaml":     manifestApp,
+		"ingress.yml":  manifestIngress,
+		"README.md":    "ignored",
+		"invalid.conf": "ignored",
+	} {
+		if err := ioutil.WriteFile(manifestsDir+"/"+name, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing manifest: %v", err)
+		}
+	}
+	logger := &types_helper.LoggerMock{T: t}
+	err = Render(logger, &Options{
+		Manifests:     []string{manifestsDir},
+		OutputDir:     outputDir,
+		TemplatesDir:  "../../rootfs/etc/haproxy",
+		ConfigMapName: "ingress/haproxy",
+		IngressClass:  "haproxy",
+	})
+	if err != nil {
+		t.Fatalf("error rendering: %v", err)
+	}
+	logger.CompareLogging(`
+INFO-V(2) ignoring object of unsupported kind 'Deployment'
+INFO-V(2) using auto generated fake certificate`)
+	config, err := ioutil.ReadFile(outputDir + "/haproxy.cfg")
+	if err != nil {
+		t.Fatalf("error reading haproxy.cfg: %v", err)
+	}
+	for _, expected := range []string{
+		"    timeout client          10s\n",
+		"backend default_echo_8080\n    mode http\n    balance leastconn\n    server 172.17.0.11:8080 172.17.0.11:8080 weight 1\n",
+		"crt-list " + outputDir + "/maps/https-front_echo.local_bind__public_crt.list",
+	} {
+		if !strings.Contains(string(config), expected) {
+			t.Errorf("expected haproxy.cfg with '%s'", expected)
+		}
+	}
+	if strings.Contains(string(config), "default_other_8080") {
+		t.Errorf("ingress of another class should not be rendered")
+	}
+	crtList, err := ioutil.ReadFile(outputDir + "/maps/https-front_echo.local_bind__public_crt.list")
+	if err != nil {
+		t.Fatalf("error reading crt-list: %v", err)
+	}
+	if !strings.Contains(string(crtList), outputDir+"/ssl/default-fake-certificate.pem") {
+		t.Errorf("expected crt-list with the fake certificate, found: %s", crtList)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	testCases := []struct {
+		manifest string
+		options  Options
+		expected string
+	}{
+		// 0
+		{
+			manifest: "kind: [",
+			expected: "error reading '%s': ",
+		},
+		// 1
+		{
+			manifest: manifestApp,
+			options:  Options{ConfigMapName: "ingress/haproxy"},
+			expected: "configmap not found: 'ingress/haproxy'",
+		},
+		// 2
+		{
+			manifest: manifestApp,
+			options:  Options{DefaultSSLCertificate: "default/tls"},
+			expected: "secret not found: 'default/tls'",
+		},
+	}
+	for i, test := range testCases {
+		tempdir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("error creating tempdir: %v", err)
+		}
+		manifest := tempdir + "/manifest.yaml"
+		if err := ioutil.WriteFile(manifest, []byte(test.manifest), 0644); err != nil {
+			t.Fatalf("error writing manifest: %v", err)
+		}
+		options := test.options
+		options.Manifests = []string{manifest}
+		options.OutputDir = tempdir + "/output"
+		options.TemplatesDir = "../../rootfs/etc/haproxy"
+		err = Render(&types_helper.LoggerMock{T: t}, &options)
+		expected := strings.Replace(test.expected, "%s", manifest, -1)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%d: expected error '%s' but was '%v'", i, expected, err)
+		}
+		os.RemoveAll(tempdir)
+	}
+}
+
+func TestCacheBundleAndCRL(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+	ca := ssl_helper.CreateCA("ca")
+	crt := ssl_helper.CreateCert("echo.local", "", ca)
+	m := newManifests()
+	m.secrets["default/tls"] = &api.Secret{Data: map[string][]byte{
+		"tls-ecdsa.crt": crt.PEM(),
+		"tls-ecdsa.key": crt.KeyPEM(),
+	}}
+	m.secrets["default/ca"] = &api.Secret{Data: map[string][]byte{
+		"ca.crt": ca.PEM(),
+		"ca.crl": ssl_helper.CreateCRL(ca, time.Now().Add(time.Hour)),
+	}}
+	m.secrets["default/invalid-crl"] = &api.Secret{Data: map[string][]byte{
+		"ca.crt": ca.PEM(),
+		"ca.crl": ca.PEM(),
+	}}
+	c := newCache(m, tempdir+"/ssl")
+
+	bundle, err := c.GetTLSSecretPath("default/tls")
+	if err != nil {
+		t.Fatalf("error creating bundle: %v", err)
+	}
+	if expected := tempdir + "/ssl/default_tls_bundle.pem"; bundle.Filename != expected {
+		t.Errorf("expected bundle '%s' but was '%s'", expected, bundle.Filename)
+	}
+	if _, err := os.Stat(bundle.Filename + ".ecdsa"); err != nil {
+		t.Errorf("expected .ecdsa file of the bundle: %v", err)
+	}
+	hash := sha1.Sum(append(append(crt.PEM(), '\n'), crt.KeyPEM()...))
+	if expected := fmt.Sprintf("%x", hash); bundle.SHA1Hash != expected {
+		t.Errorf("expected bundle hash '%s' but was '%s'", expected, bundle.SHA1Hash)
+	}
+
+	_, crl, err := c.GetCASecretPath("default/ca")
+	if err != nil {
+		t.Fatalf("error creating CA files: %v", err)
+	}
+	if expected := tempdir + "/ssl/crl-default_ca.pem"; crl.Filename != expected {
+		t.Errorf("expected CRL '%s' but was '%s'", expected, crl.Filename)
+	}
+	if _, _, err := c.GetCASecretPath("default/invalid-crl"); err == nil {
+		t.Errorf("expected an error on invalid CRL")
+	}
+}
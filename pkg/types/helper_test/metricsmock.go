@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper_test
+
+import (
+	"time"
+)
+
+// MetricsMock ...
+type MetricsMock struct {
+	Observed         []string
+	ConfigSize       [4]int
+	AnnotationErrors map[string]int
+}
+
+// ObserveSync ...
+func (m *MetricsMock) ObserveSync(duration time.Duration) {
+	m.Observed = append(m.Observed, "sync")
+}
+
+// ObserveConverter ...
+func (m *MetricsMock) ObserveConverter(duration time.Duration) {
+	m.Observed = append(m.Observed, "converter")
+}
+
+// ObserveRender ...
+func (m *MetricsMock) ObserveRender(duration time.Duration) {
+	m.Observed = append(m.Observed, "render")
+}
+
+// ObserveCheck ...
+func (m *MetricsMock) ObserveCheck(duration time.Duration) {
+	m.Observed = append(m.Observed, "check")
+}
+
+// ObserveReload ...
+func (m *MetricsMock) ObserveReload(duration time.Duration) {
+	m.Observed = append(m.Observed, "reload")
+}
+
+// SetConfigSize ...
+func (m *MetricsMock) SetConfigSize(hosts, backends, endpoints, userlists int) {
+	m.ConfigSize = [4]int{hosts, backends, endpoints, userlists}
+}
+
+// SetAnnotationErrors ...
+func (m *MetricsMock) SetAnnotationErrors(errors map[string]int) {
+	m.AnnotationErrors = errors
+}
@@ -24,3 +24,49 @@ type Logger interface {
 	Error(msg string, args ...interface{})
 	Fatal(msg string, args ...interface{})
 }
+
+// LogFields are the structured fields of a log message
+type LogFields struct {
+	SourceType      string
+	SourceNamespace string
+	SourceName      string
+	Backend         string
+	Hostname        string
+}
+
+// FieldLogger is a Logger which reports structured fields along with the message
+type FieldLogger interface {
+	Logger
+	// WithFields returns a logger which adds fields to the messages,
+	// empty fields don't override the ones already assigned
+	WithFields(fields LogFields) Logger
+}
+
+// WithFields returns a logger which adds fields to the messages
+// if logger supports them, otherwise returns logger itself
+func WithFields(logger Logger, fields LogFields) Logger {
+	if l, ok := logger.(FieldLogger); ok {
+		return l.WithFields(fields)
+	}
+	return logger
+}
+
+// Merge returns a copy of f with the non empty fields of other
+func (f LogFields) Merge(other LogFields) LogFields {
+	if other.SourceType != "" {
+		f.SourceType = other.SourceType
+	}
+	if other.SourceNamespace != "" {
+		f.SourceNamespace = other.SourceNamespace
+	}
+	if other.SourceName != "" {
+		f.SourceName = other.SourceName
+	}
+	if other.Backend != "" {
+		f.Backend = other.Backend
+	}
+	if other.Hostname != "" {
+		f.Hostname = other.Hostname
+	}
+	return f
+}
@@ -16,7 +16,17 @@ limitations under the License.
 
 package types
 
-// BindUtils ...
-type BindUtils interface {
-	CreateX509CertsDir(bindName string, certs []string) (string, error)
+import (
+	"time"
+)
+
+// Metrics ...
+type Metrics interface {
+	ObserveSync(duration time.Duration)
+	ObserveConverter(duration time.Duration)
+	ObserveRender(duration time.Duration)
+	ObserveCheck(duration time.Duration)
+	ObserveReload(duration time.Duration)
+	SetConfigSize(hosts, backends, endpoints, userlists int)
+	SetAnnotationErrors(errors map[string]int)
 }
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/mitchellh/mapstructure"
+	"io/ioutil"
 	"net"
 	"strconv"
 )
@@ -93,3 +94,22 @@ func SendToSocket(socket string, command string) error {
 	}
 	return nil
 }
+
+// HAProxyCommand sends a command to the HAProxy's stats socket
+// and returns the whole response
+func HAProxyCommand(socket string, command string) (string, error) {
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(command + "\n")); err != nil {
+		return "", err
+	}
+	// HAProxy closes the connection after the response of a non interactive command
+	out, err := ioutil.ReadAll(c)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jcmoraisjr/haproxy-ingress/pkg/types"
+)
+
+// Validator ...
+type Validator interface {
+	// ValidateIngress returns the problems found if ing is applied
+	ValidateIngress(ing *extensions.Ingress) []string
+	// ValidateConfigMap returns the problems found if configMap is applied
+	ValidateConfigMap(configMap *api.ConfigMap) []string
+}
+
+// Server ...
+type Server interface {
+	Listen(stopCh <-chan struct{}) error
+}
+
+// Options ...
+type Options struct {
+	BindAddress string
+	CertFile    string
+	KeyFile     string
+	Validator   Validator
+}
+
+// NewServer creates the HTTPS server of the validating admission webhook.
+// Ingress and ConfigMap resources are sent by the API server before being
+// persisted, and are rejected if the validator finds a problem.
+func NewServer(logger types.Logger, options Options) Server {
+	return &server{
+		logger:  logger,
+		options: options,
+	}
+}
+
+type server struct {
+	logger  types.Logger
+	options Options
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	review := &AdmissionReview{}
+	err := json.NewDecoder(r.Body).Decode(review)
+	if err == nil && review.Request == nil {
+		err = fmt.Errorf("missing request")
+	}
+	if err != nil {
+		s.logger.Warn("webhook: invalid admission review: %v", err)
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+	review.Response = s.review(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		s.logger.Error("webhook: error writing admission review: %v", err)
+	}
+}
+
+func (s *server) review(req *AdmissionRequest) *AdmissionResponse {
+	resp := &AdmissionResponse{
+		UID:     req.UID,
+		Allowed: true,
+	}
+	if req.Operation == "DELETE" {
+		return resp
+	}
+	var problems []string
+	var err error
+	name := req.Name
+	switch req.Kind.Kind {
+	case "Ingress":
+		ing := &extensions.Ingress{}
+		if err = json.Unmarshal(req.Object.Raw, ing); err == nil {
+			ing.Namespace = req.Namespace
+			name = ing.Name
+			problems = s.options.Validator.ValidateIngress(ing)
+		}
+	case "ConfigMap":
+		configMap := &api.ConfigMap{}
+		if err = json.Unmarshal(req.Object.Raw, configMap); err == nil {
+			configMap.Namespace = req.Namespace
+			name = configMap.Name
+			problems = s.options.Validator.ValidateConfigMap(configMap)
+		}
+	default:
+		return resp
+	}
+	source := fmt.Sprintf("%s '%s/%s'", strings.ToLower(req.Kind.Kind), req.Namespace, name)
+	if err != nil {
+		problems = []string{fmt.Sprintf("error decoding object: %v", err)}
+	}
+	if len(problems) == 0 {
+		s.logger.InfoV(2, "webhook: %s accepted", source)
+		return resp
+	}
+	msg := fmt.Sprintf("%s rejected: %s", source, strings.Join(problems, "; "))
+	s.logger.Info("webhook: %s", msg)
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonInvalid,
+		Code:    http.StatusUnprocessableEntity,
+		Message: msg,
+	}
+	return resp
+}
+
+func (s *server) Listen(stopCh <-chan struct{}) error {
+	cert, err := tls.LoadX509KeyPair(s.options.CertFile, s.options.KeyFile)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", s.options.BindAddress)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Handler: s,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	go func() {
+		<-stopCh
+		httpServer.Close()
+	}()
+	go func() {
+		if err := httpServer.ServeTLS(l, "", ""); err != http.ErrServerClosed {
+			s.logger.Error("webhook: error serving admission reviews: %v", err)
+		}
+	}()
+	return nil
+}
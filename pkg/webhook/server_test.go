@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The HAProxy Ingress Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	extensions "k8s.io/api/extensions/v1beta1"
+
+	types_helper "github.com/jcmoraisjr/haproxy-ingress/pkg/types/helper_test"
+)
+
+type validatorMock struct {
+	problems map[string][]string
+}
+
+func (v *validatorMock) ValidateIngress(ing *extensions.Ingress) []string {
+	return v.problems[ing.Namespace+"/"+ing.Name]
+}
+
+func (v *validatorMock) ValidateConfigMap(configMap *api.ConfigMap) []string {
+	return v.problems[configMap.Namespace+"/"+configMap.Name]
+}
+
+func TestReview(t *testing.T) {
+	testCases := []struct {
+		request  string
+		allowed  bool
+		message  string
+		logging  string
+		httpCode int
+	}{
+		// 0
+		{
+			request:  `{"kind":{"kind":"Ingress"},"namespace":"default","operation":"CREATE","object":{"metadata":{"name":"echo1"}}}`,
+			allowed:  true,
+			logging:  `INFO-V(2) webhook: ingress 'default/echo1' accepted`,
+			httpCode: http.StatusOK,
+		},
+		// 1
+		{
+			request:  `{"kind":{"kind":"Ingress"},"namespace":"default","operation":"UPDATE","object":{"metadata":{"name":"echo2"}}}`,
+			allowed:  false,
+			message:  "ingress 'default/echo2' rejected: invalid annotation; path conflict",
+			logging:  `INFO webhook: ingress 'default/echo2' rejected: invalid annotation; path conflict`,
+			httpCode: http.StatusOK,
+		},
+		// 2
+		{
+			request:  `{"kind":{"kind":"Ingress"},"namespace":"default","operation":"DELETE","name":"echo2"}`,
+			allowed:  true,
+			httpCode: http.StatusOK,
+		},
+		// 3
+		{
+			request:  `{"kind":{"kind":"ConfigMap"},"namespace":"ingress","operation":"UPDATE","object":{"metadata":{"name":"haproxy"}}}`,
+			allowed:  false,
+			message:  "configmap 'ingress/haproxy' rejected: invalid option",
+			logging:  `INFO webhook: configmap 'ingress/haproxy' rejected: invalid option`,
+			httpCode: http.StatusOK,
+		},
+		// 4
+		{
+			request:  `{"kind":{"kind":"Secret"},"namespace":"default","operation":"CREATE","object":{"metadata":{"name":"tls"}}}`,
+			allowed:  true,
+			httpCode: http.StatusOK,
+		},
+		// 5
+		{
+			request:  "",
+			logging:  `WARN webhook: invalid admission review: missing request`,
+			httpCode: http.StatusBadRequest,
+		},
+	}
+	for i, test := range testCases {
+		logger := &types_helper.LoggerMock{T: t}
+		s := NewServer(logger, Options{
+			Validator: &validatorMock{problems: map[string][]string{
+				"default/echo2":   {"invalid annotation", "path conflict"},
+				"ingress/haproxy": {"invalid option"},
+			}},
+		}).(*server)
+		body := `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview"}`
+		if test.request != "" {
+			body = `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":` + test.request + `}`
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if w.Code != test.httpCode {
+			t.Errorf("%d: expected http code %d but was %d", i, test.httpCode, w.Code)
+		}
+		if w.Code == http.StatusOK {
+			review := &AdmissionReview{}
+			if err := json.Unmarshal(w.Body.Bytes(), review); err != nil || review.Response == nil {
+				t.Errorf("%d: error reading response: %v", i, err)
+				continue
+			}
+			if review.Response.Allowed != test.allowed {
+				t.Errorf("%d: expected allowed '%v' but was '%v'", i, test.allowed, review.Response.Allowed)
+			}
+			var message string
+			if review.Response.Result != nil {
+				message = review.Response.Result.Message
+			}
+			if message != test.message {
+				t.Errorf("%d: expected message '%s' but was '%s'", i, test.message, message)
+			}
+		}
+		logger.CompareLogging(test.logging)
+	}
+}
@@ -0,0 +1,11 @@
+-- cert_pem converts the base64 encoded DER of a certificate, e.g.
+-- ssl_c_der,base64, to an URL encoded PEM. The chars +/= of base64 are
+-- encoded here, a regsub() would need brackets which end the %[] of a
+-- log-format expression.
+
+local urlenc = { ["+"] = "%2B", ["/"] = "%2F", ["="] = "%3D" }
+
+core.register_converters("cert_pem", function(der)
+    local body = der:gsub("[+/=]", urlenc)
+    return "-----BEGIN%20CERTIFICATE-----%0A" .. body .. "%0A-----END%20CERTIFICATE-----%0A"
+end)